@@ -0,0 +1,39 @@
+// Заглушка системы расчёта начислений для локального запуска gophermart без внешнего бинарного файла.
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"gophermart/internal/accrual/accrualtest"
+	"gophermart/internal/logger"
+	"gophermart/internal/models"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger.Init()
+
+	address := flag.String("a", "127.0.0.1:8081", "адрес и порт запуска заглушки host:port")
+	pollsPerStage := flag.Int("stage", 1, "число опросов заказа до перехода в следующий статус")
+	autoRegister := flag.Bool("auto", false, "регистрировать неизвестные заказы при первом опросе")
+	autoAccrual := flag.String("auto-accrual", "100", "начисление за автоматически зарегистрированный заказ")
+	flag.Parse()
+
+	accrual, err := models.ParseMoney(*autoAccrual)
+	if err != nil {
+		logger.Logger.Fatal("Неверное начисление за автоматически зарегистрированный заказ", zap.Error(err))
+	}
+
+	service := accrualtest.NewService()
+	service.PollsPerStage = *pollsPerStage
+	service.AutoRegister = *autoRegister
+	service.AutoAccrual = accrual
+
+	logger.Logger.Info("Заглушка системы расчёта запущена", zap.String("address", *address))
+	err = http.ListenAndServe(*address, service)
+	if err != nil {
+		logger.Logger.Fatal(err.Error())
+	}
+}
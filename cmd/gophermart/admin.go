@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"gophermart/internal/auth"
+	"gophermart/internal/handlers"
+	"gophermart/internal/models"
+	"gophermart/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const urlGetAdminUser = "/users/{login}"                        // сведения о пользователе;
+const urlAdminUserBalance = "/users/{login}/balance"            // баланс пользователя и его корректировка;
+const urlGetAdminUserOrders = "/users/{login}/orders"           // заказы пользователя;
+const urlGetAdminUserWithdrawals = "/users/{login}/withdrawals" // списания пользователя;
+const urlGetAdminUserLedger = "/users/{login}/ledger"           // проводки пользователя;
+const urlPostAdminUserUnlock = "/users/{login}/unlock"          // разблокировка входа;
+const urlPutAdminUserRole = "/users/{login}/role"               // назначение роли;
+const urlGetAdminDeadLetter = "/orders/deadletter"              // заказы в dead-letter;
+const urlPostAdminOrderRequeue = "/orders/{number}/requeue"     // возврат заказа в очередь;
+const urlGetAdminEvents = "/events"                             // журнал аудита изменений.
+
+// adminRoutes маршруты /api/admin. Поддержке доступны просмотр, разблокировка и возврат заказов в очередь,
+// корректировка баланса, назначение ролей и журнал аудита — только администраторам.
+func adminRoutes(r chi.Router, storage *store.StorageContext) {
+	r.Get(urlGetAdminUser, func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAdminUser(w, r, storage)
+	})
+	r.Get(urlAdminUserBalance, func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAdminUserBalance(w, r, storage)
+	})
+	r.Get(urlGetAdminUserOrders, func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAdminUserOrders(w, r, storage)
+	})
+	r.Get(urlGetAdminUserWithdrawals, func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAdminUserWithdrawals(w, r, storage)
+	})
+	r.Get(urlGetAdminUserLedger, func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAdminUserLedger(w, r, storage)
+	})
+	r.Post(urlPostAdminUserUnlock, func(w http.ResponseWriter, r *http.Request) {
+		handlers.PostAdminUserUnlock(w, r, storage)
+	})
+	r.Get(urlGetAdminDeadLetter, func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAdminDeadLetterOrders(w, r, storage)
+	})
+	r.Post(urlPostAdminOrderRequeue, func(w http.ResponseWriter, r *http.Request) {
+		handlers.PostAdminOrderRequeue(w, r, storage)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireRole(models.RoleAdmin))
+
+		r.Post(urlAdminUserBalance, func(w http.ResponseWriter, r *http.Request) {
+			handlers.PostAdminUserBalance(w, r, storage)
+		})
+		r.Put(urlPutAdminUserRole, func(w http.ResponseWriter, r *http.Request) {
+			handlers.PutAdminUserRole(w, r, storage)
+		})
+		r.Get(urlGetAdminEvents, func(w http.ResponseWriter, r *http.Request) {
+			handlers.GetAdminEvents(w, r, storage)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gophermart/internal/configure"
+	"gophermart/internal/store"
+)
+
+const deadLetterUsage = "использование: gophermart deadletter list|requeue [-d адрес базы данных] [номер заказа...]"
+
+// runDeadLetter выполняет подкоманду deadletter и возвращает код завершения процесса
+func runDeadLetter(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, deadLetterUsage)
+		return 2
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("deadletter "+action, flag.ContinueOnError)
+	if !cfg.ReadCommandParams(fs, args[1:]) || cfg.DatabaseDriver() == configure.DatabaseDriverMemory {
+		fmt.Fprintln(os.Stderr, deadLetterUsage)
+		fs.PrintDefaults()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	storage := newStorage()
+
+	switch action {
+	case "list":
+		orders, err := storage.GetDeadLetterOrders(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "не удалось получить заказы:", err)
+			return 1
+		}
+		for _, order := range orders {
+			fmt.Printf("%s  %-20s %-10s попыток: %-4d %s  %s\n",
+				order.Number, order.Login, order.Status, order.Attempts, order.DeadLetteredAt.Format(time.RFC3339), order.LastError)
+		}
+	case "requeue":
+		if fs.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, deadLetterUsage)
+			return 2
+		}
+		code := 0
+		for _, arg := range fs.Args() {
+			number, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "неверный номер заказа:", arg)
+				code = 1
+				continue
+			}
+			err = storage.RequeueOrder(ctx, number)
+			if errors.Is(err, store.ErrOrderNotFound) {
+				fmt.Fprintln(os.Stderr, "заказ не в dead-letter:", arg)
+				code = 1
+				continue
+			} else if err != nil {
+				fmt.Fprintln(os.Stderr, "не удалось вернуть заказ в очередь:", err)
+				return 1
+			}
+			fmt.Println("заказ возвращён в очередь:", arg)
+		}
+		return code
+	default:
+		fmt.Fprintln(os.Stderr, deadLetterUsage)
+		return 2
+	}
+	return 0
+}
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	_ "gophermart/docs"
 	"gophermart/internal/accrual"
+	"gophermart/internal/auth"
 	"gophermart/internal/configure"
 	"gophermart/internal/handlers"
 	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
+	"gophermart/internal/models"
 	"gophermart/internal/store"
-	"gophermart/internal/store/pg"
+	"gophermart/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -21,53 +31,166 @@ import (
 	"go.uber.org/zap"
 )
 
-const urlPostUserRegister = "/api/user/register"                // регистрация пользователя
-const urlPostUserLogin = "/api/user/login"                      // аутентификация пользователя;
-const urlPostUserOrders = "/api/user/orders"                    // загрузка пользователем номера заказа для расчёта;
-const urlGetUserOrders = "/api/user/orders"                     // получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях;
-const urlGetUserBalance = "/api/user/balance"                   // получение текущего баланса счёта баллов лояльности пользователя;
-const urlPostUserBalanceWithdraw = "/api/user/balance/withdraw" // запрос на списание баллов с накопительного счёта в счёт оплаты нового заказа;
-const urlGetUserWithdrawals = "/api/user/withdrawals"           // получение информации о выводе средств с накопительного счёта пользователем.
+const urlPostUserRegister = "/api/user/register"                           // регистрация пользователя
+const urlPostUserLogin = "/api/user/login"                                 // аутентификация пользователя;
+const urlPostUserOrders = "/api/user/orders"                               // загрузка пользователем номера заказа для расчёта;
+const urlGetUserOrders = "/api/user/orders"                                // получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях;
+const urlGetUserBalance = "/api/user/balance"                              // получение текущего баланса счёта баллов лояльности пользователя;
+const urlPostUserBalanceWithdraw = "/api/user/balance/withdraw"            // запрос на списание баллов с накопительного счёта в счёт оплаты нового заказа;
+const urlGetUserWithdrawals = "/api/user/withdrawals"                      // получение информации о выводе средств с накопительного счёта пользователем.
+const urlPostUserTokenRefresh = "/api/user/token/refresh"                  // обмен токена обновления на новую пару токенов;
+const urlPostUserLogout = "/api/user/logout"                               // выход пользователя с отзывом токенов;
+const urlPostUserPassword = "/api/user/password"                           // смена пароля;
+const urlPostUserPasswordReset = "/api/user/password/reset"                // запрос токена сброса пароля;
+const urlPostUserPasswordResetConfirm = "/api/user/password/reset/confirm" // сброс пароля по токену;
+const urlAdmin = "/api/admin"                                              // API сотрудников, маршруты в admin.go;
+const urlPostAccrualCallback = "/internal/accrual/callback"                // уведомление системы расчёта об изменении статуса заказа;
+const urlGetHealthz = "/healthz"                                           // проверка, что процесс жив;
+const urlGetReadyz = "/readyz"                                             // проверка готовности принимать запросы.
 
 var cfg configure.Config
 
-var tokenAuth *jwtauth.JWTAuth
+// pollInterval период опроса системы расчёта, меняется по SIGHUP
+var pollInterval atomic.Int64
 
 // @securityDefinitions.apikey Bearer
 // @in header
 // @name Authorization
 // @description Type "Bearer" followed by a space and JWT token.
 func main() {
-	jobs := make(chan int64, 10)
-
 	logger.Init()
-	ok := cfg.ReadStartParams()
-	if !ok {
-		flag.PrintDefaults()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "deadletter":
+			os.Exit(runDeadLetter(os.Args[2:]))
+		case "user":
+			os.Exit(runUser(os.Args[2:]))
+		}
+	}
+
+	err := cfg.ReadStartParams(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		os.Exit(0)
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "не удалось прочитать настройки:", err)
+		os.Exit(2)
+	}
+	// --print-config печатает и неверные настройки, чтобы было видно, откуда взялось значение
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "неверные настройки:\n"+err.Error())
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		os.Exit(0)
+	}
+	if err := logger.Configure(cfg.LogLevel, cfg.LogFormat); err != nil {
+		logger.Logger.Fatal("Неверные настройки журнала", zap.Error(err))
+	}
+
+	// корневой контекст сервиса отменяется по SIGINT или SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// SIGHUP перечитывает настройки, ловим его до запуска сервера, чтобы он не завершил процесс
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
+	shutdownTracing, err := tracing.Init(ctx, tracing.Config{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		logger.Logger.Fatal("Не удалось настроить трассировку", zap.Error(err))
+	}
+
+	db := newStorage()
 	storage := &store.StorageContext{}
-	storage.SetStorage(pg.NewDatabase(cfg.DatabaseURI))
+	storage.SetStorage(db)
 
-	tokenAuth = jwtauth.New("HS256", []byte("secret"), nil)
+	tokens := newTokens()
+	loginGuard := auth.NewLoginGuard(storage, loginPolicy(&cfg))
+	passwordPolicy := auth.PasswordPolicy{
+		MinLength:      cfg.PasswordMinLength,
+		RequireUpper:   cfg.PasswordRequireUpper,
+		RequireLower:   cfg.PasswordRequireLower,
+		RequireDigit:   cfg.PasswordRequireDigit,
+		RequireSpecial: cfg.PasswordRequireSpecial,
+	}
+	notifier := newNotifier()
+	accrualClient := accrual.NewClient(cfg.AccrualSystemAddress, accrual.BreakerConfig{
+		FailureThreshold: cfg.AccrualBreakerFailures,
+		OpenTimeout:      cfg.AccrualBreakerOpenTimeout,
+		HalfOpenRequests: cfg.AccrualBreakerHalfOpenRequests,
+	})
+	if cfg.AccrualRateLimit > 0 {
+		accrualClient.SetRateLimit(cfg.AccrualRateLimit)
+	}
+	pollInterval.Store(int64(cfg.PollInterval()))
+	handlers.SetTimeouts(cfg.HTTPTimeout, cfg.HTTPLongTimeout)
+	readiness := handlers.NewReadiness(storage, accrualClient.Breaker())
+	if m, ok := db.(migratable); ok {
+		migrator, err := m.Migrator()
+		if err != nil {
+			logger.Logger.Fatal("Не удалось загрузить миграции", zap.Error(err))
+		}
+		readiness.CheckSchema(migrator)
+	}
 
 	r := chi.NewRouter()
-	r.Use(middleware.Compress(5, "application/json", "text/html"))
+	r.Use(tracing.Middleware)
+	r.Use(metrics.Middleware)
+	r.Use(middleware.RequestID)
+	r.Use(logger.Middleware)
+	r.Use(handlers.AuditRequestID)
+	r.Use(middleware.Compress(cfg.HTTPCompressLevel, "application/json", "text/html"))
 
-	logger.Logger.Info("Сервер запущен", zap.String("адрес", cfg.RunAddress))
-	logger.Logger.Info(cfg.AccrualSystemAddress)
+	logger.Logger.Info("Сервер запущен", zap.String("address", cfg.RunAddress))
 
 	r.Mount("/swagger", httpSwagger.Handler())
+	r.Handle("/metrics", metrics.Handler())
+	r.Get(urlGetHealthz, handlers.GetHealthz)
+	r.Get(urlGetReadyz, func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetReadyz(w, r, readiness)
+	})
 	r.Post(urlPostUserRegister, func(w http.ResponseWriter, r *http.Request) {
-		handlers.PostUserRegister(w, r, storage, tokenAuth)
+		handlers.PostUserRegister(w, r, storage, tokens, passwordPolicy)
 	})
 	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
-		handlers.PostUserLogin(w, r, storage, tokenAuth)
+		handlers.PostUserLogin(w, r, storage, tokens, loginGuard)
+	})
+	r.Post(urlPostUserTokenRefresh, func(w http.ResponseWriter, r *http.Request) {
+		handlers.PostUserTokenRefresh(w, r, storage, tokens)
 	})
+	if notifier != nil {
+		r.Post(urlPostUserPasswordReset, func(w http.ResponseWriter, r *http.Request) {
+			handlers.PostUserPasswordReset(w, r, storage, notifier, cfg.PasswordResetTTL)
+		})
+		r.Post(urlPostUserPasswordResetConfirm, func(w http.ResponseWriter, r *http.Request) {
+			handlers.PostUserPasswordResetConfirm(w, r, storage, passwordPolicy)
+		})
+	}
 	r.Group(func(r chi.Router) {
-		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(tokens.Verifier)
+		r.Use(auth.RejectRevoked(storage.IsAccessTokenRevoked))
 		r.Use(jwtauth.Authenticator)
+		r.Use(handlers.AuditActor)
+		r.Use(handlers.LogLogin)
+
+		r.Post(urlPostUserLogout, func(w http.ResponseWriter, r *http.Request) {
+			handlers.PostUserLogout(w, r, storage)
+		})
+		r.Post(urlPostUserPassword, func(w http.ResponseWriter, r *http.Request) {
+			handlers.PostUserPassword(w, r, storage, tokens, passwordPolicy)
+		})
 
 		r.Post(urlPostUserOrders, func(w http.ResponseWriter, r *http.Request) {
 			handlers.PostUserOrders(w, r, storage)
@@ -84,23 +207,100 @@ func main() {
 		r.Get(urlGetUserWithdrawals, func(w http.ResponseWriter, r *http.Request) {
 			handlers.GetUserWithdrawals(w, r, storage)
 		})
+
+		// изменения сотрудников записываются в журнал аудита в той же транзакции, что и сами изменения
+		r.Route(urlAdmin, func(r chi.Router) {
+			r.Use(auth.RequireRole(models.RoleSupport, models.RoleAdmin))
+			adminRoutes(r, storage)
+		})
 	})
+	if cfg.AccrualCallbackSecret != "" {
+		secret := []byte(cfg.AccrualCallbackSecret)
+		r.Post(urlPostAccrualCallback, func(w http.ResponseWriter, r *http.Request) {
+			handlers.PostAccrualCallback(w, r, storage, secret)
+		})
+		logger.Logger.Info("Уведомления системы расчёта включены", zap.Duration("reconcile_interval", cfg.PollInterval()))
+	}
+	server := &http.Server{Addr: cfg.RunAddress, Handler: r}
 	go func() {
-		if err := http.ListenAndServe(cfg.RunAddress, r); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Logger.Fatal(err.Error())
 		}
 	}()
 
-	for w := 1; w <= 10; w++ {
+	replicaID := accrual.NewWorkerID()
+	logger.Logger.Info("Заказы арендуются под идентификатором реплики", zap.String("replica", replicaID))
+	go reloadOnSignal(ctx, hup, loginGuard, accrualClient)
+
+	jobs := make(chan models.AccrualJob, cfg.AccrualQueueSize)
+	var workers sync.WaitGroup
+	for w := 1; w <= cfg.AccrualWorkers; w++ {
+		workers.Add(1)
 		go func(workerID int) {
-			accrual.UpdateStatusOrdersWorker(workerID, storage, cfg.AccrualSystemAddress, jobs)
+			defer workers.Done()
+			accrual.UpdateStatusOrdersWorker(ctx, workerID, replicaID, storage, accrualClient, accrual.DefaultBackoff, jobs)
 		}(w)
 	}
 
+	dispatchOrders(ctx, storage, accrualClient, replicaID, jobs)
+	// повторный сигнал завершает процесс сразу, не дожидаясь остановки
+	stop()
+
+	readiness.Drain()
+	if cfg.ShutdownDrainDelay > 0 {
+		logger.Logger.Info("Реплика снимается с балансировки", zap.Duration("delay", cfg.ShutdownDrainDelay))
+		time.Sleep(cfg.ShutdownDrainDelay)
+	}
+
+	logger.Logger.Info("Остановка сервиса", zap.Duration("timeout", cfg.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Logger.Warn("Не все запросы завершены до остановки сервера", zap.Error(err))
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-shutdownCtx.Done():
+		logger.Logger.Warn("Не все воркеры завершились, аренда их заказов истечёт сама")
+	}
+
+	closeStorage(db)
+	err = shutdownTracing(shutdownCtx)
+	if err != nil {
+		logger.Logger.Warn("Не удалось выгрузить span'ы", zap.Error(err))
+	}
+	logger.Logger.Info("Сервис остановлен")
+}
+
+// dispatchOrders раз в период опроса арендует заказы и раздаёт их воркерам через jobs.
+// После отмены ctx перестаёт арендовать заказы и закрывает jobs.
+func dispatchOrders(ctx context.Context, storage *store.StorageContext, client *accrual.Client, replicaID string, jobs chan<- models.AccrualJob) {
+	defer close(jobs)
+	timer := time.NewTimer(time.Duration(pollInterval.Load()))
+	defer timer.Stop()
 	for {
-		time.Sleep(1 * time.Second)
-		for _, metrics := range accrual.PrepareBatch(storage) {
-			jobs <- metrics
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		timer.Reset(time.Duration(pollInterval.Load()))
+
+		// арендуем не больше, чем поместится в очередь, чтобы аренда не истекала, пока заказ ждёт воркера
+		free := cap(jobs) - len(jobs)
+		if free == 0 {
+			continue
+		}
+		for _, job := range accrual.PrepareBatch(ctx, storage, client, replicaID, free) {
+			jobs <- job
 		}
 	}
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gophermart/internal/configure"
+	"gophermart/internal/store/migrate"
+	"gophermart/internal/store/pg"
+	"gophermart/internal/store/sqlite"
+)
+
+// migratable база данных, схемой которой управляет подкоманда migrate
+type migratable interface {
+	Migrator() (*migrate.Migrator, error)
+	Close()
+}
+
+const migrateUsage = "использование: gophermart migrate up|down|status [-d адрес базы данных] [-n количество]"
+
+// runMigrate выполняет подкоманду migrate и возвращает код завершения процесса
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	steps := fs.Int("n", 1, "количество откатываемых миграций для down")
+	if !cfg.ReadCommandParams(fs, args[1:]) {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		fs.PrintDefaults()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var db migratable
+	var err error
+	switch cfg.DatabaseDriver() {
+	case configure.DatabaseDriverMemory:
+		fmt.Fprintln(os.Stderr, "хранилищу в памяти миграции не нужны")
+		return 2
+	case configure.DatabaseDriverSQLite:
+		db, err = sqlite.Connect(ctx, cfg.DatabaseURI)
+	default:
+		db, err = pg.Connect(ctx, cfg.DatabaseURI)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "не удалось подключиться к базе данных:", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := db.Migrator()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "не удалось загрузить миграции:", err)
+		return 1
+	}
+
+	switch action {
+	case "up":
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "не удалось применить миграции:", err)
+			return 1
+		}
+		fmt.Printf("применено миграций: %d\n", count)
+	case "down":
+		count, err := migrator.Down(ctx, *steps)
+		if errors.Is(err, migrate.ErrNoMigrations) {
+			fmt.Println("нет применённых миграций")
+			return 0
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "не удалось откатить миграции:", err)
+			return 1
+		}
+		fmt.Printf("откачено миграций: %d\n", count)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		for _, status := range statuses {
+			applied := "не применена"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-24s %s\n", status.Version, status.Name, applied)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"gophermart/internal/logger"
+	"gophermart/internal/notify"
+
+	"go.uber.org/zap"
+)
+
+// newNotifier выбирает доставку токенов сброса пароля по PASSWORD_RESET_NOTIFIER.
+// Возвращает nil, если уведомитель не задан: тогда сброс пароля отключён.
+func newNotifier() notify.Notifier {
+	switch cfg.PasswordResetNotifier {
+	case "":
+		logger.Logger.Warn("Сброс пароля отключён, задайте PASSWORD_RESET_NOTIFIER для доставки токенов")
+		return nil
+	case "file":
+		logger.Logger.Info("Токены сброса пароля дописываются в файл", zap.String("file", cfg.PasswordResetFile))
+		return notify.NewFileNotifier(cfg.PasswordResetFile)
+	default:
+		logger.Logger.Fatal("Неизвестный способ доставки токенов сброса пароля", zap.String("notifier", cfg.PasswordResetNotifier))
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"gophermart/internal/accrual"
+	"gophermart/internal/auth"
+	"gophermart/internal/configure"
+	"gophermart/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// loginPolicy ограничения попыток входа из настроек
+func loginPolicy(c *configure.Config) auth.LoginPolicy {
+	return auth.LoginPolicy{
+		Window:      c.LoginWindow,
+		LoginLimit:  c.LoginLimit,
+		IPLimit:     c.LoginIPLimit,
+		MaxFailures: c.LoginMaxFailures,
+		Lockout:     c.LoginLockout,
+	}
+}
+
+// reloadOnSignal перечитывает настройки по сигналу из hup, пока не отменён ctx
+func reloadOnSignal(ctx context.Context, hup <-chan os.Signal, loginGuard *auth.LoginGuard, client *accrual.Client) {
+	current := cfg
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		current = reloadConfig(current, loginGuard, client)
+	}
+}
+
+// reloadConfig перечитывает файл настроек, окружение и флаги и применяет настройки, которые меняются
+// без перезапуска: уровень журнала, ограничения попыток входа, частоту запросов и период опроса системы расчёта.
+// Об остальных изменённых настройках пишет предупреждение. Возвращает действующие настройки.
+func reloadConfig(current configure.Config, loginGuard *auth.LoginGuard, client *accrual.Client) configure.Config {
+	var next configure.Config
+	err := next.ReadStartParams(os.Args[1:])
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		logger.Logger.Warn("Настройки не перечитаны, действуют прежние", zap.Error(err))
+		return current
+	}
+
+	reloadable, restart := current.Changed(&next)
+	if len(restart) > 0 {
+		logger.Logger.Warn("Настройки изменятся только после перезапуска", zap.Strings("settings", restart))
+	}
+	applied := current
+	applied.LogLevel = next.LogLevel
+	applied.LoginWindow, applied.LoginLimit, applied.LoginIPLimit = next.LoginWindow, next.LoginLimit, next.LoginIPLimit
+	applied.LoginMaxFailures, applied.LoginLockout = next.LoginMaxFailures, next.LoginLockout
+	applied.AccrualRateLimit = next.AccrualRateLimit
+	applied.AccrualPollInterval = next.AccrualPollInterval
+
+	// ограничение частоты меняется, только если его поменяли в настройках: иначе пропало бы
+	// ограничение, которое система расчёта назначила ответом 429
+	if applied.AccrualRateLimit != current.AccrualRateLimit {
+		client.SetRateLimit(applied.AccrualRateLimit)
+	}
+	if applied.LogLevel != current.LogLevel {
+		// уровень проверен в Validate
+		_ = logger.SetLevel(applied.LogLevel)
+	}
+	loginGuard.SetPolicy(loginPolicy(&applied))
+	pollInterval.Store(int64(applied.PollInterval()))
+
+	logger.Logger.Info("Настройки перечитаны", zap.Strings("applied", reloadable))
+	return applied
+}
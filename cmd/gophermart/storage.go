@@ -0,0 +1,42 @@
+package main
+
+import (
+	"gophermart/internal/configure"
+	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
+	"gophermart/internal/store"
+	"gophermart/internal/store/memory"
+	"gophermart/internal/store/pg"
+	"gophermart/internal/store/sqlite"
+
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// newStorage открывает хранилище, выбранное схемой DATABASE_URI, и применяет миграции
+func newStorage() store.StorageInterface {
+	switch cfg.DatabaseDriver() {
+	case configure.DatabaseDriverMemory:
+		logger.Logger.Warn("Данные хранятся в памяти и будут потеряны при остановке сервиса")
+		return memory.NewStorage()
+	case configure.DatabaseDriverSQLite:
+		db := sqlite.NewDatabase(cfg.DatabaseURI)
+		metrics.Registry.MustRegister(collectors.NewDBStatsCollector(db.Conn, "gophermart"))
+		return db
+	default:
+		db := pg.NewDatabase(cfg.DatabaseURI)
+		metrics.Registry.MustRegister(metrics.NewPoolCollector(db.Conn))
+		return db
+	}
+}
+
+// closer хранилище, которое держит соединения с базой данных
+type closer interface {
+	Close()
+}
+
+// closeStorage закрывает соединения хранилища, хранилищу в памяти закрывать нечего
+func closeStorage(storage store.StorageInterface) {
+	if c, ok := storage.(closer); ok {
+		c.Close()
+	}
+}
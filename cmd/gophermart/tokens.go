@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+
+	"gophermart/internal/auth"
+	"gophermart/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// newTokens загружает ключи подписи токенов доступа: набор ключей JWT_KEYS_FILE, ключ из JWT_KEY_FILE
+// или секрет JWT_SECRET. Без ключей генерируется случайный секрет, и токены не переживают перезапуск.
+func newTokens() *auth.Tokens {
+	keys, err := loadKeySet()
+	if err != nil {
+		logger.Logger.Fatal("Не удалось загрузить ключи подписи токенов", zap.Error(err))
+	}
+	logger.Logger.Info("Токены подписываются ключом",
+		zap.String("kid", keys.SigningKey().ID), zap.String("algorithm", string(keys.SigningKey().Algorithm)))
+	return auth.NewTokens(keys, cfg.JWTTTL, cfg.JWTRefreshTTL)
+}
+
+func loadKeySet() (*auth.KeySet, error) {
+	if cfg.JWTKeysFile != "" {
+		data, err := os.ReadFile(cfg.JWTKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		return auth.ParseKeySet(data, cfg.JWTSigningKeyID)
+	}
+
+	var key *auth.Key
+	var err error
+	switch {
+	case cfg.JWTKeyFile != "":
+		var data []byte
+		data, err = os.ReadFile(cfg.JWTKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err = auth.ParseKey(cfg.JWTAlgorithm, cfg.JWTSigningKeyID, data)
+	case cfg.JWTSecret != "":
+		key, err = auth.ParseKey(cfg.JWTAlgorithm, cfg.JWTSigningKeyID, []byte(cfg.JWTSecret))
+	default:
+		logger.Logger.Warn("Ключ подписи токенов не задан, токены перестанут действовать после перезапуска")
+		key, err = auth.GenerateKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewKeySet(key)
+}
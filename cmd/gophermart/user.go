@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gophermart/internal/auth"
+	"gophermart/internal/configure"
+	"gophermart/internal/models"
+	"gophermart/internal/store"
+)
+
+const userUsage = "использование: gophermart user unlock [-d адрес базы данных] логин...\n" +
+	"              gophermart user role [-d адрес базы данных] логин user|support|admin"
+
+// runUser выполняет подкоманду user и возвращает код завершения процесса
+func runUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	if !cfg.ReadCommandParams(fs, args[1:]) || cfg.DatabaseDriver() == configure.DatabaseDriverMemory {
+		fmt.Fprintln(os.Stderr, userUsage)
+		fs.PrintDefaults()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	storage := newStorage()
+
+	switch action {
+	case "unlock":
+		if fs.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, userUsage)
+			return 2
+		}
+		code := 0
+		for _, arg := range fs.Args() {
+			login := auth.NormalizeLogin(arg)
+			err := storage.UnlockUser(ctx, login)
+			if errors.Is(err, store.ErrUserNotFound) {
+				fmt.Fprintln(os.Stderr, "пользователь не найден:", login)
+				code = 1
+				continue
+			} else if err != nil {
+				fmt.Fprintln(os.Stderr, "не удалось разблокировать пользователя:", err)
+				return 1
+			}
+			fmt.Println("пользователь разблокирован:", login)
+		}
+		return code
+	case "role":
+		if fs.NArg() != 2 || !models.ValidRole(fs.Arg(1)) {
+			fmt.Fprintln(os.Stderr, userUsage)
+			return 2
+		}
+		login := auth.NormalizeLogin(fs.Arg(0))
+		err := storage.SetUserRole(ctx, login, fs.Arg(1))
+		if errors.Is(err, store.ErrUserNotFound) {
+			fmt.Fprintln(os.Stderr, "пользователь не найден:", login)
+			return 1
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "не удалось назначить роль:", err)
+			return 1
+		}
+		fmt.Println("роль назначена:", login, fs.Arg(1))
+		return 0
+	default:
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+}
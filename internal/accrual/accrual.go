@@ -2,98 +2,164 @@ package accrual
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
 	"gophermart/internal/models"
 	"gophermart/internal/store"
-	"io"
-	"net/http"
-	"strconv"
+	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-const urlGetUserOrders = "%s/api/orders/%d" // получение информации о расчёте начислений баллов лояльности
-
 var ErrStatusNoContent = errors.New("StatusNoContent")
 var ErrStatusTooManyRequests = errors.New("StatusTooManyRequests")
 var ErrStatusInternalServerError = errors.New("StatusInternalServerError")
 
-func PrepareBatch(storage *store.StorageContext) (statusOrders []int64) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// LeaseDuration время, на которое воркер арендует заказ. Если воркер упадёт, не сняв аренду,
+// по её истечении заказ опросит другой воркер или реплика. Перед запросом к системе расчёта воркер
+// продлевает аренду ещё на LeaseDuration, а заказ, чья аренда истекла в очереди, пропускает.
+const LeaseDuration = time.Minute
 
-	statusOrders, err := storage.GetOrdersProcessing(ctx)
+// NewWorkerID возвращает идентификатор реплики, под которым она арендует заказы
+func NewWorkerID() string {
+	hostname, err := os.Hostname()
 	if err != nil {
-		logger.Logger.Warn("Ошибка получения данных о заказах")
-		return statusOrders
+		hostname = "gophermart"
 	}
-	logger.Logger.Info(fmt.Sprintf("%d", statusOrders))
-	return statusOrders
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
 }
 
-func UpdateStatusOrdersWorker(workerID int, storage *store.StorageContext, urlAccrual string, jobs <-chan int64) {
-	ctx := context.Background()
-	for job := range jobs {
-		logger.Logger.Info(fmt.Sprintf("Воркер %d", workerID))
-
-		statusOrder := GetStatus(ctx, job, urlAccrual)
-		if statusOrder != nil {
-			err := storage.UpdateStatusOrders(ctx, statusOrder)
-			if err != nil {
-				logger.Logger.Warn("Ошибка обновления данных", zap.Error(err))
-			}
-		}
-
+// PrepareBatch арендует для workerID не больше limit заказов, ожидающих расчёта начислений,
+// и обновляет метрику глубины очереди. Пока автоматический выключатель client разомкнут или ctx отменён,
+// заказы не арендуются.
+func PrepareBatch(ctx context.Context, storage *store.StorageContext, client *Client, workerID string, limit int) (statusOrders []models.AccrualJob) {
+	if ctx.Err() != nil {
+		return nil
 	}
-}
 
-func GetStatus(ctx context.Context, number int64, urlAccrual string) *models.StatusOrdersAccrual {
-	var statusOrders *models.StatusOrdersAccrual
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "accrual.PrepareBatch", trace.WithAttributes(attribute.Int("gophermart.limit", limit)))
+	defer span.End()
 
-	client := &http.Client{}
-	url := fmt.Sprintf(urlGetUserOrders, urlAccrual, number)
-	r, _ := http.NewRequest(http.MethodGet, url, nil)
-	r = r.WithContext(ctx)
-	resp, err := client.Do(r)
+	// глубина очереди нужна и при разомкнутом выключателе: по ней видно, сколько заказов копится
+	pending, err := storage.CountPendingOrders(ctx)
 	if err != nil {
-		logger.Logger.Warn("ошибка запроса", zap.Error(err))
+		logger.FromContext(ctx).Warn("Не удалось посчитать заказы в очереди", zap.Error(err))
+	} else {
+		metrics.AccrualQueueDepth.Set(float64(pending))
+	}
+
+	limit = client.Breaker().Permits(limit)
+	if limit == 0 {
 		return nil
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	statusOrders, err = storage.ClaimOrders(ctx, workerID, limit, LeaseDuration)
 	if err != nil {
-		logger.Logger.Warn("не удалось прочитать данные", zap.Error(err))
-		return nil
+		logger.FromContext(ctx).Warn("Ошибка получения данных о заказах", zap.Error(err))
+		return statusOrders
 	}
+	// опрос идёт каждый период, поэтому в обычном журнале строки о каждой аренде не нужны
+	if len(statusOrders) > 0 {
+		logger.FromContext(ctx).Debug("Арендованы заказы", zap.Int("orders", len(statusOrders)), zap.Int("limit", limit))
+	}
+	return statusOrders
+}
 
-	switch resp.StatusCode {
-	case 204:
-		logger.Logger.Warn("заказ не зарегистрирован в системе расчёта")
-		return nil
-	case 429:
-		timeSleepStr := resp.Header.Get("Retry-After")
-		timeSleep, err := strconv.ParseInt(timeSleepStr, 10, 64)
+// UpdateStatusOrdersWorker опрашивает систему расчёта по арендованным replicaID заказам и снимает аренду после опроса.
+// Все воркеры используют один client, поэтому ограничение частоты запросов общее для пула.
+// Неудачный опрос откладывается по расписанию backoff, а по истечении backoff.MaxAge заказ переводится в dead-letter.
+//
+// Воркер завершается, когда jobs закрыт и вычитан. После отмены ctx запросы к системе расчёта прерываются,
+// а оставшиеся в jobs заказы освобождаются без опроса, чтобы их сразу подхватила другая реплика.
+// Начатые записи в хранилище при этом доводятся до конца.
+func UpdateStatusOrdersWorker(ctx context.Context, workerID int, replicaID string, storage *store.StorageContext, client *Client, backoff Backoff, jobs <-chan models.AccrualJob) {
+	storeCtx := store.WithActor(context.WithoutCancel(ctx), store.AuditActorAccrual)
+	for job := range jobs {
+		start := time.Now()
+
+		// у каждого заказа своя трасса: опрос системы расчёта и запись результата в хранилище
+		jobCtx, span := tracer.Start(ctx, "accrual.ProcessOrder", trace.WithNewRoot(), trace.WithAttributes(
+			attribute.Int64("gophermart.order", job.Number),
+			attribute.Int("gophermart.attempts", job.Attempts),
+			attribute.Int("gophermart.worker", workerID),
+		))
+		correlationID := newCorrelationID(replicaID)
+		jobCtx = withCorrelation(jobCtx, correlationID, workerID, job.Number)
+		storeCtx := withCorrelation(trace.ContextWithSpan(storeCtx, span), correlationID, workerID, job.Number)
+		logger.FromContext(jobCtx).Info("Опрос заказа", zap.Int("attempt", job.Attempts+1))
+
+		statusOrder, err := client.GetStatus(jobCtx, job.Number, func(ctx context.Context) error {
+			return storage.RenewOrderLease(storeCtx, replicaID, job.Number, LeaseDuration)
+		})
+		switch {
+		case errors.Is(err, store.ErrLeaseLost):
+			// аренда истекла, пока заказ ждал в очереди воркеров или паузы системы расчёта:
+			// заказ уже может опрашивать другой воркер, снимать чужую аренду нельзя
+			logger.FromContext(jobCtx).Info("Аренда заказа истекла, опрос пропущен")
+			err = nil
+		case err == nil:
+			err = storage.UpdateStatusOrders(storeCtx, statusOrder)
+			if err != nil {
+				logger.FromContext(storeCtx).Warn("Ошибка обновления данных", zap.Error(err))
+			}
+			err = storage.ReleaseOrder(storeCtx, replicaID, job.Number)
+		case errors.Is(err, ErrStatusTooManyRequests), errors.Is(err, ErrCircuitOpen):
+			// заказ не виноват: пауза уже назначена всему пулу
+			err = storage.ReleaseOrder(storeCtx, replicaID, job.Number)
+		case ctx.Err() != nil:
+			// сервис останавливается, заказ не виноват
+			err = storage.ReleaseOrder(storeCtx, replicaID, job.Number)
+		default:
+			err = retryOrder(storeCtx, storage, replicaID, backoff, job, err)
+		}
 		if err != nil {
-			logger.Logger.Warn("не удалось задать таймер")
-			return nil
+			logger.FromContext(storeCtx).Warn("Ошибка снятия аренды заказа", zap.Error(err))
 		}
-		logger.Logger.Warn(fmt.Sprintf("превышено количество запросов к сервису, ожидание %s", timeSleepStr))
-		time.Sleep(time.Duration(timeSleep))
-		return nil
-	case 500:
-		logger.Logger.Warn("внутренняя ошибка сервера системы расчёта начислений баллов лояльности")
-		return nil
+		span.End()
+		metrics.AccrualWorkerBusy.Add(time.Since(start).Seconds())
 	}
+}
 
-	err = json.Unmarshal(body, &statusOrders)
-	if err != nil {
-		logger.Logger.Warn("не удалось распорсить запрос", zap.Error(err))
-		return nil
+var correlationSeq atomic.Uint64
+
+// newCorrelationID возвращает идентификатор опроса заказа, уникальный в кластере: идентификатор реплики и номер опроса
+func newCorrelationID(replicaID string) string {
+	return fmt.Sprintf("%s-%06d", replicaID, correlationSeq.Add(1))
+}
+
+// withCorrelation связывает опрос заказа одним идентификатором: он уходит в систему расчёта в X-Request-Id,
+// записывается в журнал аудита как идентификатор запроса и в каждую строку журнала опроса
+func withCorrelation(ctx context.Context, correlationID string, workerID int, order int64) context.Context {
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, correlationID)
+	ctx = store.WithRequestID(ctx, correlationID)
+	return logger.WithLogger(ctx, logger.Logger.With(
+		zap.String("request_id", correlationID),
+		zap.Int("worker", workerID),
+		zap.Int64("order", order),
+	))
+}
+
+// retryOrder откладывает опрос заказа после неудачи или переводит его в dead-letter, если заказ слишком долго в очереди
+func retryOrder(ctx context.Context, storage *store.StorageContext, replicaID string, backoff Backoff, job models.AccrualJob, failure error) error {
+	now := time.Now()
+	if backoff.Expired(job.QueuedAt, now) {
+		logger.FromContext(ctx).Warn("Заказ переведён в dead-letter", zap.Int("attempts", job.Attempts+1), zap.Error(failure))
+		return storage.DeadLetterOrder(ctx, replicaID, job.Number, failure.Error())
 	}
-	return statusOrders
+	delay := backoff.Delay(job.Attempts)
+	logger.FromContext(ctx).Info("Опрос заказа отложен", zap.Duration("delay", delay), zap.Error(failure))
+	return storage.RetryOrder(ctx, replicaID, job.Number, now.Add(delay), failure.Error())
 }
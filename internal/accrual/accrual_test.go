@@ -0,0 +1,177 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gophermart/internal/accrual/accrualtest"
+	"gophermart/internal/logger"
+	"gophermart/internal/models"
+	"gophermart/internal/store"
+	"gophermart/internal/store/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runWorker арендует заказы и прогоняет их через один воркер
+func runWorker(t *testing.T, storage *store.StorageContext, server *httptest.Server, backoff Backoff) {
+	t.Helper()
+	client := NewClient(server.URL, DefaultBreakerConfig)
+	jobs := make(chan models.AccrualJob, 10)
+	for _, job := range PrepareBatch(context.Background(), storage, client, "replica", cap(jobs)) {
+		jobs <- job
+	}
+	close(jobs)
+	UpdateStatusOrdersWorker(context.Background(), 1, "replica", storage, client, backoff, jobs)
+}
+
+func newWorkerStorage(t *testing.T, orders ...int64) *store.StorageContext {
+	t.Helper()
+	ctx := context.Background()
+	db := memory.NewStorage()
+	require.NoError(t, db.UserRegister(ctx, "test", "password"))
+	for _, order := range orders {
+		require.NoError(t, db.UploadUserOrders(ctx, "test", order))
+	}
+	storage := &store.StorageContext{}
+	storage.SetStorage(db)
+	return storage
+}
+
+func TestUpdateStatusOrdersWorker(t *testing.T) {
+	logger.Init()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/orders/12345678903":
+			w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`))
+		case "/api/orders/500":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	storage := newWorkerStorage(t, 12345678903, 500, 204)
+	runWorker(t, storage, server, Backoff{Base: time.Hour, Max: time.Hour, MaxAge: 24 * time.Hour})
+
+	balance, err := storage.GetUserBalance(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, models.MoneyFromKopecks(50000), balance.Current)
+
+	// неудачные заказы отложены и не арендуются до следующей попытки
+	jobs, err := storage.ClaimOrders(ctx, "other", 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+
+	deadLetters, err := storage.GetDeadLetterOrders(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+func TestUpdateStatusOrdersWorkerDeadLetter(t *testing.T) {
+	logger.Init()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	storage := newWorkerStorage(t, 204)
+	runWorker(t, storage, server, Backoff{Base: time.Hour, Max: time.Hour, MaxAge: 0})
+
+	deadLetters, err := storage.GetDeadLetterOrders(ctx)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, "204", deadLetters[0].Number)
+	assert.Equal(t, 1, deadLetters[0].Attempts)
+	assert.Equal(t, ErrStatusNoContent.Error(), deadLetters[0].LastError)
+
+	require.NoError(t, storage.RequeueOrder(ctx, 204))
+	jobs, err := storage.ClaimOrders(ctx, "other", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 0, jobs[0].Attempts)
+}
+
+func TestUpdateStatusOrdersWorkerShutdown(t *testing.T) {
+	logger.Init()
+	stub, server := accrualtest.NewServer(t)
+	stub.AutoRegister = true
+
+	storage := newWorkerStorage(t, 12345678903, 79927398713)
+	client := NewClient(server.URL, DefaultBreakerConfig)
+	jobs := make(chan models.AccrualJob, 10)
+	for _, job := range PrepareBatch(context.Background(), storage, client, "replica", cap(jobs)) {
+		jobs <- job
+	}
+	close(jobs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Empty(t, PrepareBatch(ctx, storage, client, "replica", cap(jobs)), "после остановки заказы не арендуются")
+	UpdateStatusOrdersWorker(ctx, 1, "replica", storage, client, DefaultBackoff, jobs)
+
+	// оставшиеся заказы освобождены без опроса и без учёта неудачной попытки
+	assert.Equal(t, 0, stub.Requests())
+	claimed, err := storage.ClaimOrders(context.Background(), "other", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	for _, job := range claimed {
+		assert.Equal(t, 0, job.Attempts)
+	}
+}
+
+func TestUpdateStatusOrdersWorkerLeaseLost(t *testing.T) {
+	logger.Init()
+	stub, server := accrualtest.NewServer(t)
+	stub.AutoRegister = true
+
+	ctx := context.Background()
+	storage := newWorkerStorage(t, 12345678903)
+	claimed, err := storage.ClaimOrders(ctx, "replica", 10, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	// заказ ждал в очереди дольше аренды, и его арендовала другая реплика
+	time.Sleep(50 * time.Millisecond)
+	taken, err := storage.ClaimOrders(ctx, "other", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, taken, 1)
+
+	jobs := make(chan models.AccrualJob, 1)
+	jobs <- claimed[0]
+	close(jobs)
+	UpdateStatusOrdersWorker(ctx, 1, "replica", storage, NewClient(server.URL, DefaultBreakerConfig), DefaultBackoff, jobs)
+
+	assert.Equal(t, 0, stub.Requests(), "заказ с истёкшей арендой не опрашивается")
+	require.NoError(t, storage.RenewOrderLease(ctx, "other", 12345678903, time.Minute), "чужая аренда не снята")
+}
+
+func TestUpdateStatusOrdersWorkerWithStub(t *testing.T) {
+	logger.Init()
+	stub, server := accrualtest.NewServer(t)
+	require.NoError(t, stub.AddRule(accrualtest.Rule{Match: "Bork", Reward: models.MoneyFromKopecks(1000), RewardType: accrualtest.RewardPercent}))
+	require.NoError(t, stub.RegisterOrder("12345678903", []accrualtest.Good{{Description: "Чайник Bork", Price: models.MoneyFromKopecks(729980)}}))
+	stub.ScriptOrder("12345678903", accrualtest.InternalError(), accrualtest.Pass(), accrualtest.TooManyRequests(0, 6000))
+
+	ctx := context.Background()
+	storage := newWorkerStorage(t, 12345678903)
+	backoff := Backoff{Base: time.Nanosecond, Max: time.Nanosecond, MaxAge: time.Hour}
+	for i := 0; i < 6; i++ {
+		runWorker(t, storage, server, backoff)
+	}
+
+	orders, err := storage.GetUserOrders(ctx, "test")
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, models.OrderStatusProcessed, orders[0].Status)
+	assert.Equal(t, models.MoneyFromKopecks(72998), orders[0].Accrual)
+	// 500, REGISTERED, 429, PROCESSING, PROCESSED, после чего заказ больше не опрашивается
+	assert.Equal(t, 5, stub.Polled("12345678903"))
+}
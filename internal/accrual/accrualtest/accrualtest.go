@@ -0,0 +1,381 @@
+// Package accrualtest поддельная система расчёта начислений для локального запуска и интеграционных тестов.
+//
+// Service реализует API системы расчёта: GET /api/orders/{number}, регистрацию заказов POST /api/orders
+// и правил вознаграждения POST /api/goods. Зарегистрированный заказ проходит статусы
+// REGISTERED → PROCESSING → PROCESSED, а если ни один товар не подходит под правила — INVALID.
+// Ответы 204, 429 и 500 задаются расписанием через Script и ScriptOrder.
+package accrualtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gophermart/internal/luhn"
+	"gophermart/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Статусы расчёта начислений в ответах системы расчёта
+const (
+	StatusRegistered = models.AccrualStatusRegistered
+	StatusProcessing = models.OrderStatusProcessing
+	StatusProcessed  = models.OrderStatusProcessed
+	StatusInvalid    = models.OrderStatusInvalid
+)
+
+// Виды вознаграждения правила
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+var ErrOrderRegistered = errors.New("order already registered")
+var ErrRuleRegistered = errors.New("reward rule already registered")
+var ErrInvalidOrder = errors.New("invalid order number")
+var ErrInvalidRule = errors.New("invalid reward rule")
+
+type Good struct {
+	Description string       `json:"description"`                // наименование товара
+	Price       models.Money `json:"price" swaggertype:"number"` // цена товара
+}
+
+type Rule struct {
+	Match      string       `json:"match"`                       // подстрока наименования товара
+	Reward     models.Money `json:"reward" swaggertype:"number"` // баллы или процент от цены
+	RewardType string       `json:"reward_type"`                 // вид вознаграждения: % или pt
+}
+
+// Step запланированный ответ на запрос GET /api/orders/{number}
+type Step struct {
+	Code       int           // HTTP-статус ответа, 200 — обычная обработка запроса
+	RetryAfter time.Duration // заголовок Retry-After для ответа 429
+	Limit      int           // ограничение в минуту из тела ответа 429
+}
+
+// Pass обычная обработка запроса
+func Pass() Step {
+	return Step{Code: http.StatusOK}
+}
+
+// NoContent ответ 204, как будто заказ не зарегистрирован
+func NoContent() Step {
+	return Step{Code: http.StatusNoContent}
+}
+
+// TooManyRequests ответ 429 с заголовком Retry-After и ограничением limit запросов в минуту
+func TooManyRequests(retryAfter time.Duration, limit int) Step {
+	return Step{Code: http.StatusTooManyRequests, RetryAfter: retryAfter, Limit: limit}
+}
+
+// InternalError ответ 500
+func InternalError() Step {
+	return Step{Code: http.StatusInternalServerError}
+}
+
+type order struct {
+	goods []Good
+	polls int
+	// auto заказ зарегистрирован при первом опросе, начисление за него равно Service.AutoAccrual
+	auto bool
+}
+
+// Service поддельная система расчёта, безопасна для одновременного использования
+type Service struct {
+	// PollsPerStage число опросов, после которых заказ переходит в следующий статус
+	PollsPerStage int
+	// AutoRegister регистрирует неизвестный заказ при первом опросе, начисление за него равно AutoAccrual
+	AutoRegister bool
+	AutoAccrual  models.Money
+
+	router *chi.Mux
+
+	mu          sync.Mutex
+	orders      map[string]*order
+	rules       []Rule
+	schedule    []Step
+	orderSteps  map[string][]Step
+	requests    int
+	orderPolled map[string]int
+}
+
+func NewService() *Service {
+	s := &Service{
+		PollsPerStage: 1,
+		orders:        make(map[string]*order),
+		orderSteps:    make(map[string][]Step),
+		orderPolled:   make(map[string]int),
+	}
+
+	s.router = chi.NewRouter()
+	s.router.Get("/api/orders/{number}", s.getOrder)
+	s.router.Post("/api/orders", s.postOrder)
+	s.router.Post("/api/goods", s.postGoods)
+	s.router.Post("/stub/script", s.postScript)
+	return s
+}
+
+// NewServer запускает Service на httptest.Server, который закрывается по завершении теста
+func NewServer(t testing.TB) (*Service, *httptest.Server) {
+	t.Helper()
+	s := NewService()
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// RegisterOrder регистрирует заказ с товарами
+func (s *Service) RegisterOrder(number string, goods []Good) error {
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || !luhn.Valid(n) {
+		return ErrInvalidOrder
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[number]; ok {
+		return ErrOrderRegistered
+	}
+	s.orders[number] = &order{goods: goods}
+	return nil
+}
+
+// AddRule добавляет правило вознаграждения за товары, в наименовании которых есть rule.Match
+func (s *Service) AddRule(rule Rule) error {
+	if rule.Match == "" || rule.Reward <= 0 || (rule.RewardType != RewardPercent && rule.RewardType != RewardPoints) {
+		return ErrInvalidRule
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.rules {
+		if existing.Match == rule.Match {
+			return ErrRuleRegistered
+		}
+	}
+	s.rules = append(s.rules, rule)
+	return nil
+}
+
+// Script добавляет ответы, которые по очереди получат следующие запросы о любых заказах
+func (s *Service) Script(steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedule = append(s.schedule, steps...)
+}
+
+// ScriptOrder добавляет ответы, которые по очереди получат следующие запросы о заказе number.
+// Расписание заказа применяется раньше общего расписания.
+func (s *Service) ScriptOrder(number string, steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orderSteps[number] = append(s.orderSteps[number], steps...)
+}
+
+// Requests возвращает число запросов GET /api/orders/{number}
+func (s *Service) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Polled возвращает число запросов о заказе number
+func (s *Service) Polled(number string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.orderPolled[number]
+}
+
+// nextStep возвращает запланированный ответ для заказа, вызывается под блокировкой
+func (s *Service) nextStep(number string) Step {
+	if steps := s.orderSteps[number]; len(steps) > 0 {
+		s.orderSteps[number] = steps[1:]
+		return steps[0]
+	}
+	if len(s.schedule) > 0 {
+		step := s.schedule[0]
+		s.schedule = s.schedule[1:]
+		return step
+	}
+	return Pass()
+}
+
+func (s *Service) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	s.mu.Lock()
+	s.requests++
+	s.orderPolled[number]++
+	step := s.nextStep(number)
+
+	switch step.Code {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		s.mu.Unlock()
+		w.Header().Set("Retry-After", strconv.Itoa(int(step.RetryAfter/time.Second)))
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "No more than %d requests per minute allowed", step.Limit)
+		return
+	default:
+		s.mu.Unlock()
+		w.WriteHeader(step.Code)
+		return
+	}
+
+	o, ok := s.orders[number]
+	if !ok {
+		if !s.AutoRegister {
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		o = &order{auto: true}
+		s.orders[number] = o
+	}
+	o.polls++
+	response := s.status(number, o)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// status возвращает статус расчёта заказа после очередного опроса, вызывается под блокировкой
+func (s *Service) status(number string, o *order) models.StatusOrdersAccrual {
+	perStage := s.PollsPerStage
+	if perStage < 1 {
+		perStage = 1
+	}
+	response := models.StatusOrdersAccrual{Order: number}
+	switch stage := (o.polls - 1) / perStage; {
+	case stage == 0:
+		response.Status = StatusRegistered
+	case stage == 1:
+		response.Status = StatusProcessing
+	case o.auto:
+		response.Status = StatusProcessed
+		response.Accrual = s.AutoAccrual
+	default:
+		accrual, matched := s.accrual(o.goods)
+		if !matched {
+			response.Status = StatusInvalid
+			break
+		}
+		response.Status = StatusProcessed
+		response.Accrual = accrual
+	}
+	return response
+}
+
+// accrual рассчитывает вознаграждение за товары по правилам и сообщает, подошло ли хоть одно правило.
+// Вызывается под блокировкой.
+func (s *Service) accrual(goods []Good) (models.Money, bool) {
+	var total models.Money
+	matched := false
+	for _, good := range goods {
+		for _, rule := range s.rules {
+			if !strings.Contains(good.Description, rule.Match) {
+				continue
+			}
+			matched = true
+			if rule.RewardType == RewardPoints {
+				total += rule.Reward
+			} else {
+				// цена в копейках умножается на процент в сотых долях, результат округляется до копейки
+				total += models.MoneyFromKopecks((good.Price.Kopecks()*rule.Reward.Kopecks() + 5000) / 10000)
+			}
+			break
+		}
+	}
+	return total, matched
+}
+
+type registerRequest struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+func (s *Service) postOrder(w http.ResponseWriter, r *http.Request) {
+	var request registerRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = s.RegisterOrder(request.Order, request.Goods)
+	switch {
+	case errors.Is(err, ErrOrderRegistered):
+		w.WriteHeader(http.StatusConflict)
+	case err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *Service) postGoods(w http.ResponseWriter, r *http.Request) {
+	var rule Rule
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = s.AddRule(rule)
+	switch {
+	case errors.Is(err, ErrRuleRegistered):
+		w.WriteHeader(http.StatusConflict)
+	case err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// ScriptStep запланированный ответ в запросе POST /stub/script
+type ScriptStep struct {
+	Order      string `json:"order,omitempty"`       // заказ, для которого запланирован ответ, пусто — для любого
+	Code       int    `json:"code"`                  // HTTP-статус ответа
+	RetryAfter int    `json:"retry_after,omitempty"` // секунды в заголовке Retry-After для ответа 429
+	Limit      int    `json:"limit,omitempty"`       // ограничение в минуту для ответа 429
+}
+
+// postScript позволяет задать расписание запущенной заглушке: POST /stub/script с массивом ScriptStep
+func (s *Service) postScript(w http.ResponseWriter, r *http.Request) {
+	var steps []ScriptStep
+	err := json.NewDecoder(r.Body).Decode(&steps)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, step := range steps {
+		if step.Code < 200 || step.Code > 599 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	for _, step := range steps {
+		planned := Step{Code: step.Code, RetryAfter: time.Duration(step.RetryAfter) * time.Second, Limit: step.Limit}
+		if step.Order != "" {
+			s.ScriptOrder(step.Order, planned)
+		} else {
+			s.Script(planned)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
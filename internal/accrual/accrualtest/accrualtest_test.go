@@ -0,0 +1,92 @@
+package accrualtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gophermart/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, s *Service, number string) (*httptest.ResponseRecorder, models.StatusOrdersAccrual) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/"+number, nil))
+	var status models.StatusOrdersAccrual
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	}
+	return w, status
+}
+
+func post(s *Service, path string, body string) int {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return w.Code
+}
+
+func TestServiceTransitions(t *testing.T) {
+	s := NewService()
+	assert.Equal(t, http.StatusOK, post(s, "/api/goods", `{"match":"Bork","reward":10,"reward_type":"%"}`))
+	assert.Equal(t, http.StatusConflict, post(s, "/api/goods", `{"match":"Bork","reward":5,"reward_type":"pt"}`))
+	assert.Equal(t, http.StatusBadRequest, post(s, "/api/goods", `{"match":"LG","reward":5,"reward_type":"points"}`))
+	require.NoError(t, s.AddRule(Rule{Match: "LG", Reward: models.MoneyFromKopecks(5000), RewardType: RewardPoints}))
+
+	assert.Equal(t, http.StatusAccepted, post(s, "/api/orders", `{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7299.8},{"description":"Телевизор LG","price":30000}]}`))
+	assert.Equal(t, http.StatusConflict, post(s, "/api/orders", `{"order":"12345678903","goods":[]}`))
+	assert.Equal(t, http.StatusBadRequest, post(s, "/api/orders", `{"order":"12345678904","goods":[]}`))
+	require.NoError(t, s.RegisterOrder("2377225624", []Good{{Description: "Хлеб", Price: 5000}}))
+
+	w, _ := get(t, s, "79927398713")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	for _, expected := range []string{StatusRegistered, StatusProcessing, StatusProcessed, StatusProcessed} {
+		_, status := get(t, s, "12345678903")
+		assert.Equal(t, expected, status.Status)
+	}
+	_, status := get(t, s, "12345678903")
+	assert.Equal(t, models.MoneyFromKopecks(72998+5000), status.Accrual, "10% от 7299.80 и 50 баллов")
+
+	get(t, s, "2377225624")
+	get(t, s, "2377225624")
+	_, status = get(t, s, "2377225624")
+	assert.Equal(t, StatusInvalid, status.Status, "ни одно правило не подошло")
+	assert.Equal(t, 3, s.Polled("2377225624"))
+}
+
+func TestServiceSchedule(t *testing.T) {
+	s := NewService()
+	s.AutoRegister = true
+	s.AutoAccrual = models.MoneyFromKopecks(10000)
+	s.Script(TooManyRequests(time.Minute, 10), InternalError())
+	s.ScriptOrder("2377225624", NoContent())
+
+	w, _ := get(t, s, "12345678903")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "No more than 10 requests per minute allowed", w.Body.String())
+
+	w, _ = get(t, s, "2377225624")
+	assert.Equal(t, http.StatusNoContent, w.Code, "расписание заказа важнее общего")
+
+	w, _ = get(t, s, "12345678903")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	assert.Equal(t, http.StatusOK, post(s, "/stub/script", `[{"order":"12345678903","code":429,"retry_after":2,"limit":5}]`))
+	assert.Equal(t, http.StatusBadRequest, post(s, "/stub/script", `[{"code":42}]`))
+	w, _ = get(t, s, "12345678903")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	get(t, s, "12345678903")
+	get(t, s, "12345678903")
+	_, status := get(t, s, "12345678903")
+	assert.Equal(t, models.StatusOrdersAccrual{Order: "12345678903", Status: StatusProcessed, Accrual: 10000}, status, "неизвестный заказ зарегистрирован автоматически")
+	assert.Equal(t, 7, s.Requests())
+}
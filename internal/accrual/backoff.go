@@ -0,0 +1,43 @@
+package accrual
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff расписание повторных опросов заказа, который система расчёта не знает (204) или не смогла обработать (500)
+type Backoff struct {
+	Base time.Duration // пауза после первой неудачи
+	Max  time.Duration // предельная пауза между опросами
+	// MaxAge время с постановки заказа в очередь, после которого неудачный опрос переводит заказ в dead-letter
+	MaxAge time.Duration
+}
+
+// DefaultBackoff расписание опросов по умолчанию
+var DefaultBackoff = Backoff{
+	Base:   time.Second,
+	Max:    10 * time.Minute,
+	MaxAge: 24 * time.Hour,
+}
+
+// Delay возвращает паузу перед следующим опросом после attempts предыдущих неудач.
+// Пауза удваивается с каждой неудачей и случайно сокращается до половины, чтобы реплики не опрашивали заказы синхронно.
+func (b Backoff) Delay(attempts int) time.Duration {
+	delay := b.Base
+	for i := 0; i < attempts && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Expired сообщает, что заказ, поставленный в очередь в queuedAt, пора перевести в dead-letter
+func (b Backoff) Expired(queuedAt time.Time, now time.Time) bool {
+	return now.Sub(queuedAt) >= b.MaxAge
+}
@@ -0,0 +1,38 @@
+package accrual
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Base: time.Second, Max: time.Minute, MaxAge: time.Hour}
+
+	tests := []struct {
+		attempts int
+		min, max time.Duration
+	}{
+		{attempts: 0, min: 500 * time.Millisecond, max: time.Second},
+		{attempts: 1, min: time.Second, max: 2 * time.Second},
+		{attempts: 3, min: 4 * time.Second, max: 8 * time.Second},
+		{attempts: 10, min: 30 * time.Second, max: time.Minute},
+		{attempts: 1000, min: 30 * time.Second, max: time.Minute},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			delay := backoff.Delay(test.attempts)
+			assert.GreaterOrEqual(t, delay, test.min, "попыток: %d", test.attempts)
+			assert.LessOrEqual(t, delay, test.max, "попыток: %d", test.attempts)
+		}
+	}
+}
+
+func TestBackoffExpired(t *testing.T) {
+	backoff := Backoff{Base: time.Second, Max: time.Minute, MaxAge: time.Hour}
+	now := time.Now()
+
+	assert.False(t, backoff.Expired(now.Add(-59*time.Minute), now))
+	assert.True(t, backoff.Expired(now.Add(-time.Hour), now))
+}
@@ -0,0 +1,201 @@
+package accrual
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
+
+	"go.uber.org/zap"
+)
+
+var ErrCircuitOpen = errors.New("accrual circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // запросы идут в систему расчёта
+	BreakerOpen                         // система расчёта недоступна, запросы не отправляются
+	BreakerHalfOpen                     // пробные запросы проверяют, восстановилась ли система расчёта
+)
+
+var breakerStates = []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen}
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerConfig struct {
+	FailureThreshold int           // число неудачных запросов подряд, после которого выключатель размыкается
+	OpenTimeout      time.Duration // время в разомкнутом состоянии до пробных запросов
+	HalfOpenRequests int           // число пробных запросов, после успеха которых выключатель замыкается
+}
+
+// DefaultBreakerConfig настройки автоматического выключателя по умолчанию
+var DefaultBreakerConfig = BreakerConfig{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+	HalfOpenRequests: 1,
+}
+
+// Breaker автоматический выключатель запросов к системе расчёта.
+// Неудачей считаются сетевые ошибки и ответы 5xx, ответы 204 и 429 показывают, что система доступна.
+// Смена состояния пишется в лог и в метрики, поэтому пока система недоступна, лог не засоряется ошибками по каждому заказу.
+type Breaker struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+func NewBreaker(cfg BreakerConfig) *Breaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultBreakerConfig.FailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = DefaultBreakerConfig.OpenTimeout
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = DefaultBreakerConfig.HalfOpenRequests
+	}
+	b := &Breaker{cfg: cfg, now: time.Now}
+	setStateMetric(BreakerClosed)
+	return b
+}
+
+// State возвращает текущее состояние выключателя
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// Permits возвращает, сколько из limit заказов можно отдать воркерам: все при замкнутом выключателе,
+// ни одного при разомкнутом и не больше оставшихся пробных запросов при полуразомкнутом
+func (b *Breaker) Permits(limit int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	switch b.state {
+	case BreakerOpen:
+		return 0
+	case BreakerHalfOpen:
+		return min(limit, b.cfg.HalfOpenRequests-b.probes)
+	default:
+		return limit
+	}
+}
+
+// Allow разрешает запрос или возвращает ErrCircuitOpen. Разрешённый запрос завершается
+// вызовом Success, Failure или Cancel.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	switch b.state {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Success учитывает успешный запрос
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		b.failures = 0
+	case BreakerHalfOpen:
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.transition(BreakerClosed)
+		}
+	}
+}
+
+// Failure учитывает неудачный запрос
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.transition(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		b.transition(BreakerOpen)
+	}
+}
+
+// Cancel возвращает разрешение на запрос, который не дошёл до системы расчёта, например из-за отмены контекста
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// advance переводит разомкнутый выключатель в полуразомкнутый по истечении OpenTimeout, вызывается под блокировкой
+func (b *Breaker) advance() {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.transition(BreakerHalfOpen)
+	}
+}
+
+// transition меняет состояние выключателя, вызывается под блокировкой
+func (b *Breaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	if to == BreakerOpen {
+		b.openedAt = b.now()
+	}
+
+	setStateMetric(to)
+	metrics.AccrualCircuitTransitions.WithLabelValues(to.String()).Inc()
+	switch to {
+	case BreakerOpen:
+		logger.Logger.Warn("Система расчёта недоступна, опрос заказов приостановлен",
+			zap.Stringer("from", from), zap.Stringer("to", to), zap.Duration("delay", b.cfg.OpenTimeout))
+	default:
+		logger.Logger.Info("Состояние автоматического выключателя системы расчёта изменилось",
+			zap.Stringer("from", from), zap.Stringer("to", to))
+	}
+}
+
+func setStateMetric(current BreakerState) {
+	for _, state := range breakerStates {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		metrics.AccrualCircuitState.WithLabelValues(state.String()).Set(value)
+	}
+}
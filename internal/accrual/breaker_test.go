@@ -0,0 +1,105 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBreaker возвращает выключатель с управляемыми часами
+func newTestBreaker(cfg BreakerConfig) (*Breaker, *time.Time) {
+	now := time.Now()
+	b := NewBreaker(cfg)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	logger.Init()
+	b, now := newTestBreaker(BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Minute, HalfOpenRequests: 2})
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.Allow())
+		b.Failure()
+	}
+	require.NoError(t, b.Allow())
+	b.Success()
+	assert.Equal(t, BreakerClosed, b.State(), "успешный запрос сбрасывает счётчик неудач")
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen)
+	assert.Equal(t, 0, b.Permits(10))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.AccrualCircuitState.WithLabelValues("open")))
+
+	*now = now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.Equal(t, 2, b.Permits(10))
+	require.NoError(t, b.Allow())
+	assert.Equal(t, 1, b.Permits(10))
+	require.NoError(t, b.Allow())
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen, "пробных запросов не больше HalfOpenRequests")
+
+	b.Success()
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State(), "неудачный пробный запрос снова размыкает выключатель")
+
+	*now = now.Add(time.Minute)
+	require.NoError(t, b.Allow())
+	b.Cancel()
+	require.NoError(t, b.Allow())
+	require.NoError(t, b.Allow())
+	b.Success()
+	b.Success()
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.AccrualCircuitState.WithLabelValues("closed")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.AccrualCircuitState.WithLabelValues("half-open")))
+}
+
+func TestClientCircuitOpenStopsDispatch(t *testing.T) {
+	logger.Init()
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	storage := newWorkerStorage(t, 12345678903)
+	client := NewClient(server.URL, BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Hour, HalfOpenRequests: 1})
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetStatus(ctx, 12345678903, nil)
+		assert.ErrorIs(t, err, ErrStatusInternalServerError)
+	}
+	assert.Equal(t, BreakerOpen, client.Breaker().State())
+
+	_, err := client.GetStatus(ctx, 12345678903, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int64(2), requests.Load(), "разомкнутый выключатель не пропускает запросы")
+	assert.Empty(t, PrepareBatch(context.Background(), storage, client, "replica", 10), "заказы не раздаются воркерам")
+}
+
+func TestMetricsHandlerExposesCircuitState(t *testing.T) {
+	NewBreaker(DefaultBreakerConfig)
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `gophermart_accrual_circuit_state{state="closed"} 1`))
+}
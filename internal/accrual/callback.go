@@ -0,0 +1,67 @@
+package accrual
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Заголовки подписанного запроса системы расчёта на /internal/accrual/callback
+const (
+	CallbackTimestampHeader = "X-Accrual-Timestamp" // время отправки, unix-время в секундах
+	CallbackSignatureHeader = "X-Accrual-Signature" // sha256=<hex HMAC-SHA256 от "<timestamp>.<тело запроса>">
+)
+
+// CallbackTolerance допустимое расхождение времени отправки уведомления с текущим временем.
+// Подписи принятых уведомлений хранятся столько же, поэтому повтор уведомления отклоняется.
+const CallbackTolerance = 5 * time.Minute
+
+// CallbackMaxBodySize наибольший размер тела уведомления. Эндпоинт доступен без входа,
+// поэтому тело ограничивается до проверки подписи.
+const CallbackMaxBodySize = 4 << 10
+
+const signaturePrefix = "sha256="
+
+var ErrCallbackSignature = errors.New("invalid accrual callback signature")
+var ErrCallbackExpired = errors.New("accrual callback timestamp is outside the tolerance window")
+
+// SignCallback возвращает значение заголовка X-Accrual-Signature для тела body, отправленного в timestamp
+func SignCallback(secret []byte, timestamp time.Time, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(callbackMAC(secret, strconv.FormatInt(timestamp.Unix(), 10), body))
+}
+
+// VerifyCallback проверяет подпись уведомления и что оно отправлено не раньше и не позже CallbackTolerance от now.
+// Подпись принимается только в том виде, в каком её возвращает SignCallback: по ней отклоняются повторы,
+// и та же подпись в другом регистре не должна считаться новым уведомлением.
+func VerifyCallback(secret []byte, timestamp string, signature string, body []byte, now time.Time) error {
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrCallbackSignature
+	}
+	hexMAC, ok := strings.CutPrefix(signature, signaturePrefix)
+	if !ok {
+		return ErrCallbackSignature
+	}
+	mac, err := hex.DecodeString(hexMAC)
+	if err != nil || hexMAC != hex.EncodeToString(mac) || !hmac.Equal(mac, callbackMAC(secret, timestamp, body)) {
+		return ErrCallbackSignature
+	}
+
+	delta := now.Sub(time.Unix(sent, 0))
+	if delta > CallbackTolerance || delta < -CallbackTolerance {
+		return ErrCallbackExpired
+	}
+	return nil
+}
+
+func callbackMAC(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
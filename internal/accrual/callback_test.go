@@ -0,0 +1,28 @@
+package accrual
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyCallback(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"order":"12345678903","status":"PROCESSED","accrual":729.98}`)
+	sent := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	signature := SignCallback(secret, sent, body)
+
+	assert.NoError(t, VerifyCallback(secret, timestamp, signature, body, sent.Add(time.Minute)))
+	assert.ErrorIs(t, VerifyCallback([]byte("other"), timestamp, signature, body, sent), ErrCallbackSignature)
+	assert.ErrorIs(t, VerifyCallback(secret, timestamp, signature, []byte(`{}`), sent), ErrCallbackSignature)
+	assert.ErrorIs(t, VerifyCallback(secret, strconv.FormatInt(sent.Unix()+1, 10), signature, body, sent), ErrCallbackSignature, "время отправки подписано")
+	assert.ErrorIs(t, VerifyCallback(secret, timestamp, signature[len(signaturePrefix):], body, sent), ErrCallbackSignature)
+	assert.ErrorIs(t, VerifyCallback(secret, timestamp, signaturePrefix+strings.ToUpper(signature[len(signaturePrefix):]), body, sent), ErrCallbackSignature, "подпись только в нижнем регистре")
+	assert.ErrorIs(t, VerifyCallback(secret, "", signature, body, sent), ErrCallbackSignature)
+	assert.ErrorIs(t, VerifyCallback(secret, timestamp, signature, body, sent.Add(CallbackTolerance+time.Second)), ErrCallbackExpired)
+	assert.ErrorIs(t, VerifyCallback(secret, timestamp, signature, body, sent.Add(-CallbackTolerance-time.Second)), ErrCallbackExpired)
+}
@@ -0,0 +1,249 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
+	"gophermart/internal/models"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const urlGetUserOrders = "%s/api/orders/%d" // получение информации о расчёте начислений баллов лояльности
+
+// responseError значение метки code для запросов, на которые система расчёта не ответила
+const responseError = "error"
+
+// defaultRetryAfter пауза после ответа 429 без корректного заголовка Retry-After
+const defaultRetryAfter = time.Minute
+
+var tracer = otel.Tracer("gophermart/internal/accrual")
+
+// rateLimitPattern ограничение из тела ответа 429: "No more than N requests per minute allowed"
+var rateLimitPattern = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+// Client клиент системы расчёта начислений, общий для всех воркеров.
+// Частоту запросов ограничивает token bucket, а после ответа 429 весь пул ждёт до срока из Retry-After.
+// Пока система расчёта недоступна, автоматический выключатель не пропускает запросы.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	limiter    *rate.Limiter
+	breaker    *Breaker
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewClient создаёт клиента без ограничения частоты, ограничение берётся из первого ответа 429
+// или задаётся через SetRateLimit
+func NewClient(baseURL string, breakerConfig BreakerConfig) *Client {
+	return &Client{
+		baseURL:    baseURL,
+		httpClient: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		limiter:    rate.NewLimiter(rate.Inf, 1),
+		breaker:    NewBreaker(breakerConfig),
+	}
+}
+
+// Breaker возвращает автоматический выключатель клиента
+func (c *Client) Breaker() *Breaker {
+	return c.breaker
+}
+
+// SetRateLimit ограничивает частоту запросов requestsPerMinute запросами в минуту, 0 снимает ограничение
+func (c *Client) SetRateLimit(requestsPerMinute int) {
+	if requestsPerMinute <= 0 {
+		c.limiter.SetLimit(rate.Inf)
+		return
+	}
+	c.limiter.SetLimit(rate.Limit(float64(requestsPerMinute) / 60))
+}
+
+// RateLimit возвращает текущее ограничение частоты запросов в секунду
+func (c *Client) RateLimit() rate.Limit {
+	return c.limiter.Limit()
+}
+
+// PausedUntil возвращает срок, до которого запросы к системе расчёта приостановлены
+func (c *Client) PausedUntil() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pausedUntil
+}
+
+// pause приостанавливает запросы всех воркеров до until, более ранний срок не сокращает уже назначенную паузу
+func (c *Client) pause(until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until.After(c.pausedUntil) {
+		c.pausedUntil = until
+	}
+}
+
+// wait блокирует запрос до конца паузы и до появления токена в limiter
+func (c *Client) wait(ctx context.Context) error {
+	if delay := time.Until(c.PausedUntil()); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return c.limiter.Wait(ctx)
+}
+
+// GetStatus запрашивает состояние расчёта начислений по заказу. Span запроса включает ожидание
+// ограничителя частоты, а контекст трассы уходит в систему расчёта в заголовке traceparent,
+// идентификатор корреляции из ctx — в X-Request-Id.
+//
+// Ожидание паузы после 429 и ограничителя частоты может быть дольше аренды заказа, поэтому renew,
+// если задан, вызывается после ожидания непосредственно перед запросом: воркер продлевает в нём аренду.
+// Ошибка renew прерывает опрос без запроса к системе расчёта.
+func (c *Client) GetStatus(ctx context.Context, number int64, renew func(ctx context.Context) error) (statusOrders *models.StatusOrdersAccrual, err error) {
+	ctx, span := tracer.Start(ctx, "accrual.GetStatus", trace.WithAttributes(attribute.Int64("gophermart.order", number)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	err = c.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if renew != nil {
+		err = renew(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = c.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf(urlGetUserOrders, c.baseURL, number)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		c.breaker.Cancel()
+		return nil, err
+	}
+	if correlationID := middleware.GetReqID(ctx); correlationID != "" {
+		r.Header.Set(middleware.RequestIDHeader, correlationID)
+	}
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.breaker.Cancel()
+			return nil, err
+		}
+		c.breaker.Failure()
+		metrics.AccrualResponses.WithLabelValues(responseError).Inc()
+		logger.FromContext(ctx).Warn("ошибка запроса", zap.Error(err))
+		return nil, err
+	}
+	defer resp.Body.Close()
+	metrics.AccrualResponses.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.breaker.Failure()
+		logger.FromContext(ctx).Warn("не удалось прочитать данные", zap.Error(err))
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		c.breaker.Failure()
+	} else {
+		c.breaker.Success()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		logger.FromContext(ctx).Warn("заказ не зарегистрирован в системе расчёта")
+		return nil, ErrStatusNoContent
+	case http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		c.pause(time.Now().Add(retryAfter))
+		if limit, ok := parseRateLimit(body); ok {
+			c.SetRateLimit(limit)
+			logger.FromContext(ctx).Warn("система расчёта ограничила частоту запросов", zap.Int("requests_per_minute", limit))
+		}
+		logger.FromContext(ctx).Warn("превышено количество запросов к сервису", zap.Duration("retry_after", retryAfter))
+		return nil, ErrStatusTooManyRequests
+	case http.StatusInternalServerError:
+		logger.FromContext(ctx).Warn("внутренняя ошибка сервера системы расчёта начислений баллов лояльности")
+		return nil, ErrStatusInternalServerError
+	default:
+		logger.FromContext(ctx).Warn("неожиданный ответ системы расчёта", zap.Int("status", resp.StatusCode))
+		return nil, fmt.Errorf("unexpected accrual response status %d", resp.StatusCode)
+	}
+
+	err = json.Unmarshal(body, &statusOrders)
+	if err != nil {
+		logger.FromContext(ctx).Warn("не удалось распорсить запрос", zap.Error(err))
+		return nil, err
+	}
+	metrics.AccrualOrderStatuses.WithLabelValues(statusLabel(statusOrders.Status)).Inc()
+	return statusOrders, nil
+}
+
+// statusLabel значение метки статуса заказа: неизвестные статусы сводятся к одному значению,
+// чтобы ответы системы расчёта не порождали новые ряды метрик
+func statusLabel(status string) string {
+	switch status {
+	case models.AccrualStatusRegistered, models.OrderStatusProcessing, models.OrderStatusInvalid, models.OrderStatusProcessed:
+		return status
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// parseRetryAfter разбирает Retry-After в секундах или в виде HTTP-даты
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// parseRateLimit извлекает из тела ответа 429 допустимое число запросов в минуту
+func parseRateLimit(body []byte) (int, bool) {
+	match := rateLimitPattern.FindSubmatch(body)
+	if match == nil {
+		return 0, false
+	}
+	limit, err := strconv.Atoi(string(match[1]))
+	if err != nil || limit <= 0 {
+		return 0, false
+	}
+	return limit, true
+}
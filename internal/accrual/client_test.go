@@ -0,0 +1,154 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gophermart/internal/logger"
+	"gophermart/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/time/rate"
+)
+
+func TestClientGetStatus(t *testing.T) {
+	logger.Init()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/orders/12345678903":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":729.98}`))
+		case "/api/orders/500":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, DefaultBreakerConfig)
+	ctx := context.Background()
+
+	status, err := client.GetStatus(ctx, 12345678903, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &models.StatusOrdersAccrual{Order: "12345678903", Status: models.OrderStatusProcessed, Accrual: 72998}, status)
+
+	_, err = client.GetStatus(ctx, 1, nil)
+	assert.ErrorIs(t, err, ErrStatusNoContent)
+
+	_, err = client.GetStatus(ctx, 500, nil)
+	assert.ErrorIs(t, err, ErrStatusInternalServerError)
+}
+
+func TestClientTooManyRequestsPausesPool(t *testing.T) {
+	logger.Init()
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("No more than 600 requests per minute allowed"))
+			return
+		}
+		w.Write([]byte(`{"order":"1","status":"PROCESSING"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, DefaultBreakerConfig)
+	ctx := context.Background()
+
+	started := time.Now()
+	_, err := client.GetStatus(ctx, 1, nil)
+	assert.ErrorIs(t, err, ErrStatusTooManyRequests)
+	assert.Equal(t, rate.Limit(10), client.RateLimit(), "ограничение берётся из тела ответа")
+	assert.WithinDuration(t, started.Add(time.Second), client.PausedUntil(), 200*time.Millisecond, "Retry-After задан в секундах")
+
+	// ни один воркер пула не отправляет запрос до конца паузы
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetStatus(ctx, 1, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, int64(1), requests.Load())
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(started), time.Second)
+	assert.Equal(t, int64(4), requests.Load())
+}
+
+func TestClientPropagatesTraceContext(t *testing.T) {
+	logger.Init()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traceparent := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent <- r.Header.Get("traceparent")
+		w.Write([]byte(`{"order":"1","status":"PROCESSING"}`))
+	}))
+	defer server.Close()
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "job")
+	_, err := NewClient(server.URL, DefaultBreakerConfig).GetStatus(ctx, 1, nil)
+	span.End()
+	require.NoError(t, err)
+
+	header := <-traceparent
+	assert.Contains(t, header, span.SpanContext().TraceID().String(), "запрос продолжает трассу воркера")
+
+	var names []string
+	for _, ended := range recorder.Ended() {
+		assert.Equal(t, span.SpanContext().TraceID(), ended.SpanContext().TraceID())
+		names = append(names, ended.Name())
+	}
+	assert.Contains(t, names, "accrual.GetStatus")
+	assert.Contains(t, names, "HTTP GET", "span исходящего запроса")
+}
+
+func TestClientSendsCorrelationID(t *testing.T) {
+	logger.Init()
+	correlationID := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlationID <- r.Header.Get("X-Request-Id")
+		w.Write([]byte(`{"order":"1","status":"PROCESSING"}`))
+	}))
+	defer server.Close()
+
+	ctx := withCorrelation(context.Background(), newCorrelationID("replica"), 1, 1)
+	_, err := NewClient(server.URL, DefaultBreakerConfig).GetStatus(ctx, 1, nil)
+	require.NoError(t, err)
+	assert.Regexp(t, `^replica-\d{6}$`, <-correlationID)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 60*time.Second, parseRetryAfter("60", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("", now))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("soon", now))
+}
+
+func TestParseRateLimit(t *testing.T) {
+	limit, ok := parseRateLimit([]byte("No more than 10 requests per minute allowed\n"))
+	assert.True(t, ok)
+	assert.Equal(t, 10, limit)
+
+	_, ok = parseRateLimit([]byte("Too Many Requests"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gophermart/internal/models"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pemKey(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func newKeySet(t *testing.T, key *Key, verify ...*Key) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(key, verify...)
+	require.NoError(t, err)
+	return ks
+}
+
+func TestIssueVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		alg  string
+		data []byte
+	}{
+		{alg: AlgorithmHS256, data: []byte("secret")},
+		{alg: AlgorithmRS256, data: pemKey(t, rsaKey)},
+		{alg: AlgorithmRS256, data: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})},
+		{alg: AlgorithmEdDSA, data: pemKey(t, edKey)},
+	}
+	for _, test := range tests {
+		t.Run(test.alg, func(t *testing.T) {
+			key, err := ParseKey(test.alg, "", test.data)
+			require.NoError(t, err)
+			assert.NotEmpty(t, key.ID, "kid по отпечатку ключа")
+
+			tokens := NewTokens(newKeySet(t, key), time.Hour, time.Hour)
+			tokenString, err := tokens.Issue("user", models.RoleUser)
+			require.NoError(t, err)
+
+			token, err := tokens.Verify(tokenString)
+			require.NoError(t, err)
+			username, _ := token.Get(ClaimUsername)
+			assert.Equal(t, "user", username)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), token.Expiration(), 2*time.Second)
+			assert.WithinDuration(t, time.Now(), token.IssuedAt(), 2*time.Second)
+			assert.NotEmpty(t, token.JwtID())
+
+			other, err := tokens.Issue("user", models.RoleUser)
+			require.NoError(t, err)
+			otherToken, err := tokens.Verify(other)
+			require.NoError(t, err)
+			assert.NotEqual(t, token.JwtID(), otherToken.JwtID())
+		})
+	}
+
+	_, err = ParseKey(AlgorithmRS256, "", pemKey(t, edKey))
+	assert.ErrorIs(t, err, ErrInvalidKey, "ключ не подходит алгоритму")
+	_, err = ParseKey("none", "", []byte("secret"))
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestVerifyRejects(t *testing.T) {
+	key, err := ParseKey(AlgorithmHS256, "current", []byte("secret"))
+	require.NoError(t, err)
+	tokens := NewTokens(newKeySet(t, key), time.Hour, time.Hour)
+
+	expired := NewTokens(newKeySet(t, key), time.Hour, time.Hour)
+	expired.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	tokenString, err := expired.Issue("user", models.RoleUser)
+	require.NoError(t, err)
+	_, err = tokens.Verify(tokenString)
+	assert.ErrorIs(t, err, jwtauth.ErrExpired)
+
+	// токен без exp, как выпускались токены до появления срока действия
+	withoutExp, err := jwt.Sign(jwt.New(), jwa.HS256, []byte("secret"))
+	require.NoError(t, err)
+	_, err = tokens.Verify(string(withoutExp))
+	assert.ErrorIs(t, err, jwtauth.ErrUnauthorized)
+
+	otherKey, err := ParseKey(AlgorithmHS256, "current", []byte("other"))
+	require.NoError(t, err)
+	forged, err := NewTokens(newKeySet(t, otherKey), time.Hour, time.Hour).Issue("user", models.RoleUser)
+	require.NoError(t, err)
+	_, err = tokens.Verify(forged)
+	assert.ErrorIs(t, err, jwtauth.ErrUnauthorized, "подпись чужим ключом с тем же kid")
+
+	_, err = tokens.Verify("not a token")
+	assert.ErrorIs(t, err, jwtauth.ErrUnauthorized)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, err := ParseKey(AlgorithmHS256, "2024-01", []byte("old secret"))
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	current, err := ParseKey(AlgorithmEdDSA, "2024-02", pemKey(t, edKey))
+	require.NoError(t, err)
+
+	oldToken, err := NewTokens(newKeySet(t, old), time.Hour, time.Hour).Issue("user", models.RoleUser)
+	require.NoError(t, err)
+
+	rotated := NewTokens(newKeySet(t, current, old), time.Hour, time.Hour)
+	_, err = rotated.Verify(oldToken)
+	assert.NoError(t, err, "токен прежнего ключа действует после ротации")
+	newToken, err := rotated.Issue("user", models.RoleUser)
+	require.NoError(t, err)
+	_, err = rotated.Verify(newToken)
+	assert.NoError(t, err)
+
+	retired := NewTokens(newKeySet(t, current), time.Hour, time.Hour)
+	_, err = retired.Verify(oldToken)
+	assert.ErrorIs(t, err, jwtauth.ErrUnauthorized, "прежний ключ убран из набора")
+
+	_, err = NewKeySet(current, current)
+	assert.ErrorIs(t, err, ErrDuplicateKeyID)
+}
+
+func TestParseKeySet(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	set := jwk.NewSet()
+	for kid, raw := range map[string]interface{}{
+		"hs":     []byte("secret"),
+		"ed":     edKey,
+		"rsa":    rsaKey,
+		"rsa-pk": &rsaKey.PublicKey,
+	} {
+		key, err := jwk.New(raw)
+		require.NoError(t, err)
+		require.NoError(t, key.Set(jwk.KeyIDKey, kid))
+		set.Add(key)
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+
+	ks, err := ParseKeySet(data, "ed")
+	require.NoError(t, err)
+	assert.Equal(t, "ed", ks.SigningKey().ID)
+	assert.Equal(t, jwa.EdDSA, ks.SigningKey().Algorithm)
+
+	publicKey, ok := ks.Lookup("rsa-pk")
+	require.True(t, ok)
+	assert.Equal(t, jwa.RS256, publicKey.Algorithm)
+	assert.False(t, publicKey.CanSign(), "открытый ключ только проверяет токены")
+
+	// токен, подписанный закрытым ключом RSA, проверяется открытым ключом из набора
+	signer, err := ParseKey(AlgorithmRS256, "rsa-pk", pemKey(t, rsaKey))
+	require.NoError(t, err)
+	publicOnly := newKeySet(t, signer)
+	tokenString, err := NewTokens(publicOnly, time.Hour, time.Hour).Issue("user", models.RoleUser)
+	require.NoError(t, err)
+	_, err = NewTokens(ks, time.Hour, time.Hour).Verify(tokenString)
+	assert.NoError(t, err)
+
+	_, err = ParseKeySet(data, "rsa-pk")
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+	_, err = ParseKeySet([]byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`), "")
+	assert.ErrorIs(t, err, ErrInvalidKey, "ключ без kid")
+}
+
+func TestVerifier(t *testing.T) {
+	key, err := ParseKey(AlgorithmHS256, "", []byte("secret"))
+	require.NoError(t, err)
+	tokens := NewTokens(newKeySet(t, key), time.Hour, time.Hour)
+	tokenString, err := tokens.Issue("user", models.RoleUser)
+	require.NoError(t, err)
+
+	handler := tokens.Verifier(jwtauth.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, err := jwtauth.FromContext(r.Context())
+		require.NoError(t, err)
+		w.Write([]byte(claims[ClaimUsername].(string)))
+	})))
+
+	for _, test := range []struct {
+		name          string
+		authorization string
+		code          int
+	}{
+		{name: "действующий токен", authorization: "Bearer " + tokenString, code: http.StatusOK},
+		{name: "без токена", code: http.StatusUnauthorized},
+		{name: "неверный токен", authorization: "Bearer " + tokenString + "x", code: http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, test.code, w.Code)
+			if test.code == http.StatusOK {
+				assert.Equal(t, "user", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	key, err := ParseKey(AlgorithmHS256, "", []byte("secret"))
+	require.NoError(t, err)
+	tokens := NewTokens(newKeySet(t, key), time.Minute, time.Hour)
+
+	token, hash, expiresAt, err := tokens.NewRefreshToken()
+	require.NoError(t, err)
+	assert.Equal(t, HashToken(token), hash)
+	assert.NotEqual(t, token, hash, "в базе данных хранится только хеш")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Second)
+
+	other, _, _, err := tokens.NewRefreshToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
+
+func TestRejectRevoked(t *testing.T) {
+	key, err := ParseKey(AlgorithmHS256, "", []byte("secret"))
+	require.NoError(t, err)
+	tokens := NewTokens(newKeySet(t, key), time.Hour, time.Hour)
+	active, err := tokens.Issue("user", models.RoleUser)
+	require.NoError(t, err)
+	revoked, err := tokens.Issue("user", models.RoleUser)
+	require.NoError(t, err)
+	revokedToken, err := tokens.Verify(revoked)
+	require.NoError(t, err)
+
+	isRevoked := func(ctx context.Context, jti string) (bool, error) {
+		return jti == revokedToken.JwtID(), nil
+	}
+	handler := tokens.Verifier(RejectRevoked(isRevoked)(jwtauth.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+
+	for tokenString, code := range map[string]int{active: http.StatusOK, revoked: http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	key, err := ParseKey(AlgorithmHS256, "", []byte("secret"))
+	require.NoError(t, err)
+	tokens := NewTokens(newKeySet(t, key), time.Hour, time.Hour)
+	handler := tokens.Verifier(jwtauth.Authenticator(RequireRole(models.RoleSupport, models.RoleAdmin)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+
+	// токен без claim роли, как выпускались токены до появления ролей
+	legacy := jwt.New()
+	require.NoError(t, legacy.Set(ClaimUsername, "user"))
+	require.NoError(t, legacy.Set(jwt.ExpirationKey, time.Now().Add(time.Hour).Unix()))
+	headers := jws.NewHeaders()
+	require.NoError(t, headers.Set(jws.KeyIDKey, key.ID))
+	legacyToken, err := jwt.Sign(legacy, jwa.HS256, []byte("secret"), jwt.WithHeaders(headers))
+	require.NoError(t, err)
+
+	for role, code := range map[string]int{
+		models.RoleUser:    http.StatusForbidden,
+		models.RoleSupport: http.StatusOK,
+		models.RoleAdmin:   http.StatusOK,
+		"":                 http.StatusForbidden,
+	} {
+		tokenString := string(legacyToken)
+		if role != "" {
+			tokenString, err = tokens.Issue("user", role)
+			require.NoError(t, err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code, role)
+	}
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gophermart/internal/store"
+)
+
+// LoginPolicy ограничения попыток входа
+type LoginPolicy struct {
+	Window      time.Duration // скользящее окно подсчёта неудачных попыток
+	LoginLimit  int           // неудачных попыток по одному логину в окне
+	IPLimit     int           // неудачных попыток с одного адреса в окне
+	MaxFailures int           // неудачных входов подряд до блокировки пользователя
+	Lockout     time.Duration // длительность блокировки
+}
+
+var DefaultLoginPolicy = LoginPolicy{
+	Window:      time.Minute,
+	LoginLimit:  5,
+	IPLimit:     20,
+	MaxFailures: 10,
+	Lockout:     15 * time.Minute,
+}
+
+// LoginGuard ограничивает перебор паролей: неудачные попытки по логину и по адресу считаются в скользящем окне,
+// а после MaxFailures неудачных входов подряд пользователь блокируется. Попытка занимает место в окне ещё до
+// проверки пароля и освобождает его при успешном входе. Счётчики хранятся в хранилище, поэтому ограничения
+// общие для всех реплик.
+type LoginGuard struct {
+	storage *store.StorageContext
+	now     func() time.Time
+
+	mu     sync.RWMutex
+	policy LoginPolicy
+}
+
+func NewLoginGuard(storage *store.StorageContext, policy LoginPolicy) *LoginGuard {
+	return &LoginGuard{storage: storage, policy: policy, now: time.Now}
+}
+
+// SetPolicy заменяет ограничения попыток входа. Уже учтённые неудачные попытки не сбрасываются.
+func (g *LoginGuard) SetPolicy(policy LoginPolicy) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.policy = policy
+}
+
+// Policy возвращает текущие ограничения попыток входа
+func (g *LoginGuard) Policy() LoginPolicy {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.policy
+}
+
+// Attempt попытка входа, учтённая LoginGuard.Reserve до проверки пароля
+type Attempt struct {
+	login string
+	ip    string
+	at    time.Time
+}
+
+// Reserve учитывает попытку входа пользователя login с адреса ip до проверки пароля. Попытка занимает место
+// в окнах по логину и по адресу атомарно, поэтому параллельные запросы с неверным паролем не обходят ограничения.
+// Если вход сейчас запрещён, попытка не учитывается, а возвращается, через сколько можно повторить вход.
+func (g *LoginGuard) Reserve(ctx context.Context, login string, ip string) (Attempt, time.Duration, error) {
+	now := g.now()
+	policy := g.Policy()
+
+	lockedUntil, err := g.storage.GetUserLockedUntil(ctx, login)
+	if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+		return Attempt{}, 0, err
+	}
+	if lockedUntil.After(now) {
+		return Attempt{}, lockedUntil.Sub(now), nil
+	}
+
+	attempt := Attempt{login: login, ip: ip, at: now}
+	reserved, oldest, err := g.storage.ReserveLoginAttempt(ctx, loginKey(login), now, policy.Window, policy.LoginLimit)
+	if err != nil || !reserved {
+		return Attempt{}, retryAfter(oldest, policy.Window, now), err
+	}
+	reserved, oldest, err = g.storage.ReserveLoginAttempt(ctx, ipKey(ip), now, policy.Window, policy.IPLimit)
+	if err != nil || !reserved {
+		releaseErr := g.storage.ReleaseLoginAttempt(ctx, loginKey(login), now)
+		return Attempt{}, retryAfter(oldest, policy.Window, now), errors.Join(err, releaseErr)
+	}
+	return attempt, 0, nil
+}
+
+// retryAfter через сколько освободится место в окне: попытки выходят из окна по очереди, первой — самая ранняя
+func retryAfter(oldest time.Time, window time.Duration, now time.Time) time.Duration {
+	wait := oldest.Add(window).Sub(now)
+	if wait < time.Second {
+		return time.Second
+	}
+	return wait
+}
+
+// Failure оставляет попытку учтённой как неудачную и возвращает срок блокировки пользователя,
+// если она назначена этой попыткой
+func (g *LoginGuard) Failure(ctx context.Context, attempt Attempt) (time.Time, error) {
+	policy := g.Policy()
+	lockedUntil, err := g.storage.RecordFailedLogin(ctx, attempt.login, policy.MaxFailures, policy.Lockout)
+	if errors.Is(err, store.ErrUserNotFound) {
+		return time.Time{}, nil
+	}
+	return lockedUntil, err
+}
+
+// Success отменяет попытку и сбрасывает счётчик неудачных входов подряд
+func (g *LoginGuard) Success(ctx context.Context, attempt Attempt) error {
+	err := g.Release(ctx, attempt)
+	if err != nil {
+		return err
+	}
+	return g.storage.ResetFailedLogins(ctx, attempt.login)
+}
+
+// Release отменяет попытку, которая не дошла до проверки пароля или завершилась внутренней ошибкой
+func (g *LoginGuard) Release(ctx context.Context, attempt Attempt) error {
+	return errors.Join(
+		g.storage.ReleaseLoginAttempt(ctx, loginKey(attempt.login), attempt.at),
+		g.storage.ReleaseLoginAttempt(ctx, ipKey(attempt.ip), attempt.at),
+	)
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
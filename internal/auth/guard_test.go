@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gophermart/internal/store"
+	"gophermart/internal/store/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fail учитывает неудачный вход пользователя login с адреса ip
+func fail(t *testing.T, guard *LoginGuard, login string, ip string) time.Time {
+	t.Helper()
+	attempt, wait, err := guard.Reserve(context.Background(), login, ip)
+	require.NoError(t, err)
+	require.Zero(t, wait)
+	lockedUntil, err := guard.Failure(context.Background(), attempt)
+	require.NoError(t, err)
+	return lockedUntil
+}
+
+func TestLoginGuard(t *testing.T) {
+	ctx := context.Background()
+	db := memory.NewStorage()
+	require.NoError(t, db.UserRegister(ctx, "user", "password"))
+	storage := &store.StorageContext{}
+	storage.SetStorage(db)
+
+	now := time.Now()
+	guard := NewLoginGuard(storage, LoginPolicy{
+		Window:      time.Minute,
+		LoginLimit:  2,
+		IPLimit:     3,
+		MaxFailures: 4,
+		Lockout:     time.Hour,
+	})
+	guard.now = func() time.Time { return now }
+
+	// лимит по логину
+	for i := 0; i < 2; i++ {
+		fail(t, guard, "user", "10.0.0.1")
+	}
+	_, wait, err := guard.Reserve(ctx, "user", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, wait)
+
+	// лимит по адресу действует для других логинов, отклонённая попытка не занимает место по логину
+	fail(t, guard, "nobody", "10.0.0.1")
+	_, wait, err = guard.Reserve(ctx, "other", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, wait)
+	attempt, wait, err := guard.Reserve(ctx, "other", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	require.NoError(t, guard.Release(ctx, attempt))
+
+	// попытки выходят из окна
+	now = now.Add(time.Minute + time.Second)
+	attempt, wait, err = guard.Reserve(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	require.NoError(t, guard.Release(ctx, attempt))
+
+	// блокировка после неудачных входов подряд
+	fail(t, guard, "user", "10.0.0.3")
+	lockedUntil := fail(t, guard, "user", "10.0.0.4")
+	assert.False(t, lockedUntil.IsZero())
+	_, wait, err = guard.Reserve(ctx, "user", "10.0.0.5")
+	require.NoError(t, err)
+	assert.Greater(t, wait, 50*time.Minute)
+
+	require.NoError(t, storage.UnlockUser(ctx, "user"))
+	_, wait, err = guard.Reserve(ctx, "user", "10.0.0.5")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, wait, time.Minute, "после разблокировки остаётся только окно попыток")
+}
+
+func TestLoginGuardSuccess(t *testing.T) {
+	ctx := context.Background()
+	db := memory.NewStorage()
+	require.NoError(t, db.UserRegister(ctx, "user", "password"))
+	storage := &store.StorageContext{}
+	storage.SetStorage(db)
+	guard := NewLoginGuard(storage, LoginPolicy{Window: time.Minute, LoginLimit: 2, IPLimit: 100, MaxFailures: 2, Lockout: time.Hour})
+
+	fail(t, guard, "user", "10.0.0.1")
+	attempt, wait, err := guard.Reserve(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+	require.NoError(t, guard.Success(ctx, attempt))
+
+	// успешный вход не занимает место в окне и сбрасывает счётчик входов подряд
+	lockedUntil := fail(t, guard, "user", "10.0.0.1")
+	assert.True(t, lockedUntil.IsZero(), "успешный вход сбрасывает счётчик")
+}
+
+func TestLoginGuardSetPolicy(t *testing.T) {
+	ctx := context.Background()
+	storage := &store.StorageContext{}
+	storage.SetStorage(memory.NewStorage())
+	guard := NewLoginGuard(storage, LoginPolicy{Window: time.Minute, LoginLimit: 3, IPLimit: 100, MaxFailures: 100, Lockout: time.Hour})
+
+	fail(t, guard, "user", "10.0.0.1")
+	fail(t, guard, "user", "10.0.0.1")
+
+	// учтённые попытки сохраняются, новый лимит действует сразу
+	guard.SetPolicy(LoginPolicy{Window: time.Minute, LoginLimit: 2, IPLimit: 100, MaxFailures: 100, Lockout: time.Hour})
+	_, wait, err := guard.Reserve(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Greater(t, wait, time.Duration(0))
+}
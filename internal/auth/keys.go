@@ -0,0 +1,232 @@
+// Package auth выпуск и проверка токенов доступа.
+//
+// Токены подписываются ключом из KeySet и несут в заголовке kid этого ключа, поэтому после ротации
+// токены, подписанные прежним ключом, проверяются, пока прежний ключ остаётся в наборе.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// Поддерживаемые алгоритмы подписи токенов
+const (
+	AlgorithmHS256 = string(jwa.HS256)
+	AlgorithmRS256 = string(jwa.RS256)
+	AlgorithmEdDSA = string(jwa.EdDSA)
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported token signing algorithm")
+var ErrInvalidKey = errors.New("invalid token signing key")
+var ErrNoSigningKey = errors.New("no token signing key")
+var ErrDuplicateKeyID = errors.New("duplicate token key id")
+
+// Key ключ подписи и проверки токенов
+type Key struct {
+	ID        string
+	Algorithm jwa.SignatureAlgorithm
+	// signKey nil у ключа, который только проверяет токены, выпущенные до ротации
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// CanSign сообщает, что ключом можно подписывать токены
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// KeySet ключ, которым подписываются новые токены, и ключи проверки токенов по kid
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet собирает набор из ключа подписи signing и ключей verify, которые только проверяют токены
+func NewKeySet(signing *Key, verify ...*Key) (*KeySet, error) {
+	if signing == nil || !signing.CanSign() {
+		return nil, ErrNoSigningKey
+	}
+	ks := &KeySet{signing: signing, keys: map[string]*Key{signing.ID: signing}}
+	for _, key := range verify {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateKeyID, key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+	return ks, nil
+}
+
+// SigningKey возвращает ключ, которым подписываются новые токены
+func (ks *KeySet) SigningKey() *Key {
+	return ks.signing
+}
+
+// Lookup возвращает ключ проверки токенов по kid
+func (ks *KeySet) Lookup(kid string) (*Key, bool) {
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// GenerateKey создаёт случайный секрет HS256. Выпущенные с ним токены перестают действовать
+// после перезапуска сервиса и не проверяются другими репликами.
+func GenerateKey() (*Key, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(AlgorithmHS256, "", secret)
+}
+
+// ParseKey разбирает ключ подписи алгоритма alg: секрет для HS256, закрытый ключ RSA в PEM (PKCS#1 или PKCS#8)
+// для RS256 и закрытый ключ Ed25519 в PEM (PKCS#8) для EdDSA. Пустой kid заменяется отпечатком ключа.
+func ParseKey(alg string, kid string, data []byte) (*Key, error) {
+	var signKey interface{}
+	switch alg {
+	case AlgorithmHS256:
+		if len(data) == 0 {
+			return nil, ErrInvalidKey
+		}
+		signKey = data
+	case AlgorithmRS256, AlgorithmEdDSA:
+		privateKey, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		signKey = privateKey
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+	return newKey(jwa.SignatureAlgorithm(alg), kid, signKey, nil)
+}
+
+// ParseKeySet разбирает набор ключей в формате JWK Set (RFC 7517). У каждого ключа должен быть kid,
+// алгоритм берётся из alg или из типа ключа. Новые токены подписываются ключом signingKID,
+// а если он не задан — первым закрытым ключом набора. Открытые ключи только проверяют токены.
+func ParseKeySet(data []byte, signingKID string) (*KeySet, error) {
+	set, err := jwk.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	var signing *Key
+	var verify []*Key
+	ctx := context.Background()
+	for iter := set.Iterate(ctx); iter.Next(ctx); {
+		key, err := fromJWK(iter.Pair().Value.(jwk.Key))
+		if err != nil {
+			return nil, err
+		}
+		isSigning := key.CanSign() && signing == nil && (signingKID == "" || key.ID == signingKID)
+		if isSigning {
+			signing = key
+		} else {
+			verify = append(verify, key)
+		}
+	}
+	return NewKeySet(signing, verify...)
+}
+
+func fromJWK(key jwk.Key) (*Key, error) {
+	if key.KeyID() == "" {
+		return nil, fmt.Errorf("%w: key without kid", ErrInvalidKey)
+	}
+	var raw interface{}
+	err := key.Raw(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, key.KeyID(), err)
+	}
+
+	alg := jwa.SignatureAlgorithm(key.Algorithm())
+	if alg == "" {
+		switch key.KeyType() {
+		case jwa.OctetSeq:
+			alg = jwa.HS256
+		case jwa.RSA:
+			alg = jwa.RS256
+		case jwa.OKP:
+			alg = jwa.EdDSA
+		}
+	}
+	switch alg {
+	case jwa.HS256:
+		return newKey(alg, key.KeyID(), raw, nil)
+	case jwa.RS256, jwa.EdDSA:
+		if _, ok := raw.(crypto.Signer); ok {
+			return newKey(alg, key.KeyID(), raw, nil)
+		}
+		return newKey(alg, key.KeyID(), nil, raw)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+// newKey проверяет, что ключ подходит алгоритму, и вычисляет ключ проверки по ключу подписи
+func newKey(alg jwa.SignatureAlgorithm, kid string, signKey interface{}, verifyKey interface{}) (*Key, error) {
+	if signKey != nil {
+		switch k := signKey.(type) {
+		case []byte:
+			verifyKey = k
+		case crypto.Signer:
+			verifyKey = k.Public()
+		}
+	}
+
+	var fingerprint []byte
+	switch k := verifyKey.(type) {
+	case []byte:
+		if alg != jwa.HS256 || len(k) == 0 {
+			return nil, ErrInvalidKey
+		}
+		fingerprint = k
+	case *rsa.PublicKey:
+		if alg != jwa.RS256 {
+			return nil, ErrInvalidKey
+		}
+		fingerprint = x509.MarshalPKCS1PublicKey(k)
+	case ed25519.PublicKey:
+		if alg != jwa.EdDSA {
+			return nil, ErrInvalidKey
+		}
+		fingerprint = k
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrInvalidKey, verifyKey)
+	}
+
+	if kid == "" {
+		sum := sha256.Sum256(fingerprint)
+		kid = hex.EncodeToString(sum[:8])
+	}
+	return &Key{ID: kid, Algorithm: alg, signKey: signKey, verifyKey: verifyKey}, nil
+}
+
+func parsePrivateKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block", ErrInvalidKey)
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLoginLength ограничение столбца users.login
+const MaxLoginLength = 40
+
+// maxPasswordBytes bcrypt учитывает только первые 72 байта пароля
+const maxPasswordBytes = 72
+
+var ErrInvalidLogin = errors.New("invalid login")
+var ErrWeakPassword = errors.New("password does not satisfy the policy")
+
+// PasswordPolicy требования к паролю
+type PasswordPolicy struct {
+	MinLength      int  // минимальная длина в символах
+	RequireUpper   bool // хотя бы одна заглавная буква
+	RequireLower   bool // хотя бы одна строчная буква
+	RequireDigit   bool // хотя бы одна цифра
+	RequireSpecial bool // хотя бы один символ, кроме букв и цифр
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8}
+
+// Validate проверяет пароль пользователя login, пустой login не сравнивается с паролем. Ошибка оборачивает ErrWeakPassword и называет нарушенное требование.
+func (p PasswordPolicy) Validate(login string, password string) error {
+	if password == "" {
+		return fmt.Errorf("%w: empty", ErrWeakPassword)
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: shorter than %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: longer than %d bytes", ErrWeakPassword, maxPasswordBytes)
+	}
+	if login != "" && strings.EqualFold(password, login) {
+		return fmt.Errorf("%w: same as login", ErrWeakPassword)
+	}
+
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			special = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !upper:
+		return fmt.Errorf("%w: no upper case letter", ErrWeakPassword)
+	case p.RequireLower && !lower:
+		return fmt.Errorf("%w: no lower case letter", ErrWeakPassword)
+	case p.RequireDigit && !digit:
+		return fmt.Errorf("%w: no digit", ErrWeakPassword)
+	case p.RequireSpecial && !special:
+		return fmt.Errorf("%w: no special character", ErrWeakPassword)
+	}
+	return nil
+}
+
+// NormalizeLogin приводит логин к виду, в котором он хранится: без пробелов по краям и в нижнем регистре
+func NormalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// ValidateLogin проверяет нормализованный логин: от 3 до MaxLoginLength символов,
+// буквы, цифры и символы «.», «_», «-», «@»
+func ValidateLogin(login string) error {
+	length := utf8.RuneCountInString(login)
+	if length < 3 || length > MaxLoginLength {
+		return fmt.Errorf("%w: length must be between 3 and %d characters", ErrInvalidLogin, MaxLoginLength)
+	}
+	for _, r := range login {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-@", r) {
+			return fmt.Errorf("%w: unexpected character %q", ErrInvalidLogin, r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	strict := PasswordPolicy{MinLength: 8, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSpecial: true}
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		login    string
+		password string
+		valid    bool
+	}{
+		{name: "достаточная длина", policy: DefaultPasswordPolicy, login: "user", password: "correct horse", valid: true},
+		{name: "длина в символах, а не в байтах", policy: DefaultPasswordPolicy, login: "user", password: "пароль12", valid: true},
+		{name: "пустой пароль", policy: PasswordPolicy{}, login: "user", password: ""},
+		{name: "короткий пароль", policy: DefaultPasswordPolicy, login: "user", password: "short"},
+		{name: "длиннее 72 байт", policy: DefaultPasswordPolicy, login: "user", password: strings.Repeat("a", 73)},
+		{name: "совпадает с логином", policy: DefaultPasswordPolicy, login: "username", password: "UserName"},
+		{name: "все классы символов", policy: strict, login: "user", password: "Passw0rd!", valid: true},
+		{name: "без заглавной буквы", policy: strict, login: "user", password: "passw0rd!"},
+		{name: "без строчной буквы", policy: strict, login: "user", password: "PASSW0RD!"},
+		{name: "без цифры", policy: strict, login: "user", password: "Password!"},
+		{name: "без спецсимвола", policy: strict, login: "user", password: "Passw0rd"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Validate(test.login, test.password)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrWeakPassword)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	assert.Equal(t, "alice@example.com", NormalizeLogin("  Alice@Example.COM "))
+
+	for login, valid := range map[string]bool{
+		"alice":                 true,
+		"иван.петров":           true,
+		"a_b-c@d.e":             true,
+		"ab":                    false,
+		"alice smith":           false,
+		"alice;drop":            false,
+		strings.Repeat("я", 40): true,
+		strings.Repeat("я", 41): false,
+	} {
+		err := ValidateLogin(login)
+		if valid {
+			assert.NoError(t, err, login)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidLogin, login)
+		}
+	}
+}
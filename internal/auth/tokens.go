@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"gophermart/internal/models"
+
+	"github.com/go-chi/jwtauth"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// ClaimUsername claim с логином пользователя
+const ClaimUsername = "username"
+
+// ClaimRole claim с ролью пользователя. Токены, выпущенные до появления ролей, его не содержат
+// и считаются токенами роли models.RoleUser.
+const ClaimRole = "role"
+
+// Tokens выпускает токены доступа и проверяет их по набору ключей.
+// Токены обновления непрозрачны и хранятся в базе данных только в виде хеша.
+type Tokens struct {
+	keys       *KeySet
+	ttl        time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+// NewTokens создаёт выпуск токенов доступа, действующих ttl, и токенов обновления, действующих refreshTTL
+func NewTokens(keys *KeySet, ttl time.Duration, refreshTTL time.Duration) *Tokens {
+	return &Tokens{keys: keys, ttl: ttl, refreshTTL: refreshTTL, now: time.Now}
+}
+
+// TTL возвращает срок действия токенов доступа
+func (t *Tokens) TTL() time.Duration {
+	return t.ttl
+}
+
+// NewRefreshToken создаёт токен обновления и возвращает его, хеш для хранения и срок действия
+func (t *Tokens) NewRefreshToken() (token string, hash string, expiresAt time.Time, err error) {
+	token, hash, err = NewOpaqueToken()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, hash, t.now().Add(t.refreshTTL), nil
+}
+
+// NewOpaqueToken создаёт случайный непрозрачный токен и возвращает его и хеш для хранения
+func NewOpaqueToken() (token string, hash string, err error) {
+	raw := make([]byte, 32)
+	_, err = rand.Read(raw)
+	if err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashToken(token), nil
+}
+
+// HashToken возвращает хеш, под которым непрозрачный токен хранится в базе данных
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue выпускает токен доступа пользователя login с ролью role и claims exp, iat и jti
+func (t *Tokens) Issue(login string, role string) (string, error) {
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return "", err
+	}
+	now := t.now()
+
+	token := jwt.New()
+	for claim, value := range map[string]interface{}{
+		ClaimUsername:     login,
+		ClaimRole:         role,
+		jwt.IssuedAtKey:   now.Unix(),
+		jwt.ExpirationKey: now.Add(t.ttl).Unix(),
+		jwt.JwtIDKey:      hex.EncodeToString(jti),
+	} {
+		err = token.Set(claim, value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	key := t.keys.SigningKey()
+	headers := jws.NewHeaders()
+	err = headers.Set(jws.KeyIDKey, key.ID)
+	if err != nil {
+		return "", err
+	}
+	signed, err := jwt.Sign(token, key.Algorithm, key.signKey, jwt.WithHeaders(headers))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// Verify проверяет подпись токена ключом из его kid и сроки действия.
+// Ошибки совпадают с ошибками jwtauth: ErrUnauthorized, ErrExpired и другие.
+func (t *Tokens) Verify(tokenString string) (jwt.Token, error) {
+	message, err := jws.Parse([]byte(tokenString))
+	if err != nil || len(message.Signatures()) != 1 {
+		return nil, jwtauth.ErrUnauthorized
+	}
+	headers := message.Signatures()[0].ProtectedHeaders()
+	key, ok := t.keys.Lookup(headers.KeyID())
+	if !ok {
+		return nil, jwtauth.ErrUnauthorized
+	}
+	// алгоритм задаёт ключ, а не заголовок токена
+	if headers.Algorithm() != key.Algorithm {
+		return nil, jwtauth.ErrAlgoInvalid
+	}
+
+	token, err := jwt.Parse([]byte(tokenString), jwt.WithVerify(key.Algorithm, key.verifyKey))
+	if err != nil {
+		return nil, jwtauth.ErrUnauthorized
+	}
+	if token.Expiration().IsZero() {
+		return nil, jwtauth.ErrUnauthorized
+	}
+	err = jwt.Validate(token, jwt.WithClock(jwt.ClockFunc(t.now)))
+	if err != nil {
+		return nil, jwtauth.ErrorReason(err)
+	}
+	return token, nil
+}
+
+// Verifier проверяет токен из заголовка Authorization или cookie jwt и кладёт результат в контекст запроса,
+// откуда его читают jwtauth.Authenticator и jwtauth.FromContext
+func (t *Tokens) Verifier(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := jwtauth.TokenFromHeader(r)
+		if tokenString == "" {
+			tokenString = jwtauth.TokenFromCookie(r)
+		}
+
+		var token jwt.Token
+		err := jwtauth.ErrNoTokenFound
+		if tokenString != "" {
+			token, err = t.Verify(tokenString)
+		}
+		next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), token, err)))
+	})
+}
+
+// RequireRole пропускает запросы только с токеном одной из ролей roles, остальным отвечает 403.
+// Роль берётся из токена и меняется у пользователя со следующим выпуском токена доступа.
+// Ставится после jwtauth.Authenticator.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, claims, err := jwtauth.FromContext(r.Context())
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			role := Role(claims)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+}
+
+// Role возвращает роль из claims токена доступа
+func Role(claims map[string]interface{}) string {
+	role, ok := claims[ClaimRole].(string)
+	if !ok || role == "" {
+		return models.RoleUser
+	}
+	return role
+}
+
+// RejectRevoked отклоняет токены доступа, отозванные до истечения срока действия.
+// Ставится между Verifier и jwtauth.Authenticator.
+func RejectRevoked(isRevoked func(ctx context.Context, jti string) (bool, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			token, _, err := jwtauth.FromContext(ctx)
+			if err == nil && token != nil {
+				revoked, err := isRevoked(ctx, token.JwtID())
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if revoked {
+					ctx = jwtauth.NewContext(ctx, token, jwtauth.ErrUnauthorized)
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package configure
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnknownFileFormat = errors.New("unknown config file format, expected .yaml, .yml or .toml")
+	ErrUnknownSetting    = errors.New("unknown setting")
+	ErrInvalidValue      = errors.New("invalid setting value")
+)
+
+// readFile читает файл настроек YAML или TOML. Ключи — имена настроек в нижнем регистре, вложенные разделы
+// склеиваются через подчёркивание: accrual: {breaker_failures: 5} — то же, что accrual_breaker_failures: 5.
+// Возвращает значения строками под именами переменных окружения.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFileFormat, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, f := range fields() {
+		known[f.name] = true
+	}
+	values := make(map[string]string)
+	err = flatten("", raw, known, values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]any, known map[string]bool, values map[string]string) error {
+	for key, value := range raw {
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		if section, ok := value.(map[string]any); ok {
+			err := flatten(name, section, known, values)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !known[name] {
+			return fmt.Errorf("%w: %s", ErrUnknownSetting, strings.ToLower(name))
+		}
+
+		switch v := value.(type) {
+		case nil:
+		case string:
+			values[name] = v
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		case int, int64, uint64:
+			values[name] = fmt.Sprint(v)
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("%w: %s", ErrInvalidValue, strings.ToLower(name))
+		}
+	}
+	return nil
+}
+
+// dsnPassword пароль в строке подключения PostgreSQL без схемы
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// Print печатает настройки cfg в формате файла настроек YAML. Секреты заменяются звёздочками,
+// пароль в адресе базы данных — на xxxxx.
+func (cfg *Config) Print(w io.Writer) error {
+	value := reflect.ValueOf(cfg).Elem()
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range fields() {
+		node := &yaml.Node{Kind: yaml.ScalarNode}
+		switch v := value.Field(f.index).Interface().(type) {
+		case string:
+			node.Tag, node.Value = "!!str", maskSecret(f.secret, v)
+		case time.Duration:
+			node.Tag, node.Value = "!!str", v.String()
+		default:
+			node.Value = fmt.Sprint(v)
+		}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: strings.ToLower(f.name)}, node)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	defer encoder.Close()
+	return encoder.Encode(root)
+}
+
+func maskSecret(secret string, value string) string {
+	switch {
+	case value == "":
+		return value
+	case secret == "true":
+		return "***"
+	case secret == "password":
+		if u, err := url.Parse(value); err == nil && strings.Contains(value, "://") {
+			if _, ok := u.User.Password(); ok {
+				return u.Redacted()
+			}
+			return value
+		}
+		return dsnPassword.ReplaceAllString(value, "${1}xxxxx")
+	default:
+		return value
+	}
+}
@@ -0,0 +1,198 @@
+package configure
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gophermart/internal/auth"
+	"gophermart/internal/logger"
+	"gophermart/internal/tracing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// maxPasswordLength bcrypt учитывает только первые 72 байта пароля
+const maxPasswordLength = 72
+
+var (
+	ErrRequired     = errors.New("is required")
+	ErrNotPositive  = errors.New("must be positive")
+	ErrNegative     = errors.New("must not be negative")
+	ErrOutOfRange   = errors.New("is out of range")
+	ErrNotAllowed   = errors.New("is not one of allowed values")
+	ErrInvalidURL   = errors.New("must be an http or https URL with a host")
+	ErrInvalidDSN   = errors.New("must be postgres://, sqlite://, memory:// or a PostgreSQL key=value connection string")
+	ErrInvalidAddr  = errors.New("must be host:port")
+	ErrShorterTTL   = errors.New("must be longer than JWT_TTL")
+	ErrFileNotFound = errors.New("file does not exist")
+)
+
+// Validate проверяет все настройки сервиса и возвращает все найденные ошибки разом
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	check("RUN_ADDRESS", validateAddress(cfg.RunAddress))
+	check("DATABASE_URI", validateDatabaseURI(cfg.DatabaseURI))
+	check("ACCRUAL_SYSTEM_ADDRESS", validateURL(cfg.AccrualSystemAddress))
+	check("ACCRUAL_WORKERS", positive(cfg.AccrualWorkers))
+	check("ACCRUAL_QUEUE_SIZE", positive(cfg.AccrualQueueSize))
+	check("ACCRUAL_RATE_LIMIT", nonNegative(cfg.AccrualRateLimit))
+	check("ACCRUAL_BREAKER_FAILURES", positive(cfg.AccrualBreakerFailures))
+	check("ACCRUAL_BREAKER_OPEN_TIMEOUT", positive(cfg.AccrualBreakerOpenTimeout))
+	check("ACCRUAL_BREAKER_HALF_OPEN_REQUESTS", positive(cfg.AccrualBreakerHalfOpenRequests))
+	check("ACCRUAL_POLL_INTERVAL", nonNegative(cfg.AccrualPollInterval))
+
+	check("JWT_ALGORITHM", oneOf(cfg.JWTAlgorithm, auth.AlgorithmHS256, auth.AlgorithmRS256, auth.AlgorithmEdDSA))
+	check("JWT_KEY_FILE", fileExists(cfg.JWTKeyFile))
+	check("JWT_KEYS_FILE", fileExists(cfg.JWTKeysFile))
+	check("JWT_TTL", positive(cfg.JWTTTL))
+	check("JWT_REFRESH_TTL", positive(cfg.JWTRefreshTTL))
+	if cfg.JWTTTL > 0 && cfg.JWTRefreshTTL > 0 && cfg.JWTRefreshTTL <= cfg.JWTTTL {
+		check("JWT_REFRESH_TTL", ErrShorterTTL)
+	}
+
+	check("PASSWORD_MIN_LENGTH", inRange(cfg.PasswordMinLength, 1, maxPasswordLength))
+	check("PASSWORD_RESET_TTL", positive(cfg.PasswordResetTTL))
+	if cfg.PasswordResetNotifier != "" {
+		check("PASSWORD_RESET_NOTIFIER", oneOf(cfg.PasswordResetNotifier, "file"))
+	}
+	if cfg.PasswordResetNotifier == "file" && cfg.PasswordResetFile == "" {
+		check("PASSWORD_RESET_FILE", ErrRequired)
+	}
+
+	check("LOGIN_WINDOW", positive(cfg.LoginWindow))
+	check("LOGIN_LIMIT", positive(cfg.LoginLimit))
+	check("LOGIN_IP_LIMIT", positive(cfg.LoginIPLimit))
+	check("LOGIN_MAX_FAILURES", positive(cfg.LoginMaxFailures))
+	check("LOGIN_LOCKOUT", positive(cfg.LoginLockout))
+
+	check("HTTP_TIMEOUT", positive(cfg.HTTPTimeout))
+	check("HTTP_LONG_TIMEOUT", positive(cfg.HTTPLongTimeout))
+	check("HTTP_COMPRESS_LEVEL", inRange(cfg.HTTPCompressLevel, 1, 9))
+
+	if _, err := zapcore.ParseLevel(cfg.LogLevel); err != nil {
+		check("LOG_LEVEL", fmt.Errorf("%w: %q", ErrNotAllowed, cfg.LogLevel))
+	}
+	check("LOG_FORMAT", oneOf(cfg.LogFormat, logger.FormatJSON, logger.FormatConsole))
+
+	check("TRACING_EXPORTER", oneOf(cfg.TracingExporter, tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP))
+	if cfg.TracingEndpoint != "" {
+		check("TRACING_OTLP_ENDPOINT", validateURL(cfg.TracingEndpoint))
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		check("TRACING_SAMPLE_RATIO", fmt.Errorf("%w: %v not in [0, 1]", ErrOutOfRange, cfg.TracingSampleRatio))
+	}
+
+	check("SHUTDOWN_TIMEOUT", positive(cfg.ShutdownTimeout))
+	check("SHUTDOWN_DRAIN_DELAY", nonNegative(cfg.ShutdownDrainDelay))
+	return errors.Join(errs...)
+}
+
+// validateAddress проверяет адрес запуска сервиса host:port, хост может быть пустым
+func validateAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidAddr, address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%w: port %q", ErrInvalidAddr, port)
+	}
+	return nil
+}
+
+// validateDatabaseURI проверяет DATABASE_URI по правилам DatabaseDriver
+func validateDatabaseURI(uri string) error {
+	if uri == "" {
+		return ErrRequired
+	}
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		// строка подключения PostgreSQL вида host=localhost dbname=praktikum
+		for _, pair := range strings.Fields(uri) {
+			if key, _, ok := strings.Cut(pair, "="); !ok || key == "" {
+				return ErrInvalidDSN
+			}
+		}
+		return nil
+	}
+	switch scheme {
+	case "postgres", "postgresql":
+		_, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidDSN, err)
+		}
+		return nil
+	case "sqlite":
+		if path, _, _ := strings.Cut(rest, "?"); path == "" {
+			return fmt.Errorf("%w: empty SQLite file path", ErrInvalidDSN)
+		}
+		return nil
+	case "memory":
+		return nil
+	default:
+		return fmt.Errorf("%w: scheme %q", ErrInvalidDSN, scheme)
+	}
+}
+
+// validateURL проверяет адрес http или https с хостом
+func validateURL(address string) error {
+	if address == "" {
+		return ErrRequired
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidURL, address)
+	}
+	return nil
+}
+
+func fileExists(path string) error {
+	if path == "" {
+		return nil
+	}
+	_, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	}
+	return nil
+}
+
+func positive[T int | time.Duration](value T) error {
+	if value <= 0 {
+		return fmt.Errorf("%w: %v", ErrNotPositive, value)
+	}
+	return nil
+}
+
+func nonNegative[T int | time.Duration](value T) error {
+	if value < 0 {
+		return fmt.Errorf("%w: %v", ErrNegative, value)
+	}
+	return nil
+}
+
+func inRange(value int, min int, max int) error {
+	if value < min || value > max {
+		return fmt.Errorf("%w: %d not in [%d, %d]", ErrOutOfRange, value, min, max)
+	}
+	return nil
+}
+
+func oneOf(value string, allowed ...string) error {
+	if !slices.Contains(allowed, value) {
+		return fmt.Errorf("%w %v: %q", ErrNotAllowed, allowed, value)
+	}
+	return nil
+}
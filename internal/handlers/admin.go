@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gophermart/internal/auth"
+	"gophermart/internal/models"
+	"gophermart/internal/store"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// writeJSON отправляет v в теле ответа 200
+func writeJSON(res http.ResponseWriter, v interface{}) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(jsonBytes)
+}
+
+// adminUser возвращает пользователя из параметра маршрута login. Если пользователя нет, отвечает 404.
+func adminUser(ctx context.Context, res http.ResponseWriter, req *http.Request, storage *store.StorageContext) (models.UserInfo, bool) {
+	user, err := storage.GetUser(ctx, auth.NormalizeLogin(chi.URLParam(req, "login")))
+	if errors.Is(err, store.ErrUserNotFound) {
+		res.WriteHeader(http.StatusNotFound)
+		return models.UserInfo{}, false
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return models.UserInfo{}, false
+	}
+	return user, true
+}
+
+// GetAdminUser Сведения о пользователе
+// @Summary Сведения о пользователе
+// @Description Логин, роль, время регистрации и срок блокировки входа. Доступно ролям support и admin.
+// @Produce json
+// @Param login path string true "логин пользователя"
+// @Success 200 {object}  models.UserInfo "успешная обработка запроса"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "пользователь не найден"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/users/{login} [get]
+// @Security Bearer
+func GetAdminUser(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+
+	user, ok := adminUser(ctx, res, req, storage)
+	if !ok {
+		return
+	}
+	writeJSON(res, user)
+}
+
+// GetAdminUserBalance Баланс пользователя
+// @Summary Баланс пользователя
+// @Description Доступно ролям support и admin.
+// @Produce json
+// @Param login path string true "логин пользователя"
+// @Success 200 {object}  models.Balance "успешная обработка запроса"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "пользователь не найден"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/users/{login}/balance [get]
+// @Security Bearer
+func GetAdminUserBalance(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+
+	user, ok := adminUser(ctx, res, req, storage)
+	if !ok {
+		return
+	}
+	balance, err := storage.GetUserBalance(ctx, user.Login)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(res, balance)
+}
+
+// GetAdminUserOrders Заказы пользователя
+// @Summary Заказы пользователя
+// @Description Доступно ролям support и admin.
+// @Produce json
+// @Param login path string true "логин пользователя"
+// @Success 200 {array}   models.StatusOrders "успешная обработка запроса"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "пользователь не найден"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/users/{login}/orders [get]
+// @Security Bearer
+func GetAdminUserOrders(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
+	defer cancel()
+
+	user, ok := adminUser(ctx, res, req, storage)
+	if !ok {
+		return
+	}
+	orders, err := storage.GetUserOrders(ctx, user.Login)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if orders == nil {
+		orders = []models.StatusOrders{}
+	}
+	writeJSON(res, orders)
+}
+
+// GetAdminUserWithdrawals Списания пользователя
+// @Summary Списания пользователя
+// @Description Доступно ролям support и admin.
+// @Produce json
+// @Param login path string true "логин пользователя"
+// @Success 200 {array}   models.BalanceWithdrawals "успешная обработка запроса"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "пользователь не найден"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/users/{login}/withdrawals [get]
+// @Security Bearer
+func GetAdminUserWithdrawals(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
+	defer cancel()
+
+	user, ok := adminUser(ctx, res, req, storage)
+	if !ok {
+		return
+	}
+	withdrawals, err := storage.GetUserWithdrawals(ctx, user.Login)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if withdrawals == nil {
+		withdrawals = []models.BalanceWithdrawals{}
+	}
+	writeJSON(res, withdrawals)
+}
+
+// GetAdminUserLedger Проводки пользователя
+// @Summary Проводки пользователя
+// @Description История проводок книги учёта по счетам пользователя. Доступно ролям support и admin.
+// @Produce json
+// @Param login path string true "логин пользователя"
+// @Success 200 {array}   models.LedgerEntry "успешная обработка запроса"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "пользователь не найден"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/users/{login}/ledger [get]
+// @Security Bearer
+func GetAdminUserLedger(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
+	defer cancel()
+
+	user, ok := adminUser(ctx, res, req, storage)
+	if !ok {
+		return
+	}
+	entries, err := storage.GetUserLedger(ctx, user.Login)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []models.LedgerEntry{}
+	}
+	writeJSON(res, entries)
+}
+
+// PostAdminUserBalance Корректировка баланса
+// @Summary Корректировка баланса
+// @Description Начисляет или списывает баллы с указанием причины. Доступно роли admin.
+// @Accept json
+// @Param login path string true "логин пользователя"
+// @Param request body models.BalanceAdjustment true "JSON тело запроса"
+// @Success 200 {string}  string    "баланс скорректирован"
+// @Failure 400 {string}  string    "неверный формат запроса, нулевая сумма или пустая причина"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 402 {string}  string    "списание больше текущего баланса"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "пользователь не найден"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/users/{login}/balance [post]
+// @Security Bearer
+func PostAdminUserBalance(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+
+	var request models.BalanceAdjustment
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil || request.Amount == 0 || request.Reason == "" {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = storage.AdjustUserBalance(ctx, auth.NormalizeLogin(chi.URLParam(req, "login")), request.Amount, request.Reason)
+	if errors.Is(err, store.ErrUserNotFound) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	} else if errors.Is(err, store.ErrInsufficientFunds) {
+		res.WriteHeader(http.StatusPaymentRequired)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+// PostAdminUserUnlock Разблокировка пользователя
+// @Summary Разблокировка пользователя
+// @Description Снимает блокировку входа после неудачных попыток. Доступно ролям support и admin.
+// @Param login path string true "логин пользователя"
+// @Success 200 {string}  string    "пользователь разблокирован"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "пользователь не найден"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/users/{login}/unlock [post]
+// @Security Bearer
+func PostAdminUserUnlock(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+
+	err := storage.UnlockUser(ctx, auth.NormalizeLogin(chi.URLParam(req, "login")))
+	if errors.Is(err, store.ErrUserNotFound) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+// PutAdminUserRole Назначение роли
+// @Summary Назначение роли
+// @Description Роль попадает в токен доступа при следующем входе или обновлении токенов. Доступно роли admin.
+// @Accept json
+// @Param login path string true "логин пользователя"
+// @Param request body models.RoleChange true "JSON тело запроса"
+// @Success 200 {string}  string    "роль назначена"
+// @Failure 400 {string}  string    "неверный формат запроса или неизвестная роль"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "пользователь не найден"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/users/{login}/role [put]
+// @Security Bearer
+func PutAdminUserRole(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+
+	var request models.RoleChange
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil || !models.ValidRole(request.Role) {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = storage.SetUserRole(ctx, auth.NormalizeLogin(chi.URLParam(req, "login")), request.Role)
+	if errors.Is(err, store.ErrUserNotFound) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+// GetAdminDeadLetterOrders Заказы в dead-letter
+// @Summary Заказы в dead-letter
+// @Description Заказы, опрос которых прекращён после неудачных попыток. Доступно ролям support и admin.
+// @Produce json
+// @Success 200 {array}   models.DeadLetterOrder "успешная обработка запроса"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/orders/deadletter [get]
+// @Security Bearer
+func GetAdminDeadLetterOrders(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
+	defer cancel()
+
+	orders, err := storage.GetDeadLetterOrders(ctx)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if orders == nil {
+		orders = []models.DeadLetterOrder{}
+	}
+	writeJSON(res, orders)
+}
+
+// PostAdminOrderRequeue Возврат заказа в очередь
+// @Summary Возврат заказа в очередь
+// @Description Возвращает заказ из dead-letter в очередь опроса системы расчёта. Доступно ролям support и admin.
+// @Param number path string true "номер заказа"
+// @Success 200 {string}  string    "заказ возвращён в очередь"
+// @Failure 400 {string}  string    "неверный номер заказа"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 404 {string}  string    "заказ не в dead-letter"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/orders/{number}/requeue [post]
+// @Security Bearer
+func PostAdminOrderRequeue(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+
+	number, err := strconv.ParseInt(chi.URLParam(req, "number"), 10, 64)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = storage.RequeueOrder(ctx, number)
+	if errors.Is(err, store.ErrOrderNotFound) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+// GetAdminEvents Журнал аудита изменений
+// @Summary Журнал аудита изменений
+// @Description События журнала аудита, новые первыми. Фильтры необязательны и объединяются по И.
+// @Description Следующая страница запрашивается с before_id, равным id последнего полученного события. Доступно роли admin.
+// @Produce json
+// @Param actor       query string false "инициатор: логин, accrual или system"
+// @Param action      query string false "действие, например withdrawal.create"
+// @Param entity_type query string false "вид сущности: user, order, withdrawal, access_token"
+// @Param entity_id   query string false "логин, номер заказа или jti токена"
+// @Param before_id   query int    false "события с id меньше before_id"
+// @Param limit       query int    false "число событий, по умолчанию 100, не больше 1000"
+// @Success 200 {array}   models.AuditEvent "успешная обработка запроса"
+// @Failure 400 {string}  string    "неверный фильтр"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "недостаточно прав"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/admin/events [get]
+// @Security Bearer
+func GetAdminEvents(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
+	defer cancel()
+
+	query := req.URL.Query()
+	filter := store.AuditFilter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		EntityType: query.Get("entity_type"),
+		EntityID:   query.Get("entity_id"),
+		Limit:      100,
+	}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit <= 0 || filter.Limit > 1000 {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("before_id"); value != "" {
+		var err error
+		filter.BeforeID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || filter.BeforeID <= 0 {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := storage.GetAuditEvents(ctx, filter)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []models.AuditEvent{}
+	}
+	writeJSON(res, events)
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gophermart/internal/auth"
+	"gophermart/internal/logger"
+	"gophermart/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/jwtauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	logger.Init()
+	tokens := newTestTokens(t)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(AuditRequestID)
+	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
+		PostUserLogin(w, r, storage, tokens, guard)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(tokens.Verifier)
+		r.Use(jwtauth.Authenticator)
+		r.Use(AuditActor)
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(auth.RequireRole(models.RoleSupport, models.RoleAdmin))
+			r.Get("/users/{login}", func(w http.ResponseWriter, r *http.Request) {
+				GetAdminUser(w, r, storage)
+			})
+			r.Get("/users/{login}/balance", func(w http.ResponseWriter, r *http.Request) {
+				GetAdminUserBalance(w, r, storage)
+			})
+			r.Get("/users/{login}/orders", func(w http.ResponseWriter, r *http.Request) {
+				GetAdminUserOrders(w, r, storage)
+			})
+			r.Post("/users/{login}/unlock", func(w http.ResponseWriter, r *http.Request) {
+				PostAdminUserUnlock(w, r, storage)
+			})
+			r.Post("/orders/{number}/requeue", func(w http.ResponseWriter, r *http.Request) {
+				PostAdminOrderRequeue(w, r, storage)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireRole(models.RoleAdmin))
+				r.Post("/users/{login}/balance", func(w http.ResponseWriter, r *http.Request) {
+					PostAdminUserBalance(w, r, storage)
+				})
+				r.Put("/users/{login}/role", func(w http.ResponseWriter, r *http.Request) {
+					PutAdminUserRole(w, r, storage)
+				})
+				r.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+					GetAdminEvents(w, r, storage)
+				})
+			})
+		})
+	})
+
+	issue := func(login string, role string) string {
+		token, err := tokens.Issue(login, role)
+		require.NoError(t, err)
+		return token
+	}
+	user, support, admin := issue("test3", models.RoleUser), issue("support", models.RoleSupport), issue("admin", models.RoleAdmin)
+
+	type want struct {
+		code int
+	}
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		accessToken string
+		body        string
+		want        want
+	}{
+		{
+			name:   "без токена",
+			method: http.MethodGet,
+			url:    "/api/admin/users/test",
+			want: want{
+				code: 401,
+			},
+		},
+		{
+			name:        "покупателю недоступно",
+			method:      http.MethodGet,
+			url:         "/api/admin/users/test",
+			accessToken: user,
+			want: want{
+				code: 403,
+			},
+		},
+		{
+			name:        "поддержка смотрит пользователя",
+			method:      http.MethodGet,
+			url:         "/api/admin/users/test",
+			accessToken: support,
+			want: want{
+				code: 200,
+			},
+		},
+		{
+			name:        "пользователь не найден",
+			method:      http.MethodGet,
+			url:         "/api/admin/users/nobody",
+			accessToken: support,
+			want: want{
+				code: 404,
+			},
+		},
+		{
+			name:        "поддержка смотрит заказы",
+			method:      http.MethodGet,
+			url:         "/api/admin/users/test/orders",
+			accessToken: support,
+			want: want{
+				code: 200,
+			},
+		},
+		{
+			name:        "поддержка разблокирует пользователя",
+			method:      http.MethodPost,
+			url:         "/api/admin/users/test/unlock",
+			accessToken: support,
+			want: want{
+				code: 200,
+			},
+		},
+		{
+			name:        "заказа нет в dead-letter",
+			method:      http.MethodPost,
+			url:         "/api/admin/orders/7950839220/requeue",
+			accessToken: support,
+			want: want{
+				code: 404,
+			},
+		},
+		{
+			name:        "поддержке недоступна корректировка баланса",
+			method:      http.MethodPost,
+			url:         "/api/admin/users/test/balance",
+			accessToken: support,
+			body:        `{"amount":5,"reason":"компенсация"}`,
+			want: want{
+				code: 403,
+			},
+		},
+		{
+			name:        "корректировка без причины",
+			method:      http.MethodPost,
+			url:         "/api/admin/users/test/balance",
+			accessToken: admin,
+			body:        `{"amount":5}`,
+			want: want{
+				code: 400,
+			},
+		},
+		{
+			name:        "списание больше баланса",
+			method:      http.MethodPost,
+			url:         "/api/admin/users/test/balance",
+			accessToken: admin,
+			body:        `{"amount":-1000,"reason":"ошибка начисления"}`,
+			want: want{
+				code: 402,
+			},
+		},
+		{
+			name:        "администратор корректирует баланс",
+			method:      http.MethodPost,
+			url:         "/api/admin/users/test/balance",
+			accessToken: admin,
+			body:        `{"amount":5,"reason":"компенсация"}`,
+			want: want{
+				code: 200,
+			},
+		},
+		{
+			name:        "неизвестная роль",
+			method:      http.MethodPut,
+			url:         "/api/admin/users/test2/role",
+			accessToken: admin,
+			body:        `{"role":"root"}`,
+			want: want{
+				code: 400,
+			},
+		},
+		{
+			name:        "поддержке недоступен журнал аудита",
+			method:      http.MethodGet,
+			url:         "/api/admin/events",
+			accessToken: support,
+			want: want{
+				code: 403,
+			},
+		},
+		{
+			name:        "неверный before_id",
+			method:      http.MethodGet,
+			url:         "/api/admin/events?before_id=abc",
+			accessToken: admin,
+			want: want{
+				code: 400,
+			},
+		},
+		{
+			name:        "администратор назначает роль",
+			method:      http.MethodPut,
+			url:         "/api/admin/users/test2/role",
+			accessToken: admin,
+			body:        `{"role":"support"}`,
+			want: want{
+				code: 200,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, bytes.NewReader([]byte(test.body)))
+			if test.accessToken != "" {
+				req.Header.Set("Authorization", "Bearer "+test.accessToken)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, test.want.code, w.Code)
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users/test/balance", nil)
+	req.Header.Set("Authorization", "Bearer "+support)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var balance models.Balance
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &balance))
+	assert.Equal(t, "15", balance.Current.String(), "10 баллов и корректировка на 5")
+
+	// назначенная роль попадает в токен при следующем входе
+	w = postJSON(t, r, urlPostUserLogin, "", models.User{Login: "test2", Password: "password2"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var issued models.Tokens
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &issued))
+	token, err := tokens.Verify(issued.AccessToken)
+	require.NoError(t, err)
+	role, _ := token.Get(auth.ClaimRole)
+	assert.Equal(t, models.RoleSupport, role)
+
+	// отклонённые попытки ничего не меняют и в журнал аудита не попадают
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/events?actor=test3", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var denied []models.AuditEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &denied))
+	for _, event := range denied {
+		assert.Equal(t, "user.register", event.Action, "у пользователя только его собственная регистрация")
+	}
+
+	// изменение записано в журнал аудита от имени сотрудника, а не пользователя, вместе с идентификатором запроса
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/events?entity_type=user&entity_id=test&action=balance.adjust", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var events []models.AuditEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &events))
+	require.Len(t, events, 1, "неудачные корректировки в журнал аудита не попадают")
+	assert.Equal(t, "admin", events[0].Actor)
+	assert.NotEmpty(t, events[0].RequestID)
+	assert.JSONEq(t, `{"current":10}`, string(events[0].Before))
+	assert.JSONEq(t, `{"current":15,"sum":5,"reason":"компенсация"}`, string(events[0].After))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/events?entity_id=test2&action=user.role_change", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &events))
+	require.Len(t, events, 1)
+	assert.JSONEq(t, `{"role":"user"}`, string(events[0].Before))
+	assert.JSONEq(t, `{"role":"support"}`, string(events[0].After))
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"gophermart/internal/auth"
+	"gophermart/internal/store"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/jwtauth"
+)
+
+// AuditRequestID связывает изменения, сделанные при обработке запроса, с его идентификатором в журнале аудита.
+// Ставится после middleware.RequestID.
+func AuditRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestID := middleware.GetReqID(r.Context()); requestID != "" {
+			r = r.WithContext(store.WithRequestID(r.Context(), requestID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AuditActor записывает изменения, сделанные при обработке запроса, в журнал аудита от имени владельца токена:
+// так действия сотрудника над чужими данными не приписываются пользователю. Ставится после jwtauth.Authenticator.
+func AuditActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, err := jwtauth.FromContext(r.Context())
+		if err == nil {
+			if login, ok := claims[auth.ClaimUsername].(string); ok && login != "" {
+				r = r.WithContext(store.WithActor(r.Context(), login))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -5,17 +5,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"gophermart/internal/accrual"
+	"gophermart/internal/auth"
 	"gophermart/internal/logger"
 	"gophermart/internal/luhn"
 	"gophermart/internal/models"
+	"gophermart/internal/notify"
 	"gophermart/internal/store"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/jwtauth"
+	"go.uber.org/zap"
 )
 
 // PostUserRegister Регистрация пользователя
@@ -23,13 +27,13 @@ import (
 // @Description Этот эндпоинт производит регистрацию пользователя
 // @Accept json
 // @Param request body models.User true "JSON тело запроса"
-// @Success 200 {string}  string    "пользователь успешно аутентифицирован"
-// @Failure 400 {string}  string    "неверный формат запроса"
+// @Success 200 {object}  models.Tokens "пользователь успешно аутентифицирован"
+// @Failure 400 {string}  string    "неверный формат запроса, логин или пароль не соответствуют требованиям"
 // @Failure 409 {string}  string    "логин уже занят"
 // @Failure 500 {string}  string    "внутренняя ошибка сервера"
 // @Router /api/user/register [post]
-func PostUserRegister(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, tokenAuth *jwtauth.JWTAuth) {
-	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+func PostUserRegister(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, tokens *auth.Tokens, policy auth.PasswordPolicy) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
 	defer cancel()
 
 	var user models.User
@@ -46,8 +50,13 @@ func PostUserRegister(res http.ResponseWriter, req *http.Request, storage *store
 		return
 	}
 
-	if user.Login == "" || user.Password == "" {
-		res.WriteHeader(http.StatusBadRequest)
+	user.Login = auth.NormalizeLogin(user.Login)
+	err = auth.ValidateLogin(user.Login)
+	if err == nil {
+		err = policy.Validate(user.Login, user.Password)
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -59,22 +68,69 @@ func PostUserRegister(res http.ResponseWriter, req *http.Request, storage *store
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	logger.AddFields(ctx, zap.String("login", user.Login))
+
+	refreshToken, refreshHash, refreshExpiresAt, err := tokens.NewRefreshToken()
+	if err == nil {
+		err = storage.CreateRefreshToken(ctx, user.Login, refreshHash, refreshExpiresAt)
+	}
+	if err != nil {
+		logger.FromContext(ctx).Warn("Произошла ошибка генерации токена", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	claims := jwt.MapClaims{
-		"username": user.Login,
+	writeTokens(ctx, res, storage, tokens, user.Login, refreshToken)
+	logger.FromContext(ctx).Info("Новый пользователь аутентифицирован")
+}
+
+// writeTokens выпускает токен доступа пользователя login с его текущей ролью и отправляет его
+// в заголовке Authorization и вместе с токеном обновления в теле ответа
+func writeTokens(ctx context.Context, res http.ResponseWriter, storage *store.StorageContext, tokens *auth.Tokens, login string, refreshToken string) {
+	user, err := storage.GetUser(ctx, login)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	accessToken, err := tokens.Issue(login, user.Role)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Произошла ошибка генерации токена", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	_, tokenString, err := tokenAuth.Encode(claims)
+	jsonBytes, err := json.Marshal(models.Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.TTL() / time.Second),
+	})
 	if err != nil {
-		logger.Logger.Warn("Произошла ошибка генерации токена")
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	bearer := "Bearer " + tokenString
-	res.Header().Set("Authorization", bearer)
+	res.Header().Set("Authorization", "Bearer "+accessToken)
+	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(http.StatusOK)
-	logger.Logger.Info("Новый пользователь аутентифицирован")
+	res.Write(jsonBytes)
+}
+
+// clientIP возвращает адрес клиента из соединения. Заголовкам X-Forwarded-For не доверяем:
+// их подменой перебор обходил бы ограничение по адресу.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// tooManyRequests отвечает 429 с заголовком Retry-After в целых секундах, округлённых вверх
+func tooManyRequests(res http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	res.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	res.WriteHeader(http.StatusTooManyRequests)
 }
 
 // PostUserLogin Аутентификация пользователя
@@ -82,13 +138,14 @@ func PostUserRegister(res http.ResponseWriter, req *http.Request, storage *store
 // @Description Этот эндпоинт производит аутентификацию пользователя
 // @Accept json
 // @Param request body models.User true "JSON тело запроса"
-// @Success 200 {string}  string    "пользователь успешно аутентифицирован"
+// @Success 200 {object}  models.Tokens "пользователь успешно аутентифицирован"
 // @Failure 400 {string}  string    "неверный формат запроса"
 // @Failure 401 {string}  string    "неверная пара логин/пароль"
+// @Failure 429 {string}  string    "слишком много неудачных попыток входа, повтор через Retry-After секунд"
 // @Failure 500 {string}  string    "внутренняя ошибка сервера"
 // @Router /api/user/login [post]
-func PostUserLogin(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, tokenAuth *jwtauth.JWTAuth) {
-	ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+func PostUserLogin(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, tokens *auth.Tokens, guard *auth.LoginGuard) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
 	defer cancel()
 
 	var user models.User
@@ -105,36 +162,66 @@ func PostUserLogin(res http.ResponseWriter, req *http.Request, storage *store.St
 		return
 	}
 
+	user.Login = auth.NormalizeLogin(user.Login)
 	if user.Login == "" || user.Password == "" {
 		res.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	// попытка учитывается до bcrypt: перебор не нагружает процессор, а параллельные запросы
+	// с неверным паролем не проходят сверх ограничений
+	ip := clientIP(req)
+	attempt, retryAfter, err := guard.Reserve(ctx, user.Login, ip)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		tooManyRequests(res, retryAfter)
+		return
+	}
+
 	err = storage.UserLogin(ctx, user.Login, user.Password)
 
 	if errors.Is(err, store.ErrAuthentication) {
+		lockedUntil, err := guard.Failure(ctx, attempt)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !lockedUntil.IsZero() {
+			logger.FromContext(ctx).Warn("Пользователь заблокирован после неудачных входов", zap.String("login", user.Login), zap.Time("locked_until", lockedUntil))
+		}
 		res.WriteHeader(http.StatusUnauthorized)
 		return
 	} else if err != nil && !errors.Is(err, store.ErrLoginDuplicate) {
+		if err := guard.Release(ctx, attempt); err != nil {
+			logger.FromContext(ctx).Warn("Не удалось отменить попытку входа", zap.Error(err))
+		}
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	claims := jwt.MapClaims{
-		"username": user.Login,
+	logger.AddFields(ctx, zap.String("login", user.Login))
+
+	err = guard.Success(ctx, attempt)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	_, tokenString, err := tokenAuth.Encode(claims)
+	refreshToken, refreshHash, refreshExpiresAt, err := tokens.NewRefreshToken()
+	if err == nil {
+		err = storage.CreateRefreshToken(ctx, user.Login, refreshHash, refreshExpiresAt)
+	}
 	if err != nil {
-		logger.Logger.Warn("Произошла ошибка генерации токена")
+		logger.FromContext(ctx).Warn("Произошла ошибка генерации токена", zap.Error(err))
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	bearer := "Bearer " + tokenString
-	res.Header().Set("Authorization", bearer)
-	res.WriteHeader(http.StatusOK)
-	logger.Logger.Info("Пользователь аутентифицирован")
+	writeTokens(ctx, res, storage, tokens, user.Login, refreshToken)
+	logger.FromContext(ctx).Info("Пользователь аутентифицирован")
 }
 
 // PostUserOrders Загрузка номера заказа
@@ -152,7 +239,7 @@ func PostUserLogin(res http.ResponseWriter, req *http.Request, storage *store.St
 // @Router /api/user/orders [post]
 // @Security Bearer
 func PostUserOrders(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
-	ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
 	defer cancel()
 
 	token, _, err := jwtauth.FromContext(ctx)
@@ -171,10 +258,11 @@ func PostUserOrders(res http.ResponseWriter, req *http.Request, storage *store.S
 	order, err := strconv.ParseInt(string(body), 10, 64)
 
 	if err != nil || !luhn.Valid(order) {
-		logger.Logger.Info("Номер заказа не прошел проверку")
+		logger.FromContext(ctx).Info("Номер заказа не прошел проверку", zap.String("order", string(body)))
 		res.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
+	logger.AddFields(ctx, zap.Int64("order", order))
 
 	err = storage.UploadUserOrders(ctx, user, order)
 
@@ -204,7 +292,7 @@ func PostUserOrders(res http.ResponseWriter, req *http.Request, storage *store.S
 // @Router /api/user/orders [get]
 // @Security Bearer
 func GetUserOrders(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
-	ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
 	defer cancel()
 	res.Header().Set("Content-Type", "application/json")
 	token, _, err := jwtauth.FromContext(ctx)
@@ -252,7 +340,7 @@ func GetUserOrders(res http.ResponseWriter, req *http.Request, storage *store.St
 // @Router /api/user/balance [get]
 // @Security Bearer
 func GetUserBalance(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
-	ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
 	defer cancel()
 	res.Header().Set("Content-Type", "application/json")
 	token, _, err := jwtauth.FromContext(ctx)
@@ -292,12 +380,12 @@ func GetUserBalance(res http.ResponseWriter, req *http.Request, storage *store.S
 // @Success 200 {string}  string    "успешная обработка запроса"
 // @Failure 401 {string}  string    "пользователь не авторизован"
 // @Failure 402 {string}  string    "на счету недостаточно средств"
-// @Failure 422 {string}  string    "неверный номер заказа"
+// @Failure 422 {string}  string    "неверный номер заказа или сумма не больше нуля"
 // @Failure 500 {string}  string    "внутренняя ошибка сервера"
 // @Router /api/user/balance/withdraw [post]
 // @Security Bearer
 func PostUserBalanceWithdraw(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
-	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
 	defer cancel()
 
 	token, _, err := jwtauth.FromContext(ctx)
@@ -329,7 +417,14 @@ func PostUserBalanceWithdraw(res http.ResponseWriter, req *http.Request, storage
 
 	order, err := strconv.ParseInt(userBalance.Order, 10, 64)
 	if err != nil || !luhn.Valid(order) {
-		logger.Logger.Info("Номер заказа не прошел проверку")
+		logger.FromContext(ctx).Info("Номер заказа не прошел проверку", zap.String("order", userBalance.Order))
+		res.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	logger.AddFields(ctx, zap.Int64("order", order))
+	// отрицательное списание пополнило бы баланс
+	if userBalance.Sum <= 0 {
+		logger.FromContext(ctx).Info("Сумма списания не больше нуля", zap.Stringer("sum", userBalance.Sum))
 		res.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
@@ -357,7 +452,7 @@ func PostUserBalanceWithdraw(res http.ResponseWriter, req *http.Request, storage
 // @Security Bearer
 func GetUserWithdrawals(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
 
-	ctx, cancel := context.WithTimeout(req.Context(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
 	defer cancel()
 	res.Header().Set("Content-Type", "application/json")
 	token, _, err := jwtauth.FromContext(ctx)
@@ -394,3 +489,320 @@ func GetUserWithdrawals(res http.ResponseWriter, req *http.Request, storage *sto
 
 	res.WriteHeader(http.StatusOK)
 }
+
+// PostUserTokenRefresh Обновление токенов
+// @Summary Обновление токенов
+// @Description Этот эндпоинт обменивает токен обновления на новую пару токенов. Токен обновления действует один раз,
+// @Description а повторное использование уже обменянного токена отзывает все токены, полученные по цепочке от того же входа.
+// @Accept json
+// @Produce json
+// @Param request body models.RefreshToken true "JSON тело запроса"
+// @Success 200 {object}  models.Tokens "токены обновлены"
+// @Failure 400 {string}  string    "неверный формат запроса"
+// @Failure 401 {string}  string    "токен обновления недействителен, истёк или отозван"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/user/token/refresh [post]
+func PostUserTokenRefresh(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, tokens *auth.Tokens) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+
+	var request models.RefreshToken
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil || request.RefreshToken == "" {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	refreshToken, refreshHash, refreshExpiresAt, err := tokens.NewRefreshToken()
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	login, err := storage.RotateRefreshToken(ctx, auth.HashToken(request.RefreshToken), refreshHash, refreshExpiresAt)
+	if errors.Is(err, store.ErrRefreshTokenReused) {
+		logger.FromContext(ctx).Warn("Повторно использован токен обновления, цепочка токенов отозвана")
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	} else if errors.Is(err, store.ErrRefreshTokenInvalid) {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeTokens(ctx, res, storage, tokens, login, refreshToken)
+}
+
+// PostUserLogout Выход пользователя
+// @Summary Выход пользователя
+// @Description Этот эндпоинт отзывает текущий токен доступа и, если он передан, токен обновления вместе с его цепочкой
+// @Accept json
+// @Param request body models.RefreshToken false "JSON тело запроса"
+// @Success 200 {string}  string    "пользователь вышел"
+// @Failure 400 {string}  string    "неверный формат запроса"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/user/logout [post]
+// @Security Bearer
+func PostUserLogout(res http.ResponseWriter, req *http.Request, storage *store.StorageContext) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+
+	token, claims, err := jwtauth.FromContext(ctx)
+	if err != nil {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	user := claims[auth.ClaimUsername].(string)
+
+	var request models.RefreshToken
+	err = json.NewDecoder(req.Body).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = storage.RevokeAccessToken(ctx, token.JwtID(), token.Expiration())
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if request.RefreshToken != "" {
+		// повторный выход с уже отозванным токеном обновления не ошибка
+		err = storage.RevokeRefreshToken(ctx, user, auth.HashToken(request.RefreshToken))
+		if err != nil && !errors.Is(err, store.ErrRefreshTokenInvalid) {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+// PostUserPassword Смена пароля
+// @Summary Смена пароля
+// @Description Этот эндпоинт меняет пароль пользователя. Остальные сеансы пользователя завершаются:
+// @Description их токены обновления отзываются, а текущий сеанс получает новую пару токенов.
+// @Accept json
+// @Param request body models.PasswordChange true "JSON тело запроса"
+// @Success 200 {object}  models.Tokens "пароль изменён"
+// @Failure 400 {string}  string    "неверный формат запроса или новый пароль не соответствует требованиям"
+// @Failure 401 {string}  string    "пользователь не аутентифицирован"
+// @Failure 403 {string}  string    "неверный текущий пароль"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/user/password [post]
+// @Security Bearer
+func PostUserPassword(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, tokens *auth.Tokens, policy auth.PasswordPolicy) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
+	defer cancel()
+
+	_, claims, err := jwtauth.FromContext(ctx)
+	if err != nil {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	user := claims[auth.ClaimUsername].(string)
+
+	var request models.PasswordChange
+	err = json.NewDecoder(req.Body).Decode(&request)
+	if err != nil || request.OldPassword == "" {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = policy.Validate(user, request.NewPassword)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = storage.UserLogin(ctx, user, request.OldPassword)
+	if errors.Is(err, store.ErrAuthentication) {
+		res.WriteHeader(http.StatusForbidden)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = storage.ChangePassword(ctx, user, request.NewPassword)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, refreshHash, refreshExpiresAt, err := tokens.NewRefreshToken()
+	if err == nil {
+		err = storage.CreateRefreshToken(ctx, user, refreshHash, refreshExpiresAt)
+	}
+	if err != nil {
+		logger.FromContext(ctx).Warn("Произошла ошибка генерации токена", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeTokens(ctx, res, storage, tokens, user, refreshToken)
+	logger.FromContext(ctx).Info("Пароль пользователя изменён")
+}
+
+// PostUserPasswordReset Запрос сброса пароля
+// @Summary Запрос сброса пароля
+// @Description Этот эндпоинт отправляет пользователю токен сброса пароля. Ответ не зависит от того,
+// @Description существует ли пользователь, чтобы по нему нельзя было перебирать логины.
+// @Accept json
+// @Param request body models.PasswordResetRequest true "JSON тело запроса"
+// @Success 202 {string}  string    "запрос принят"
+// @Failure 400 {string}  string    "неверный формат запроса"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/user/password/reset [post]
+func PostUserPasswordReset(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, notifier notify.Notifier, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
+	defer cancel()
+
+	var request models.PasswordResetRequest
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	login := auth.NormalizeLogin(request.Login)
+	if login == "" {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	token, tokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+	err = storage.CreatePasswordReset(ctx, login, tokenHash, expiresAt)
+	if errors.Is(err, store.ErrUserNotFound) {
+		res.WriteHeader(http.StatusAccepted)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = notifier.SendPasswordReset(ctx, notify.PasswordReset{Login: login, Token: token, ExpiresAt: expiresAt})
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отправить токен сброса пароля", zap.Error(err))
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusAccepted)
+}
+
+// PostUserPasswordResetConfirm Сброс пароля
+// @Summary Сброс пароля
+// @Description Этот эндпоинт по токену сброса заменяет пароль, снимает блокировку входа и завершает все сеансы пользователя.
+// @Description Токен действует один раз.
+// @Accept json
+// @Param request body models.PasswordResetConfirm true "JSON тело запроса"
+// @Success 200 {string}  string    "пароль изменён"
+// @Failure 400 {string}  string    "неверный формат запроса или пароль не соответствует требованиям"
+// @Failure 401 {string}  string    "токен сброса недействителен, истёк или уже использован"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /api/user/password/reset/confirm [post]
+func PostUserPasswordResetConfirm(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, policy auth.PasswordPolicy) {
+	ctx, cancel := context.WithTimeout(req.Context(), longRequestTimeout)
+	defer cancel()
+
+	var request models.PasswordResetConfirm
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil || request.Token == "" {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// логин станет известен только по токену, поэтому совпадение пароля с логином здесь не проверяется
+	err = policy.Validate("", request.Password)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	login, err := storage.ResetPassword(ctx, auth.HashToken(request.Token), request.Password)
+	if errors.Is(err, store.ErrResetTokenInvalid) {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.AddFields(ctx, zap.String("login", login))
+	logger.FromContext(ctx).Info("Пароль пользователя сброшен")
+	res.WriteHeader(http.StatusOK)
+}
+
+// PostAccrualCallback Уведомление системы расчёта об изменении статуса заказа
+// @Summary Уведомление системы расчёта об изменении статуса заказа
+// @Description Система расчёта или прокси сообщает новый статус заказа. Запрос подписывается HMAC-SHA256 от "<X-Accrual-Timestamp>.<тело запроса>",
+// @Description повтор уведомления отклоняется. Эндпоинт доступен, если задан ACCRUAL_CALLBACK_SECRET.
+// @Accept json
+// @Param request body models.StatusOrdersAccrual true "JSON тело запроса"
+// @Param X-Accrual-Timestamp header string true "время отправки, unix-время в секундах"
+// @Param X-Accrual-Signature header string true "sha256=<hex HMAC-SHA256>"
+// @Success 200 {string}  string    "статус заказа обновлён"
+// @Failure 400 {string}  string    "неверный формат запроса"
+// @Failure 401 {string}  string    "неверная или устаревшая подпись"
+// @Failure 404 {string}  string    "заказ не найден"
+// @Failure 409 {string}  string    "уведомление уже было принято"
+// @Failure 413 {string}  string    "тело запроса больше 4 КБ"
+// @Failure 500 {string}  string    "внутренняя ошибка сервера"
+// @Router /internal/accrual/callback [post]
+func PostAccrualCallback(res http.ResponseWriter, req *http.Request, storage *store.StorageContext, secret []byte) {
+	ctx, cancel := context.WithTimeout(store.WithActor(req.Context(), store.AuditActorAccrual), requestTimeout)
+	defer cancel()
+
+	body, err := io.ReadAll(http.MaxBytesReader(res, req.Body, accrual.CallbackMaxBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		logger.FromContext(ctx).Warn("отклонено уведомление системы расчёта", zap.Error(err))
+		res.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	signature := req.Header.Get(accrual.CallbackSignatureHeader)
+	err = accrual.VerifyCallback(secret, req.Header.Get(accrual.CallbackTimestampHeader), signature, body, time.Now())
+	if err != nil {
+		logger.FromContext(ctx).Warn("отклонено уведомление системы расчёта", zap.Error(err))
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var statusOrder models.StatusOrdersAccrual
+	err = json.Unmarshal(body, &statusOrder)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, err = strconv.ParseInt(statusOrder.Order, 10, 64); err != nil || statusOrder.Status == "" {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// подпись запоминается до проверки заказа: повтор отклоняется, даже если первое уведомление не применилось
+	err = storage.SaveAccrualCallback(ctx, signature, time.Now().Add(2*accrual.CallbackTolerance))
+	if errors.Is(err, store.ErrCallbackReplayed) {
+		res.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = storage.UpdateStatusOrders(ctx, &statusOrder)
+	if errors.Is(err, store.ErrOrderNotFound) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
@@ -2,64 +2,98 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"gophermart/internal/accrual"
+	"gophermart/internal/auth"
 	"gophermart/internal/logger"
 	"gophermart/internal/models"
+	"gophermart/internal/notify"
 	"gophermart/internal/store"
-	"gophermart/internal/store/mock"
+	"gophermart/internal/store/memory"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/jwtauth"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-const urlPostUserRegister = "/api/user/register"                // регистрация пользователя
-const urlPostUserLogin = "/api/user/login"                      // аутентификация пользователя;
-const urlPostUserOrders = "/api/user/orders"                    // загрузка пользователем номера заказа для расчёта;
-const urlGetUserOrders = "/api/user/orders"                     // получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях;
-const urlGetUserBalance = "/api/user/balance"                   // получение текущего баланса счёта баллов лояльности пользователя;
-const urlPostUserBalanceWithdraw = "/api/user/balance/withdraw" // запрос на списание баллов с накопительного счёта в счёт оплаты нового заказа;
-const urlGetUserWithdrawals = "/api/user/withdrawals"           // получение информации о выводе средств с накопительного счёта пользователем.
+const urlPostUserRegister = "/api/user/register"                           // регистрация пользователя
+const urlPostUserLogin = "/api/user/login"                                 // аутентификация пользователя;
+const urlPostUserOrders = "/api/user/orders"                               // загрузка пользователем номера заказа для расчёта;
+const urlGetUserOrders = "/api/user/orders"                                // получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях;
+const urlGetUserBalance = "/api/user/balance"                              // получение текущего баланса счёта баллов лояльности пользователя;
+const urlPostUserBalanceWithdraw = "/api/user/balance/withdraw"            // запрос на списание баллов с накопительного счёта в счёт оплаты нового заказа;
+const urlGetUserWithdrawals = "/api/user/withdrawals"                      // получение информации о выводе средств с накопительного счёта пользователем.
+const urlPostUserTokenRefresh = "/api/user/token/refresh"                  // обмен токена обновления на новую пару токенов;
+const urlPostUserLogout = "/api/user/logout"                               // выход пользователя с отзывом токенов;
+const urlPostUserPassword = "/api/user/password"                           // смена пароля;
+const urlPostUserPasswordReset = "/api/user/password/reset"                // запрос токена сброса пароля;
+const urlPostUserPasswordResetConfirm = "/api/user/password/reset/confirm" // сброс пароля по токену;
+const urlPostAccrualCallback = "/internal/accrual/callback"                // уведомление системы расчёта об изменении статуса заказа;
+const urlGetHealthz = "/healthz"                                           // проверка, что процесс жив;
+const urlGetReadyz = "/readyz"                                             // проверка готовности принимать запросы.
+
+// newTestTokens возвращает выпуск токенов с секретом HS256
+func newTestTokens(t *testing.T) *auth.Tokens {
+	t.Helper()
+	key, err := auth.ParseKey(auth.AlgorithmHS256, "", []byte("secret"))
+	require.NoError(t, err)
+	keys, err := auth.NewKeySet(key)
+	require.NoError(t, err)
+	return auth.NewTokens(keys, time.Hour, time.Hour)
+}
 
-func TestPostUserRegister(t *testing.T) {
-	logger.Init()
-	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+// newTestStorage возвращает хранилище в памяти с пользователями test, test2 и test3.
+// У test на счету 10 баллов и одно списание, у test3 нет ни заказов, ни списаний.
+func newTestStorage(t *testing.T) *store.StorageContext {
+	t.Helper()
+	ctx := context.Background()
+	db := memory.NewStorage()
 
-	mockDB := &mock.MockDB{
-		Users: map[int]map[string]string{
-			1: {"id": "1", "login": "test", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC", "sum": "10", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"id": "2", "login": "test2", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"id": "3", "login": "test3", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Orders: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "status": "NEW", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "status": "PROCESSED", "accrual": "10", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "status": "PROCESSING", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			4: {"number": "9347167976", "user_id": "1", "status": "INVALID", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Withdrawals: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "sum": "5", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "sum": "1", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "sum": "4", "processed_at": "2024-03-19 19:35:17.662533+00"},
-		},
-	}
+	require.NoError(t, db.UserRegister(ctx, "test", "password"))
+	require.NoError(t, db.UserRegister(ctx, "test2", "password2"))
+	require.NoError(t, db.UserRegister(ctx, "test3", "password3"))
+
+	require.NoError(t, db.UploadUserOrders(ctx, "test", 7950839220))
+	require.NoError(t, db.UploadUserOrders(ctx, "test", 2396508901))
+	require.NoError(t, db.UploadUserOrders(ctx, "test2", 1852074499))
+	require.NoError(t, db.UploadUserOrders(ctx, "test", 9347167976))
+
+	require.NoError(t, db.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: "2396508901", Status: models.OrderStatusProcessed, Accrual: models.MoneyFromKopecks(1500)}))
+	require.NoError(t, db.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: "1852074499", Status: models.OrderStatusProcessing}))
+	require.NoError(t, db.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: "9347167976", Status: models.OrderStatusInvalid}))
+
+	require.NoError(t, db.UpdateUserBalanceWithdraw(ctx, "test", "2377225624", models.MoneyFromKopecks(500)))
 
 	storage := &store.StorageContext{}
-	storage.SetStorage(mockDB)
+	storage.SetStorage(db)
+	return storage
+}
+
+func TestPostUserRegister(t *testing.T) {
+	logger.Init()
+	tokens := newTestTokens(t)
+
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Compress(5, "application/json", "text/html"))
 
 	r.Post(urlPostUserRegister, func(w http.ResponseWriter, r *http.Request) {
-		PostUserRegister(w, r, storage, tokenAuth)
+		PostUserRegister(w, r, storage, tokens, auth.DefaultPasswordPolicy)
 	})
 	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
-		PostUserLogin(w, r, storage, tokenAuth)
+		PostUserLogin(w, r, storage, tokens, guard)
 	})
 	r.Post(urlPostUserOrders, func(w http.ResponseWriter, r *http.Request) {
 		PostUserOrders(w, r, storage)
@@ -92,7 +126,7 @@ func TestPostUserRegister(t *testing.T) {
 			url:  urlPostUserRegister,
 			body: models.User{
 				Login:    "test4",
-				Password: "test3",
+				Password: "password4",
 			},
 			typeReqest: http.MethodPost,
 			want: want{
@@ -112,13 +146,49 @@ func TestPostUserRegister(t *testing.T) {
 			url:  urlPostUserRegister,
 			body: models.User{
 				Login:    "test",
-				Password: "test",
+				Password: "password5",
 			},
 			typeReqest: http.MethodPost,
 			want: want{
 				code: 409,
 			},
 		},
+		{
+			name: "логин занят с точностью до регистра и пробелов",
+			url:  urlPostUserRegister,
+			body: models.User{
+				Login:    " Test ",
+				Password: "password5",
+			},
+			typeReqest: http.MethodPost,
+			want: want{
+				code: 409,
+			},
+		},
+		{
+			name: "короткий пароль",
+			url:  urlPostUserRegister,
+			body: models.User{
+				Login:    "test5",
+				Password: "short",
+			},
+			typeReqest: http.MethodPost,
+			want: want{
+				code: 400,
+			},
+		},
+		{
+			name: "недопустимый логин",
+			url:  urlPostUserRegister,
+			body: models.User{
+				Login:    "test 5",
+				Password: "password5",
+			},
+			typeReqest: http.MethodPost,
+			want: want{
+				code: 400,
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -139,38 +209,19 @@ func TestPostUserRegister(t *testing.T) {
 
 func TestPostUserLogin(t *testing.T) {
 	logger.Init()
-	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
-
-	mockDB := &mock.MockDB{
-		Users: map[int]map[string]string{
-			1: {"id": "1", "login": "test", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC", "sum": "10", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"id": "2", "login": "test2", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"id": "3", "login": "test3", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Orders: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "status": "NEW", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "status": "PROCESSED", "accrual": "10", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "status": "PROCESSING", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			4: {"number": "9347167976", "user_id": "1", "status": "INVALID", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Withdrawals: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "sum": "5", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "sum": "1", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "sum": "4", "processed_at": "2024-03-19 19:35:17.662533+00"},
-		},
-	}
+	tokens := newTestTokens(t)
 
-	storage := &store.StorageContext{}
-	storage.SetStorage(mockDB)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Compress(5, "application/json", "text/html"))
 
 	r.Post(urlPostUserRegister, func(w http.ResponseWriter, r *http.Request) {
-		PostUserRegister(w, r, storage, tokenAuth)
+		PostUserRegister(w, r, storage, tokens, auth.DefaultPasswordPolicy)
 	})
 	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
-		PostUserLogin(w, r, storage, tokenAuth)
+		PostUserLogin(w, r, storage, tokens, guard)
 	})
 	r.Post(urlPostUserOrders, func(w http.ResponseWriter, r *http.Request) {
 		PostUserOrders(w, r, storage)
@@ -203,7 +254,7 @@ func TestPostUserLogin(t *testing.T) {
 			url:  urlPostUserLogin,
 			body: models.User{
 				Login:    "test",
-				Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC",
+				Password: "password",
 			},
 			typeReqest: http.MethodPost,
 			want: want{
@@ -223,7 +274,7 @@ func TestPostUserLogin(t *testing.T) {
 			url:  urlPostUserLogin,
 			body: models.User{
 				Login:    "test",
-				Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5CsmJLnPVA5W3y.EfNz7rC",
+				Password: "wrong-password",
 			},
 			typeReqest: http.MethodPost,
 			want: want{
@@ -250,41 +301,22 @@ func TestPostUserLogin(t *testing.T) {
 
 func TestPostUserOrders(t *testing.T) {
 	logger.Init()
-	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
-
-	mockDB := &mock.MockDB{
-		Users: map[int]map[string]string{
-			1: {"id": "1", "login": "test", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC", "sum": "10", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"id": "2", "login": "test2", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"id": "3", "login": "test3", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Orders: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "status": "NEW", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "status": "PROCESSED", "accrual": "10", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "status": "PROCESSING", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			4: {"number": "9347167976", "user_id": "1", "status": "INVALID", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Withdrawals: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "sum": "5", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "sum": "1", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "sum": "4", "processed_at": "2024-03-19 19:35:17.662533+00"},
-		},
-	}
+	tokens := newTestTokens(t)
 
-	storage := &store.StorageContext{}
-	storage.SetStorage(mockDB)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Compress(5, "application/json", "text/html"))
 
 	r.Post(urlPostUserRegister, func(w http.ResponseWriter, r *http.Request) {
-		PostUserRegister(w, r, storage, tokenAuth)
+		PostUserRegister(w, r, storage, tokens, auth.DefaultPasswordPolicy)
 	})
 	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
-		PostUserLogin(w, r, storage, tokenAuth)
+		PostUserLogin(w, r, storage, tokens, guard)
 	})
 	r.Group(func(r chi.Router) {
-		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(tokens.Verifier)
 		r.Use(jwtauth.Authenticator)
 
 		r.Post(urlPostUserOrders, func(w http.ResponseWriter, r *http.Request) {
@@ -306,7 +338,7 @@ func TestPostUserOrders(t *testing.T) {
 
 	bodyLogin := models.User{
 		Login:    "test",
-		Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC",
+		Password: "password",
 	}
 	bodyJSON, _ := json.Marshal(bodyLogin)
 	req := httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
@@ -397,41 +429,22 @@ func TestPostUserOrders(t *testing.T) {
 
 func TestGetUserOrders(t *testing.T) {
 	logger.Init()
-	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
-
-	mockDB := &mock.MockDB{
-		Users: map[int]map[string]string{
-			1: {"id": "1", "login": "test", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC", "sum": "10", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"id": "2", "login": "test2", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"id": "3", "login": "test3", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Orders: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "status": "NEW", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "status": "PROCESSED", "accrual": "10", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "status": "PROCESSING", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			4: {"number": "9347167976", "user_id": "1", "status": "INVALID", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Withdrawals: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "sum": "5", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "sum": "1", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "sum": "4", "processed_at": "2024-03-19 19:35:17.662533+00"},
-		},
-	}
+	tokens := newTestTokens(t)
 
-	storage := &store.StorageContext{}
-	storage.SetStorage(mockDB)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Compress(5, "application/json", "text/html"))
 
 	r.Post(urlPostUserRegister, func(w http.ResponseWriter, r *http.Request) {
-		PostUserRegister(w, r, storage, tokenAuth)
+		PostUserRegister(w, r, storage, tokens, auth.DefaultPasswordPolicy)
 	})
 	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
-		PostUserLogin(w, r, storage, tokenAuth)
+		PostUserLogin(w, r, storage, tokens, guard)
 	})
 	r.Group(func(r chi.Router) {
-		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(tokens.Verifier)
 		r.Use(jwtauth.Authenticator)
 
 		r.Post(urlPostUserOrders, func(w http.ResponseWriter, r *http.Request) {
@@ -453,7 +466,7 @@ func TestGetUserOrders(t *testing.T) {
 
 	bodyLogin := models.User{
 		Login:    "test",
-		Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC",
+		Password: "password",
 	}
 	bodyJSON, _ := json.Marshal(bodyLogin)
 	req := httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
@@ -466,7 +479,7 @@ func TestGetUserOrders(t *testing.T) {
 
 	bodyLogin2 := models.User{
 		Login:    "test3",
-		Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC",
+		Password: "password3",
 	}
 	bodyJSON2, _ := json.Marshal(bodyLogin2)
 	req = httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON2))
@@ -525,41 +538,22 @@ func TestGetUserOrders(t *testing.T) {
 
 func TestGetUserBalance(t *testing.T) {
 	logger.Init()
-	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
-
-	mockDB := &mock.MockDB{
-		Users: map[int]map[string]string{
-			1: {"id": "1", "login": "test", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC", "sum": "10", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"id": "2", "login": "test2", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"id": "3", "login": "test3", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Orders: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "status": "NEW", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "status": "PROCESSED", "accrual": "10", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "status": "PROCESSING", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			4: {"number": "9347167976", "user_id": "1", "status": "INVALID", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Withdrawals: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "sum": "5", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "sum": "1", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "sum": "4", "processed_at": "2024-03-19 19:35:17.662533+00"},
-		},
-	}
+	tokens := newTestTokens(t)
 
-	storage := &store.StorageContext{}
-	storage.SetStorage(mockDB)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Compress(5, "application/json", "text/html"))
 
 	r.Post(urlPostUserRegister, func(w http.ResponseWriter, r *http.Request) {
-		PostUserRegister(w, r, storage, tokenAuth)
+		PostUserRegister(w, r, storage, tokens, auth.DefaultPasswordPolicy)
 	})
 	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
-		PostUserLogin(w, r, storage, tokenAuth)
+		PostUserLogin(w, r, storage, tokens, guard)
 	})
 	r.Group(func(r chi.Router) {
-		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(tokens.Verifier)
 		r.Use(jwtauth.Authenticator)
 
 		r.Post(urlPostUserOrders, func(w http.ResponseWriter, r *http.Request) {
@@ -581,7 +575,7 @@ func TestGetUserBalance(t *testing.T) {
 
 	bodyLogin := models.User{
 		Login:    "test",
-		Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC",
+		Password: "password",
 	}
 	bodyJSON, _ := json.Marshal(bodyLogin)
 	req := httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
@@ -631,41 +625,22 @@ func TestGetUserBalance(t *testing.T) {
 
 func TestPostUserBalanceWithdraw(t *testing.T) {
 	logger.Init()
-	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
-
-	mockDB := &mock.MockDB{
-		Users: map[int]map[string]string{
-			1: {"id": "1", "login": "test", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC", "sum": "10", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"id": "2", "login": "test2", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"id": "3", "login": "test3", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "10", "registered_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Orders: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "status": "NEW", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "status": "PROCESSED", "accrual": "10", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "status": "PROCESSING", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			4: {"number": "9347167976", "user_id": "1", "status": "INVALID", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Withdrawals: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "sum": "5", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "sum": "1", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "sum": "4", "processed_at": "2024-03-19 19:35:17.662533+00"},
-		},
-	}
+	tokens := newTestTokens(t)
 
-	storage := &store.StorageContext{}
-	storage.SetStorage(mockDB)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Compress(5, "application/json", "text/html"))
 
 	r.Post(urlPostUserRegister, func(w http.ResponseWriter, r *http.Request) {
-		PostUserRegister(w, r, storage, tokenAuth)
+		PostUserRegister(w, r, storage, tokens, auth.DefaultPasswordPolicy)
 	})
 	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
-		PostUserLogin(w, r, storage, tokenAuth)
+		PostUserLogin(w, r, storage, tokens, guard)
 	})
 	r.Group(func(r chi.Router) {
-		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(tokens.Verifier)
 		r.Use(jwtauth.Authenticator)
 
 		r.Post(urlPostUserOrders, func(w http.ResponseWriter, r *http.Request) {
@@ -687,7 +662,7 @@ func TestPostUserBalanceWithdraw(t *testing.T) {
 
 	bodyLogin := models.User{
 		Login:    "test",
-		Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC",
+		Password: "password",
 	}
 	bodyJSON, _ := json.Marshal(bodyLogin)
 	req := httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
@@ -698,6 +673,18 @@ func TestPostUserBalanceWithdraw(t *testing.T) {
 	r.ServeHTTP(w, req)
 	jwtTok := w.Header().Get("Authorization")
 
+	getBalance := func() models.Balance {
+		req := httptest.NewRequest(http.MethodGet, urlGetUserBalance, nil)
+		req.Header.Set("Authorization", jwtTok)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var balance models.Balance
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &balance))
+		return balance
+	}
+	before := getBalance()
+
 	type want struct {
 		code int
 	}
@@ -714,7 +701,7 @@ func TestPostUserBalanceWithdraw(t *testing.T) {
 			url:  urlPostUserBalanceWithdraw,
 			body: models.BalanceWithdrawn{
 				Order: "8593379475",
-				Sum:   1,
+				Sum:   models.MoneyFromKopecks(100),
 			},
 			jwtToken:   jwtTok,
 			typeReqest: http.MethodPost,
@@ -727,7 +714,7 @@ func TestPostUserBalanceWithdraw(t *testing.T) {
 			url:  urlPostUserBalanceWithdraw,
 			body: models.BalanceWithdrawn{
 				Order: "8593379475",
-				Sum:   100,
+				Sum:   models.MoneyFromKopecks(10000),
 			},
 			jwtToken:   jwtTok,
 			typeReqest: http.MethodPost,
@@ -740,7 +727,33 @@ func TestPostUserBalanceWithdraw(t *testing.T) {
 			url:  urlPostUserBalanceWithdraw,
 			body: models.BalanceWithdrawn{
 				Order: "7950830",
-				Sum:   1,
+				Sum:   models.MoneyFromKopecks(100),
+			},
+			jwtToken:   jwtTok,
+			typeReqest: http.MethodPost,
+			want: want{
+				code: 422,
+			},
+		},
+		{
+			name: "отрицательная сумма",
+			url:  urlPostUserBalanceWithdraw,
+			body: models.BalanceWithdrawn{
+				Order: "2377225624",
+				Sum:   models.MoneyFromKopecks(-100),
+			},
+			jwtToken:   jwtTok,
+			typeReqest: http.MethodPost,
+			want: want{
+				code: 422,
+			},
+		},
+		{
+			name: "нулевая сумма",
+			url:  urlPostUserBalanceWithdraw,
+			body: models.BalanceWithdrawn{
+				Order: "2377225624",
+				Sum:   0,
 			},
 			jwtToken:   jwtTok,
 			typeReqest: http.MethodPost,
@@ -766,45 +779,31 @@ func TestPostUserBalanceWithdraw(t *testing.T) {
 			assert.Equal(t, test.want.code, w.Code)
 		})
 	}
+
+	// отклонённые списания не меняют баланс
+	balance := getBalance()
+	assert.Equal(t, before.Withdrawn+models.MoneyFromKopecks(100), balance.Withdrawn)
+	assert.Equal(t, before.Current-models.MoneyFromKopecks(100), balance.Current)
 }
 
 func TestGetUserWithdrawals(t *testing.T) {
 	logger.Init()
-	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
-
-	mockDB := &mock.MockDB{
-		Users: map[int]map[string]string{
-			1: {"id": "1", "login": "test", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC", "sum": "10", "withdrawn": "6", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"id": "2", "login": "test2", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3z.EfNz7rC", "sum": "0", "withdrawn": "4", "registered_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"id": "3", "login": "test3", "password": "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC", "sum": "0", "withdrawn": "0", "registered_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Orders: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "status": "NEW", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "status": "PROCESSED", "accrual": "10", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "status": "PROCESSING", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-			4: {"number": "9347167976", "user_id": "1", "status": "INVALID", "uploaded_at": "2024-03-19 19:35:17.662533+00"},
-		},
-		Withdrawals: map[int]map[string]string{
-			1: {"number": "7950839220", "user_id": "1", "sum": "5", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			2: {"number": "2396508901", "user_id": "1", "sum": "1", "processed_at": "2024-03-19 19:35:17.662533+00"},
-			3: {"number": "1852074499", "user_id": "2", "sum": "4", "processed_at": "2024-03-19 19:35:17.662533+00"},
-		},
-	}
+	tokens := newTestTokens(t)
 
-	storage := &store.StorageContext{}
-	storage.SetStorage(mockDB)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Compress(5, "application/json", "text/html"))
 
 	r.Post(urlPostUserRegister, func(w http.ResponseWriter, r *http.Request) {
-		PostUserRegister(w, r, storage, tokenAuth)
+		PostUserRegister(w, r, storage, tokens, auth.DefaultPasswordPolicy)
 	})
 	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
-		PostUserLogin(w, r, storage, tokenAuth)
+		PostUserLogin(w, r, storage, tokens, guard)
 	})
 	r.Group(func(r chi.Router) {
-		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(tokens.Verifier)
 		r.Use(jwtauth.Authenticator)
 
 		r.Post(urlPostUserOrders, func(w http.ResponseWriter, r *http.Request) {
@@ -826,7 +825,7 @@ func TestGetUserWithdrawals(t *testing.T) {
 
 	bodyLogin := models.User{
 		Login:    "test",
-		Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC",
+		Password: "password",
 	}
 	bodyJSON, _ := json.Marshal(bodyLogin)
 	req := httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
@@ -839,7 +838,7 @@ func TestGetUserWithdrawals(t *testing.T) {
 
 	bodyLogin = models.User{
 		Login:    "test3",
-		Password: "$2a$10$kte3HgQ6VtHaZSBVc0Cr2OSHQnVL3UB5C0mJLnPVA5W3y.EfNz7rC",
+		Password: "password3",
 	}
 	bodyJSON, _ = json.Marshal(bodyLogin)
 	req = httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
@@ -904,3 +903,480 @@ func TestGetUserWithdrawals(t *testing.T) {
 		})
 	}
 }
+
+// newAuthRouter возвращает маршрутизатор входа, обновления токенов, выхода и баланса с проверкой отзыва токенов
+func newAuthRouter(t *testing.T) http.Handler {
+	t.Helper()
+	return newAuthRouterWithNotifier(t, &recordingNotifier{})
+}
+
+// newAuthRouterWithNotifier собирает маршруты входа, токенов и паролей, токены сброса пароля отправляются через notifier
+func newAuthRouterWithNotifier(t *testing.T, notifier notify.Notifier) http.Handler {
+	t.Helper()
+	tokens := newTestTokens(t)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.DefaultLoginPolicy)
+
+	r := chi.NewRouter()
+	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
+		PostUserLogin(w, r, storage, tokens, guard)
+	})
+	r.Post(urlPostUserTokenRefresh, func(w http.ResponseWriter, r *http.Request) {
+		PostUserTokenRefresh(w, r, storage, tokens)
+	})
+	r.Post(urlPostUserPasswordReset, func(w http.ResponseWriter, r *http.Request) {
+		PostUserPasswordReset(w, r, storage, notifier, time.Hour)
+	})
+	r.Post(urlPostUserPasswordResetConfirm, func(w http.ResponseWriter, r *http.Request) {
+		PostUserPasswordResetConfirm(w, r, storage, auth.DefaultPasswordPolicy)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(tokens.Verifier)
+		r.Use(auth.RejectRevoked(storage.IsAccessTokenRevoked))
+		r.Use(jwtauth.Authenticator)
+
+		r.Post(urlPostUserLogout, func(w http.ResponseWriter, r *http.Request) {
+			PostUserLogout(w, r, storage)
+		})
+		r.Post(urlPostUserPassword, func(w http.ResponseWriter, r *http.Request) {
+			PostUserPassword(w, r, storage, tokens, auth.DefaultPasswordPolicy)
+		})
+		r.Get(urlGetUserBalance, func(w http.ResponseWriter, r *http.Request) {
+			GetUserBalance(w, r, storage)
+		})
+	})
+	return r
+}
+
+// login входит пользователем test и возвращает выданные токены
+func login(t *testing.T, r http.Handler) models.Tokens {
+	t.Helper()
+	bodyJSON, _ := json.Marshal(models.User{Login: "test", Password: "password"})
+	req := httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var tokens models.Tokens
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	assert.Equal(t, "Bearer "+tokens.AccessToken, w.Header().Get("Authorization"))
+	assert.NotEmpty(t, tokens.RefreshToken)
+	return tokens
+}
+
+// refresh обменивает токен обновления и возвращает код ответа и новые токены
+func refresh(t *testing.T, r http.Handler, refreshToken string) (int, models.Tokens) {
+	t.Helper()
+	bodyJSON, _ := json.Marshal(models.RefreshToken{RefreshToken: refreshToken})
+	req := httptest.NewRequest(http.MethodPost, urlPostUserTokenRefresh, bytes.NewReader(bodyJSON))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var tokens models.Tokens
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	}
+	return w.Code, tokens
+}
+
+// getBalance запрашивает баланс с токеном доступа и возвращает код ответа
+func getBalance(t *testing.T, r http.Handler, accessToken string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, urlGetUserBalance, nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestPostUserTokenRefresh(t *testing.T) {
+	logger.Init()
+	r := newAuthRouter(t)
+	first := login(t, r)
+
+	code, second := refresh(t, r, first.RefreshToken)
+	require.Equal(t, http.StatusOK, code)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken, "токен обновления меняется при каждом обмене")
+	assert.Equal(t, http.StatusOK, getBalance(t, r, second.AccessToken))
+
+	// повторный обмен уже использованного токена отзывает и выданный взамен
+	code, _ = refresh(t, r, first.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = refresh(t, r, second.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = refresh(t, r, "unknown")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = refresh(t, r, "")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestPostUserLogout(t *testing.T) {
+	logger.Init()
+	r := newAuthRouter(t)
+	tokens := login(t, r)
+	other := login(t, r)
+
+	bodyJSON, _ := json.Marshal(models.RefreshToken{RefreshToken: tokens.RefreshToken})
+	req := httptest.NewRequest(http.MethodPost, urlPostUserLogout, bytes.NewReader(bodyJSON))
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, getBalance(t, r, tokens.AccessToken), "токен доступа отозван")
+	code, _ := refresh(t, r, tokens.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code, "токен обновления отозван")
+	assert.Equal(t, http.StatusOK, getBalance(t, r, other.AccessToken), "другой вход не затронут")
+
+	req = httptest.NewRequest(http.MethodPost, urlPostUserLogout, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// postJSON отправляет запрос с телом body и, если он задан, токеном доступа
+func postJSON(t *testing.T, r http.Handler, url string, accessToken string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	bodyJSON, err := json.Marshal(body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(bodyJSON))
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPostUserPassword(t *testing.T) {
+	logger.Init()
+	r := newAuthRouter(t)
+	current := login(t, r)
+	other := login(t, r)
+
+	type want struct {
+		code int
+	}
+	tests := []struct {
+		name        string
+		accessToken string
+		body        models.PasswordChange
+		want        want
+	}{
+		{
+			name: "пользователь не аутентифицирован",
+			body: models.PasswordChange{OldPassword: "password", NewPassword: "new password"},
+			want: want{
+				code: 401,
+			},
+		},
+		{
+			name:        "неверный текущий пароль",
+			accessToken: current.AccessToken,
+			body:        models.PasswordChange{OldPassword: "wrong-password", NewPassword: "new password"},
+			want: want{
+				code: 403,
+			},
+		},
+		{
+			name:        "новый пароль не соответствует требованиям",
+			accessToken: current.AccessToken,
+			body:        models.PasswordChange{OldPassword: "password", NewPassword: "short"},
+			want: want{
+				code: 400,
+			},
+		},
+		{
+			name:        "пароль изменён",
+			accessToken: current.AccessToken,
+			body:        models.PasswordChange{OldPassword: "password", NewPassword: "new password"},
+			want: want{
+				code: 200,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := postJSON(t, r, urlPostUserPassword, test.accessToken, test.body)
+			assert.Equal(t, test.want.code, w.Code)
+		})
+	}
+
+	code, _ := refresh(t, r, other.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code, "другие сеансы завершены")
+	w := postJSON(t, r, urlPostUserLogin, "", models.User{Login: "test", Password: "new password"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// recordingNotifier запоминает отправленные токены сброса пароля
+type recordingNotifier struct {
+	resets []notify.PasswordReset
+}
+
+func (n *recordingNotifier) SendPasswordReset(ctx context.Context, message notify.PasswordReset) error {
+	n.resets = append(n.resets, message)
+	return nil
+}
+
+func TestPostUserPasswordReset(t *testing.T) {
+	logger.Init()
+	notifier := &recordingNotifier{}
+	r := newAuthRouterWithNotifier(t, notifier)
+	session := login(t, r)
+
+	w := postJSON(t, r, urlPostUserPasswordReset, "", models.PasswordResetRequest{Login: "nobody"})
+	assert.Equal(t, http.StatusAccepted, w.Code, "ответ не выдаёт, есть ли пользователь")
+	assert.Empty(t, notifier.resets)
+
+	w = postJSON(t, r, urlPostUserPasswordReset, "", models.PasswordResetRequest{Login: " Test "})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Len(t, notifier.resets, 1)
+	reset := notifier.resets[0]
+	assert.Equal(t, "test", reset.Login)
+
+	type want struct {
+		code int
+	}
+	tests := []struct {
+		name string
+		body models.PasswordResetConfirm
+		want want
+	}{
+		{
+			name: "неверный токен",
+			body: models.PasswordResetConfirm{Token: "wrong", Password: "new password"},
+			want: want{
+				code: 401,
+			},
+		},
+		{
+			name: "пароль не соответствует требованиям",
+			body: models.PasswordResetConfirm{Token: reset.Token, Password: "short"},
+			want: want{
+				code: 400,
+			},
+		},
+		{
+			name: "пароль сброшен",
+			body: models.PasswordResetConfirm{Token: reset.Token, Password: "new password"},
+			want: want{
+				code: 200,
+			},
+		},
+		{
+			name: "токен уже использован",
+			body: models.PasswordResetConfirm{Token: reset.Token, Password: "other password"},
+			want: want{
+				code: 401,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := postJSON(t, r, urlPostUserPasswordResetConfirm, "", test.body)
+			assert.Equal(t, test.want.code, w.Code)
+		})
+	}
+
+	code, _ := refresh(t, r, session.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code, "сеансы завершены")
+	w = postJSON(t, r, urlPostUserLogin, "", models.User{Login: "test", Password: "new password"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPostAccrualCallback(t *testing.T) {
+	logger.Init()
+	secret := []byte("callback-secret")
+
+	storage := newTestStorage(t)
+
+	r := chi.NewRouter()
+	r.Post(urlPostAccrualCallback, func(w http.ResponseWriter, r *http.Request) {
+		PostAccrualCallback(w, r, storage, secret)
+	})
+
+	processed := []byte(`{"order":"7950839220","status":"PROCESSED","accrual":100}`)
+	now := time.Now()
+
+	type want struct {
+		code int
+	}
+	tests := []struct {
+		name      string
+		body      []byte
+		timestamp time.Time
+		secret    []byte
+		upper     bool
+		want      want
+	}{
+		{
+			name:      "успешная обработка запроса",
+			body:      processed,
+			timestamp: now,
+			secret:    secret,
+			want: want{
+				code: 200,
+			},
+		},
+		{
+			name:      "повтор уведомления",
+			body:      processed,
+			timestamp: now,
+			secret:    secret,
+			want: want{
+				code: 409,
+			},
+		},
+		{
+			name:      "повтор уведомления с подписью в верхнем регистре",
+			body:      processed,
+			timestamp: now,
+			secret:    secret,
+			upper:     true,
+			want: want{
+				code: 401,
+			},
+		},
+		{
+			name:      "неверная подпись",
+			body:      processed,
+			timestamp: now.Add(time.Second),
+			secret:    []byte("other"),
+			want: want{
+				code: 401,
+			},
+		},
+		{
+			name:      "устаревшее уведомление",
+			body:      processed,
+			timestamp: now.Add(-time.Hour),
+			secret:    secret,
+			want: want{
+				code: 401,
+			},
+		},
+		{
+			name:      "заказ не найден",
+			body:      []byte(`{"order":"12345678903","status":"PROCESSED","accrual":100}`),
+			timestamp: now,
+			secret:    secret,
+			want: want{
+				code: 404,
+			},
+		},
+		{
+			name:      "неверный формат запроса",
+			body:      []byte(`{"order":"abc"}`),
+			timestamp: now,
+			secret:    secret,
+			want: want{
+				code: 400,
+			},
+		},
+		{
+			name:      "слишком большое тело",
+			body:      append([]byte(`{"order":"7950839220","status":"PROCESSED","padding":"`), bytes.Repeat([]byte("x"), accrual.CallbackMaxBodySize)...),
+			timestamp: now,
+			secret:    secret,
+			want: want{
+				code: 413,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, urlPostAccrualCallback, bytes.NewReader(test.body))
+			req.Header.Set(accrual.CallbackTimestampHeader, strconv.FormatInt(test.timestamp.Unix(), 10))
+			signature := accrual.SignCallback(test.secret, test.timestamp, test.body)
+			if test.upper {
+				signature = "sha256=" + strings.ToUpper(strings.TrimPrefix(signature, "sha256="))
+			}
+			req.Header.Set(accrual.CallbackSignatureHeader, signature)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, test.want.code, w.Code)
+		})
+	}
+
+	balance, err := storage.GetUserBalance(context.Background(), "test")
+	require.NoError(t, err)
+	assert.Equal(t, models.MoneyFromKopecks(1500+10000-500), balance.Current, "начисление применено один раз")
+}
+
+func TestPostUserLoginThrottle(t *testing.T) {
+	logger.Init()
+	tokens := newTestTokens(t)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.LoginPolicy{
+		Window:      time.Minute,
+		LoginLimit:  3,
+		IPLimit:     100,
+		MaxFailures: 100,
+		Lockout:     time.Minute,
+	})
+
+	r := chi.NewRouter()
+	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
+		PostUserLogin(w, r, storage, tokens, guard)
+	})
+	post := func(password string) *httptest.ResponseRecorder {
+		bodyJSON, _ := json.Marshal(models.User{Login: "test", Password: password})
+		req := httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, post("wrong-password").Code)
+	}
+	w := post("password")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "верный пароль не проверяется, пока лимит исчерпан")
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.True(t, retryAfter > 0 && retryAfter <= 60)
+}
+
+func TestPostUserLoginThrottleParallel(t *testing.T) {
+	logger.Init()
+	tokens := newTestTokens(t)
+	storage := newTestStorage(t)
+	guard := auth.NewLoginGuard(storage, auth.LoginPolicy{
+		Window:      time.Minute,
+		LoginLimit:  3,
+		IPLimit:     100,
+		MaxFailures: 100,
+		Lockout:     time.Minute,
+	})
+
+	r := chi.NewRouter()
+	r.Post(urlPostUserLogin, func(w http.ResponseWriter, r *http.Request) {
+		PostUserLogin(w, r, storage, tokens, guard)
+	})
+
+	// неверные пароли одновременно: bcrypt медленный, и все запросы проверяют ограничение раньше, чем учтена первая неудача
+	codes := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodyJSON, _ := json.Marshal(models.User{Login: "test", Password: "wrong-password"})
+			req := httptest.NewRequest(http.MethodPost, urlPostUserLogin, bytes.NewReader(bodyJSON))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusUnauthorized: 3, http.StatusTooManyRequests: 7}, counts)
+}
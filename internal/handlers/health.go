@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"gophermart/internal/accrual"
+	"gophermart/internal/models"
+	"gophermart/internal/store"
+)
+
+// Schema версия схемы базы данных, которую проверяет /readyz
+type Schema interface {
+	Version(ctx context.Context) (int64, error)
+	Latest() int64
+}
+
+// Readiness проверяет, может ли реплика принимать запросы
+type Readiness struct {
+	storage  *store.StorageContext
+	schema   Schema
+	breaker  *accrual.Breaker
+	draining atomic.Bool
+}
+
+// NewReadiness создаёт проверку готовности хранилища и системы расчёта.
+// Версия схемы проверяется, только если задана через CheckSchema: хранилищу в памяти миграции не нужны.
+func NewReadiness(storage *store.StorageContext, breaker *accrual.Breaker) *Readiness {
+	return &Readiness{storage: storage, breaker: breaker}
+}
+
+// CheckSchema добавляет в проверку версию схемы базы данных
+func (r *Readiness) CheckSchema(schema Schema) {
+	r.schema = schema
+}
+
+// Drain снимает реплику с балансировки на время остановки
+func (r *Readiness) Drain() {
+	r.draining.Store(true)
+}
+
+// Check проверяет компоненты реплики. Реплика не готова, если хотя бы один компонент в состоянии down.
+// Разомкнутый выключатель системы расчёта только помечает компонент degraded: API пользователей работает
+// и без неё, а снятие с балансировки всех реплик разом превратило бы сбой системы расчёта в отказ сервиса.
+func (r *Readiness) Check(ctx context.Context) models.Readiness {
+	result := models.Readiness{Ready: true, Components: make(map[string]models.ComponentStatus)}
+	add := func(name string, status models.ComponentStatus) {
+		result.Components[name] = status
+		if status.Status == models.ComponentDown {
+			result.Ready = false
+		}
+	}
+
+	if r.draining.Load() {
+		add("shutdown", models.ComponentStatus{Status: models.ComponentDown, Error: "service is shutting down"})
+	} else {
+		add("shutdown", models.ComponentStatus{Status: models.ComponentUp})
+	}
+
+	if r.storage.Ping(ctx) {
+		add("database", models.ComponentStatus{Status: models.ComponentUp})
+	} else {
+		add("database", models.ComponentStatus{Status: models.ComponentDown, Error: "database is unreachable"})
+	}
+
+	if r.schema != nil {
+		expected := r.schema.Latest()
+		version, err := r.schema.Version(ctx)
+		switch {
+		case err != nil:
+			add("migrations", models.ComponentStatus{Status: models.ComponentDown, Expected: &expected, Error: err.Error()})
+		case version != expected:
+			add("migrations", models.ComponentStatus{Status: models.ComponentDown, Version: &version, Expected: &expected,
+				Error: fmt.Sprintf("schema version %d, expected %d", version, expected)})
+		default:
+			add("migrations", models.ComponentStatus{Status: models.ComponentUp, Version: &version, Expected: &expected})
+		}
+	}
+
+	if r.breaker != nil {
+		state := r.breaker.State()
+		if state == accrual.BreakerClosed {
+			add("accrual", models.ComponentStatus{Status: models.ComponentUp, Circuit: state.String()})
+		} else {
+			add("accrual", models.ComponentStatus{Status: models.ComponentDegraded, Circuit: state.String()})
+		}
+	}
+	return result
+}
+
+// GetHealthz Проверка, что процесс жив
+// @Summary Проверка, что процесс жив
+// @Description Не обращается к базе данных и системе расчёта: неудача значит, что процесс нужно перезапустить.
+// @Produce json
+// @Success 200 {string}  string    "процесс жив"
+// @Router /healthz [get]
+func GetHealthz(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	_, _ = res.Write([]byte(`{"status":"ok"}`))
+}
+
+// GetReadyz Проверка готовности принимать запросы
+// @Summary Проверка готовности принимать запросы
+// @Description Проверяет доступность базы данных, версию схемы и состояние выключателя системы расчёта.
+// @Description Во время остановки сервиса реплика не готова, чтобы балансировщик перестал направлять на неё запросы.
+// @Produce json
+// @Success 200 {object}  models.Readiness    "реплика готова"
+// @Failure 503 {object}  models.Readiness    "реплика не готова"
+// @Router /readyz [get]
+func GetReadyz(res http.ResponseWriter, req *http.Request, readiness *Readiness) {
+	ctx, cancel := context.WithTimeout(req.Context(), requestTimeout)
+	defer cancel()
+	res.Header().Set("Content-Type", "application/json")
+
+	result := readiness.Check(ctx)
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if result.Ready {
+		res.WriteHeader(http.StatusOK)
+	} else {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = res.Write(jsonBytes)
+}
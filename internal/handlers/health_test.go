@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gophermart/internal/accrual"
+	"gophermart/internal/logger"
+	"gophermart/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSchema версия схемы базы данных для проверки готовности
+type testSchema struct {
+	version int64
+	latest  int64
+}
+
+func (s testSchema) Version(ctx context.Context) (int64, error) {
+	return s.version, nil
+}
+
+func (s testSchema) Latest() int64 {
+	return s.latest
+}
+
+func TestGetHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	GetHealthz(w, httptest.NewRequest(http.MethodGet, urlGetHealthz, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestGetReadyz(t *testing.T) {
+	logger.Init()
+	storage := newTestStorage(t)
+
+	type want struct {
+		code       int
+		components map[string]string
+	}
+	tests := []struct {
+		name    string
+		prepare func(readiness *Readiness, breaker *accrual.Breaker)
+		want    want
+	}{
+		{
+			name:    "все компоненты работают",
+			prepare: func(readiness *Readiness, breaker *accrual.Breaker) {},
+			want: want{
+				code:       200,
+				components: map[string]string{"database": "up", "migrations": "up", "accrual": "up", "shutdown": "up"},
+			},
+		},
+		{
+			name: "схема отстаёт от приложения",
+			prepare: func(readiness *Readiness, breaker *accrual.Breaker) {
+				readiness.CheckSchema(testSchema{version: 11, latest: 12})
+			},
+			want: want{
+				code:       503,
+				components: map[string]string{"database": "up", "migrations": "down", "accrual": "up", "shutdown": "up"},
+			},
+		},
+		{
+			name: "система расчёта недоступна",
+			prepare: func(readiness *Readiness, breaker *accrual.Breaker) {
+				breaker.Failure()
+			},
+			want: want{
+				code:       200,
+				components: map[string]string{"database": "up", "migrations": "up", "accrual": "degraded", "shutdown": "up"},
+			},
+		},
+		{
+			name: "остановка сервиса",
+			prepare: func(readiness *Readiness, breaker *accrual.Breaker) {
+				readiness.Drain()
+			},
+			want: want{
+				code:       503,
+				components: map[string]string{"database": "up", "migrations": "up", "accrual": "up", "shutdown": "down"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := accrual.NewBreaker(accrual.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+			readiness := NewReadiness(storage, breaker)
+			readiness.CheckSchema(testSchema{version: 12, latest: 12})
+			test.prepare(readiness, breaker)
+
+			w := httptest.NewRecorder()
+			GetReadyz(w, httptest.NewRequest(http.MethodGet, urlGetReadyz, nil), readiness)
+			assert.Equal(t, test.want.code, w.Code)
+
+			var result models.Readiness
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, test.want.code == http.StatusOK, result.Ready)
+			components := make(map[string]string)
+			for name, component := range result.Components {
+				components[name] = component.Status
+			}
+			assert.Equal(t, test.want.components, components)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"gophermart/internal/auth"
+	"gophermart/internal/logger"
+
+	"github.com/go-chi/jwtauth"
+	"go.uber.org/zap"
+)
+
+// LogLogin добавляет логин владельца токена в журнал запроса. Ставится после jwtauth.Authenticator.
+func LogLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, err := jwtauth.FromContext(r.Context())
+		if err == nil {
+			if login, ok := claims[auth.ClaimUsername].(string); ok && login != "" {
+				logger.AddFields(r.Context(), zap.String("login", login))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
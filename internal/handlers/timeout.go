@@ -0,0 +1,16 @@
+package handlers
+
+import "time"
+
+// Время на обработку запроса: requestTimeout — для запросов к одной записи, longRequestTimeout — для списков
+// и запросов с проверкой пароля
+var (
+	requestTimeout     = 5 * time.Second
+	longRequestTimeout = 30 * time.Second
+)
+
+// SetTimeouts задаёт время на обработку запросов, вызывается до запуска сервера
+func SetTimeouts(timeout time.Duration, longTimeout time.Duration) {
+	requestTimeout = timeout
+	longRequestTimeout = longTimeout
+}
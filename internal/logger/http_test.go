@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMiddleware(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	Logger = zap.New(core)
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(Middleware)
+	r.Get("/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		AddFields(r.Context(), zap.String("login", "user"))
+		FromContext(r.Context()).Info("внутри обработчика")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/12345678903", nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+
+	handler := entries[0].ContextMap()
+	assert.Equal(t, "req-1", handler["request_id"], "строки обработчика связаны с запросом")
+	assert.Equal(t, "GET", handler["method"])
+	assert.Equal(t, "user", handler["login"])
+
+	access := entries[1].ContextMap()
+	assert.Equal(t, "Запрос обработан", entries[1].Message)
+	assert.Equal(t, "req-1", access["request_id"])
+	assert.Equal(t, "/orders/{number}", access["route"])
+	assert.Equal(t, "/orders/12345678903", access["path"])
+	assert.Equal(t, int64(http.StatusAccepted), access["status"])
+	assert.Equal(t, "user", access["login"], "логин, добавленный глубже по цепочке, попадает в итоговую строку")
+	assert.Contains(t, access, "latency")
+}
+
+func TestFromContextOutsideRequest(t *testing.T) {
+	Init()
+	assert.Same(t, Logger, FromContext(context.Background()))
+	AddFields(context.Background(), zap.String("login", "user"))
+}
@@ -1,13 +1,100 @@
 package logger
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Форматы журнала, которые выбираются LOG_FORMAT
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
 )
 
+var ErrUnknownFormat = errors.New("unknown log format")
+
 var Logger *zap.Logger
 
+// level уровень журнала, общий для Logger и всех журналов запросов
+var level = zap.NewAtomicLevelAt(zap.InfoLevel)
+
 func Init() {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 	Logger = logger
 }
+
+// Configure заменяет Logger журналом с уровнем levelName (debug, info, warn, error) и форматом format
+func Configure(levelName string, format string) error {
+	parsed, err := zapcore.ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+
+	config := zap.NewProductionConfig()
+	switch format {
+	case "", FormatJSON:
+	case FormatConsole:
+		config.Encoding = FormatConsole
+		config.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	level.SetLevel(parsed)
+	config.Level = level
+
+	logger, err := config.Build()
+	if err != nil {
+		return err
+	}
+	Logger = logger
+	return nil
+}
+
+// SetLevel меняет уровень Logger и всех журналов запросов на ходу
+func SetLevel(levelName string) error {
+	parsed, err := zapcore.ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(parsed)
+	return nil
+}
+
+// requestLogger журнал запроса. Поля добавляются и после того, как журнал положен в контекст:
+// логин становится известен только после проверки токена, глубже по цепочке middleware.
+type requestLogger struct {
+	mu     sync.Mutex
+	logger *zap.Logger
+}
+
+type contextKey struct{}
+
+// WithLogger возвращает контекст с журналом запроса logger
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestLogger{logger: logger})
+}
+
+// FromContext возвращает журнал запроса из ctx, вне запроса — Logger
+func FromContext(ctx context.Context) *zap.Logger {
+	if rl, ok := ctx.Value(contextKey{}).(*requestLogger); ok {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		return rl.logger
+	}
+	return Logger
+}
+
+// AddFields добавляет поля в журнал запроса из ctx, вне запроса ничего не делает
+func AddFields(ctx context.Context, fields ...zap.Field) {
+	if rl, ok := ctx.Value(contextKey{}).(*requestLogger); ok {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		rl.logger = rl.logger.With(fields...)
+	}
+}
@@ -0,0 +1,20 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestConfigure(t *testing.T) {
+	assert.NoError(t, Configure("warn", FormatConsole))
+	assert.False(t, Logger.Core().Enabled(zap.InfoLevel))
+	assert.True(t, Logger.Core().Enabled(zap.WarnLevel))
+
+	assert.NoError(t, Configure("debug", FormatJSON))
+	assert.True(t, Logger.Core().Enabled(zap.DebugLevel))
+
+	assert.Error(t, Configure("verbose", FormatJSON))
+	assert.ErrorIs(t, Configure("info", "xml"), ErrUnknownFormat)
+}
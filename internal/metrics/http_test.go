@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Post("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	for _, url := range []string{"/items/1", "/items/2", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/items", nil))
+	for _, method := range []string{"FOO", "BAR"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/missing", nil))
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(HTTPRequests.WithLabelValues(http.MethodGet, "/items/{id}", "200")),
+		"запросы к разным заказам учитываются по одному шаблону маршрута")
+	assert.Equal(t, float64(1), testutil.ToFloat64(HTTPRequests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(HTTPRequests.WithLabelValues(http.MethodPost, "/items", "202")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(HTTPRequests.WithLabelValues(otherMethod, unmatchedRoute, "405")),
+		"нестандартные методы не создают отдельных рядов")
+	assert.Equal(t, 4, testutil.CollectAndCount(HTTPRequestDuration), "по ряду гистограммы на метод и маршрут")
+}
@@ -0,0 +1,117 @@
+// Package metrics метрики сервиса в формате Prometheus.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+// Registry реестр метрик сервиса, отдаётся обработчиком Handler
+var Registry = prometheus.NewRegistry()
+
+// AccrualCircuitState состояние автоматического выключателя клиента системы расчёта:
+// у текущего состояния значение 1, у остальных 0
+var AccrualCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Subsystem: "accrual",
+	Name:      "circuit_state",
+	Help:      "Current state of the accrual system circuit breaker.",
+}, []string{"state"})
+
+// AccrualCircuitTransitions число переходов автоматического выключателя в каждое состояние
+var AccrualCircuitTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "accrual",
+	Name:      "circuit_transitions_total",
+	Help:      "Number of accrual system circuit breaker transitions by target state.",
+}, []string{"state"})
+
+// HTTPRequests число обработанных HTTP-запросов по методу, шаблону маршрута chi и коду ответа
+var HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "http",
+	Name:      "requests_total",
+	Help:      "Number of HTTP requests by method, chi route pattern and status code.",
+}, []string{"method", "route", "code"})
+
+// HTTPRequestDuration время обработки HTTP-запросов по методу и шаблону маршрута chi
+var HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Subsystem: "http",
+	Name:      "request_duration_seconds",
+	Help:      "HTTP request latency by method and chi route pattern.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"method", "route"})
+
+// AccrualQueueDepth число заказов, ожидающих расчёта начислений, на момент последней аренды
+var AccrualQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Subsystem: "accrual",
+	Name:      "queue_depth",
+	Help:      "Number of orders awaiting accrual, sampled when a batch is claimed.",
+})
+
+// AccrualResponses ответы системы расчёта по коду HTTP, error — запрос не выполнен
+var AccrualResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "accrual",
+	Name:      "responses_total",
+	Help:      "Number of accrual system responses by HTTP status code, error for failed requests.",
+}, []string{"code"})
+
+// AccrualOrderStatuses статусы заказов в успешных ответах системы расчёта
+var AccrualOrderStatuses = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "accrual",
+	Name:      "order_statuses_total",
+	Help:      "Number of order statuses reported by the accrual system.",
+}, []string{"status"})
+
+// AccrualWorkerBusy суммарное время, которое воркеры потратили на опрос заказов
+var AccrualWorkerBusy = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "accrual",
+	Name:      "worker_busy_seconds_total",
+	Help:      "Total time accrual workers spent processing orders.",
+})
+
+// PointsCredited начисленные баллы
+var PointsCredited = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "points_credited_total",
+	Help:      "Total loyalty points credited to users by the accrual system.",
+})
+
+// PointsWithdrawn списанные пользователями баллы
+var PointsWithdrawn = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "points_withdrawn_total",
+	Help:      "Total loyalty points withdrawn by users.",
+})
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		AccrualCircuitState,
+		AccrualCircuitTransitions,
+		HTTPRequests,
+		HTTPRequestDuration,
+		AccrualQueueDepth,
+		AccrualResponses,
+		AccrualOrderStatuses,
+		AccrualWorkerBusy,
+		PointsCredited,
+		PointsWithdrawn,
+	)
+}
+
+// Handler отдаёт метрики из Registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector снимает статистику пула соединений pgx при каждом запросе метрик
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired     *prometheus.Desc
+	idle         *prometheus.Desc
+	constructing *prometheus.Desc
+	total        *prometheus.Desc
+	max          *prometheus.Desc
+
+	acquires         *prometheus.Desc
+	acquireDuration  *prometheus.Desc
+	canceledAcquires *prometheus.Desc
+	emptyAcquires    *prometheus.Desc
+	newConns         *prometheus.Desc
+	lifetimeDestroys *prometheus.Desc
+	idleDestroys     *prometheus.Desc
+}
+
+// NewPoolCollector метрики пула соединений PostgreSQL с префиксом gophermart_db_pool_
+func NewPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+	}
+	return &poolCollector{
+		pool: pool,
+
+		acquired:     desc("acquired_connections", "Number of currently acquired connections in the pool."),
+		idle:         desc("idle_connections", "Number of currently idle connections in the pool."),
+		constructing: desc("constructing_connections", "Number of connections being established."),
+		total:        desc("total_connections", "Total number of connections in the pool."),
+		max:          desc("max_connections", "Maximum size of the pool."),
+
+		acquires:         desc("acquires_total", "Number of successful connection acquires from the pool."),
+		acquireDuration:  desc("acquire_duration_seconds_total", "Total time spent waiting for successful acquires."),
+		canceledAcquires: desc("canceled_acquires_total", "Number of acquires canceled by a context."),
+		emptyAcquires:    desc("empty_acquires_total", "Number of acquires that waited for a connection because the pool was empty."),
+		newConns:         desc("new_connections_total", "Number of new connections opened."),
+		lifetimeDestroys: desc("max_lifetime_destroys_total", "Number of connections closed due to MaxConnLifetime."),
+		idleDestroys:     desc("max_idle_destroys_total", "Number of connections closed due to MaxConnIdleTime."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	gauge(c.acquired, float64(stat.AcquiredConns()))
+	gauge(c.idle, float64(stat.IdleConns()))
+	gauge(c.constructing, float64(stat.ConstructingConns()))
+	gauge(c.total, float64(stat.TotalConns()))
+	gauge(c.max, float64(stat.MaxConns()))
+
+	counter(c.acquires, float64(stat.AcquireCount()))
+	counter(c.acquireDuration, stat.AcquireDuration().Seconds())
+	counter(c.canceledAcquires, float64(stat.CanceledAcquireCount()))
+	counter(c.emptyAcquires, float64(stat.EmptyAcquireCount()))
+	counter(c.newConns, float64(stat.NewConnsCount()))
+	counter(c.lifetimeDestroys, float64(stat.MaxLifetimeDestroyCount()))
+	counter(c.idleDestroys, float64(stat.MaxIdleDestroyCount()))
+}
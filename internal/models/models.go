@@ -1,39 +1,163 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// Статусы расчёта начислений по заказу
+const (
+	OrderStatusNew        = "NEW"        // заказ загружен, но не попал в обработку
+	OrderStatusProcessing = "PROCESSING" // вознаграждение за заказ рассчитывается
+	OrderStatusInvalid    = "INVALID"    // система расчёта вознаграждений отказала в расчёте
+	OrderStatusProcessed  = "PROCESSED"  // данные по заказу проверены и информация о расчёте успешно получена
+
+	AccrualStatusRegistered = "REGISTERED" // заказ зарегистрирован в системе расчёта, но вознаграждение не рассчитано
+)
+
+// Роли пользователей
+const (
+	RoleUser    = "user"    // покупатель
+	RoleSupport = "support" // сотрудник поддержки: просмотр данных пользователей, разблокировка, возврат заказов в очередь
+	RoleAdmin   = "admin"   // администратор: всё, что доступно поддержке, корректировка баланса и назначение ролей
+)
+
+// ValidRole сообщает, что role — одна из ролей пользователей
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleSupport || role == RoleAdmin
+}
+
 type User struct {
 	Login    string `json:"login"`    // логин
 	Password string `json:"password"` // параметр, принимающий значение gauge или counter
 }
 
 type StatusOrders struct {
-	Number     string    `json:"number" db:"number"`                       // номер заказа
-	Status     string    `json:"status" db:"status"`                       // статус расчёта начисления
-	Accrual    float64   `json:"accrual,omitempty" db:"accrual,omitempty"` // рассчитанные баллы к начислению, при отсутствии начисления — поле отсутствует в ответе.
-	UploadedAt time.Time `json:"uploaded_at" db:"uploaded_at"`             // временЯ загрузки, формат даты — RFC3339.
+	Number     string    `json:"number" db:"number"`                                            // номер заказа
+	Status     string    `json:"status" db:"status"`                                            // статус расчёта начисления
+	Accrual    Money     `json:"accrual,omitempty" db:"accrual,omitempty" swaggertype:"number"` // рассчитанные баллы к начислению, при отсутствии начисления — поле отсутствует в ответе.
+	UploadedAt time.Time `json:"uploaded_at" db:"uploaded_at"`                                  // временЯ загрузки, формат даты — RFC3339.
 }
 
 type Balance struct {
-	Current   float64 `json:"current"`   // текущий баланс пользователя
-	Withdrawn float64 `json:"withdrawn"` // сумма использованных за весь период баллов
+	Current   Money `json:"current" swaggertype:"number"`   // текущий баланс пользователя
+	Withdrawn Money `json:"withdrawn" swaggertype:"number"` // сумма использованных за весь период баллов
 }
 
 type BalanceWithdrawn struct {
-	Order string  `json:"order"` // номер заказа
-	Sum   float64 `json:"sum"`   // сумма списания
+	Order string `json:"order"`                    // номер заказа
+	Sum   Money  `json:"sum" swaggertype:"number"` // сумма списания
 }
 
 type BalanceWithdrawals struct {
 	Order       string    `json:"order" db:"order"`                         // номер заказа
-	Sum         float64   `json:"sum" db:"sum"`                             // сумма вывода средств
+	Sum         Money     `json:"sum" db:"sum" swaggertype:"number"`        // сумма вывода средств
 	ProcessedAt time.Time `json:"processed_at,omitempty" db:"processed_at"` // временя загрузки, формат даты — RFC3339.
 }
 
 type StatusOrdersAccrual struct {
-	Order   string  `json:"order"`             // номер заказа
-	Status  string  `json:"status"`            // статус расчёта начисления
-	Accrual float64 `json:"accrual,omitempty"` // рассчитанные баллы к начислению, при отсутствии начисления — поле отсутствует в ответе.
+	Order   string `json:"order"`                                  // номер заказа
+	Status  string `json:"status"`                                 // статус расчёта начисления
+	Accrual Money  `json:"accrual,omitempty" swaggertype:"number"` // рассчитанные баллы к начислению, при отсутствии начисления — поле отсутствует в ответе.
+}
+
+type LedgerEntry struct {
+	TransactionID int64     `json:"transaction_id"`              // идентификатор транзакции книги учёта
+	Kind          string    `json:"kind"`                        // вид транзакции
+	Account       string    `json:"account"`                     // счёт проводки
+	Amount        Money     `json:"amount" swaggertype:"number"` // сумма проводки, отрицательная при списании со счёта
+	Order         string    `json:"order,omitempty"`             // номер заказа
+	Reason        string    `json:"reason,omitempty"`            // причина корректировки
+	CreatedAt     time.Time `json:"created_at"`                  // время проводки, формат даты — RFC3339.
+}
+
+// AccrualJob арендованный воркером заказ, по которому нужно опросить систему расчёта
+type AccrualJob struct {
+	Number   int64     // номер заказа
+	Attempts int       // число неудачных опросов
+	QueuedAt time.Time // время постановки в очередь: загрузки заказа или его возврата из dead-letter
+}
+
+type DeadLetterOrder struct {
+	Number         string    `json:"number"`           // номер заказа
+	Login          string    `json:"login"`            // владелец заказа
+	Status         string    `json:"status"`           // статус заказа на момент остановки опроса
+	Attempts       int       `json:"attempts"`         // число неудачных опросов
+	LastError      string    `json:"last_error"`       // причина последней неудачи
+	UploadedAt     time.Time `json:"uploaded_at"`      // время загрузки заказа, формат даты — RFC3339.
+	DeadLetteredAt time.Time `json:"dead_lettered_at"` // время остановки опроса, формат даты — RFC3339.
+}
+
+type Tokens struct {
+	AccessToken  string `json:"access_token"`  // токен доступа, он же передаётся в заголовке Authorization
+	RefreshToken string `json:"refresh_token"` // токен обновления для POST /api/user/token/refresh
+	TokenType    string `json:"token_type"`    // тип токена доступа, всегда Bearer
+	ExpiresIn    int64  `json:"expires_in"`    // срок действия токена доступа в секундах
+}
+
+type RefreshToken struct {
+	RefreshToken string `json:"refresh_token"` // токен обновления
+}
+
+type PasswordChange struct {
+	OldPassword string `json:"old_password"` // текущий пароль
+	NewPassword string `json:"new_password"` // новый пароль
+}
+
+type PasswordResetRequest struct {
+	Login string `json:"login"` // логин пользователя, забывшего пароль
+}
+
+type PasswordResetConfirm struct {
+	Token    string `json:"token"`    // токен сброса пароля из уведомления
+	Password string `json:"password"` // новый пароль
+}
+
+type UserInfo struct {
+	Login        string     `json:"login"`                  // логин
+	Role         string     `json:"role"`                   // роль
+	RegisteredAt time.Time  `json:"registered_at"`          // время регистрации, формат даты — RFC3339.
+	LockedUntil  *time.Time `json:"locked_until,omitempty"` // срок блокировки входа, если пользователь заблокирован
+}
+
+type RoleChange struct {
+	Role string `json:"role"` // новая роль: user, support или admin
+}
+
+type BalanceAdjustment struct {
+	Amount Money  `json:"amount" swaggertype:"number"` // сумма корректировки, отрицательная при списании
+	Reason string `json:"reason"`                      // причина корректировки
+}
+
+type AuditEvent struct {
+	ID         int64           `json:"id"`                   // номер события, возрастает со временем
+	Actor      string          `json:"actor"`                // логин пользователя или сотрудника, accrual для системы расчёта
+	Action     string          `json:"action"`               // действие, например withdrawal.create
+	EntityType string          `json:"entity_type"`          // вид сущности: user, order, withdrawal, access_token
+	EntityID   string          `json:"entity_id"`            // логин пользователя, номер заказа или jti токена
+	Before     json.RawMessage `json:"before,omitempty"`     // состояние сущности до изменения
+	After      json.RawMessage `json:"after,omitempty"`      // состояние сущности после изменения
+	RequestID  string          `json:"request_id,omitempty"` // идентификатор HTTP-запроса
+	CreatedAt  time.Time       `json:"created_at"`           // время события, формат даты — RFC3339.
+}
+
+// Состояния компонентов в ответе /readyz
+const (
+	ComponentUp       = "up"       // компонент работает
+	ComponentDegraded = "degraded" // компонент неисправен, но реплика может обслуживать запросы
+	ComponentDown     = "down"     // реплика не может обслуживать запросы
+)
+
+// Readiness готовность реплики принимать запросы
+type Readiness struct {
+	Ready      bool                       `json:"ready"`
+	Components map[string]ComponentStatus `json:"components"` // database, migrations, accrual, shutdown
+}
+
+type ComponentStatus struct {
+	Status   string `json:"status"`             // up, degraded или down
+	Version  *int64 `json:"version,omitempty"`  // применённая версия схемы базы данных
+	Expected *int64 `json:"expected,omitempty"` // версия схемы, которую ожидает приложение
+	Circuit  string `json:"circuit,omitempty"`  // состояние автоматического выключателя системы расчёта
+	Error    string `json:"error,omitempty"`    // причина неисправности
 }
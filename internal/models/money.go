@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Money сумма баллов в сотых долях балла (копейках).
+// В JSON и в базе данных передаётся как десятичное число с двумя знаками после запятой.
+type Money int64
+
+const moneyScale = 100
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// ParseMoney разбирает десятичную запись суммы без потери точности,
+// дробная часть округляется до копеек по правилу «половина от нуля».
+func ParseMoney(s string) (Money, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	r.Mul(r, big.NewRat(moneyScale, 1))
+
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() != 0 {
+		// сравниваем 2*|остаток| со знаменателем, чтобы округлить половину от нуля
+		twice := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2))
+		if twice.Cmp(den) >= 0 {
+			if num.Sign() < 0 {
+				quo.Sub(quo, big.NewInt(1))
+			} else {
+				quo.Add(quo, big.NewInt(1))
+			}
+		}
+	}
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	return Money(quo.Int64()), nil
+}
+
+// MoneyFromKopecks создаёт сумму из целого числа копеек
+func MoneyFromKopecks(kopecks int64) Money {
+	return Money(kopecks)
+}
+
+// Kopecks возвращает сумму в копейках
+func (m Money) Kopecks() int64 {
+	return int64(m)
+}
+
+// Float64 возвращает приближённое значение, использовать только для метрик и логов
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// String возвращает десятичную запись без лишних нулей: 500, 729.98, 0.5
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	whole, frac := v/moneyScale, v%moneyScale
+	switch {
+	case frac == 0:
+		return sign + strconv.FormatInt(whole, 10)
+	case frac%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, whole, frac/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, whole, frac)
+	}
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Scan читает значение столбца numeric
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case string:
+		parsed, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case []byte:
+		return m.Scan(string(v))
+	case int64:
+		*m = Money(v * moneyScale)
+		return nil
+	case float64:
+		return m.Scan(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("%w: unsupported type %T", ErrInvalidMoney, src)
+	}
+}
+
+// Value передаёт сумму в базу данных строкой, чтобы не терять точность
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
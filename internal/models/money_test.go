@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Money
+	}{
+		{name: "целое число", input: "500", want: 50000},
+		{name: "две цифры после запятой", input: "729.98", want: 72998},
+		{name: "одна цифра после запятой", input: "0.1", want: 10},
+		{name: "отрицательная сумма", input: "-12.34", want: -1234},
+		{name: "экспонента", input: "1e2", want: 10000},
+		{name: "округление вверх", input: "0.125", want: 13},
+		{name: "округление вниз", input: "0.124", want: 12},
+		{name: "округление отрицательной суммы", input: "-0.125", want: -13},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseMoney(test.input)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	_, err := ParseMoney("abc")
+	assert.ErrorIs(t, err, ErrInvalidMoney)
+}
+
+func TestMoneyJSON(t *testing.T) {
+	balance := Balance{Current: 50050, Withdrawn: 42}
+	data, err := json.Marshal(balance)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"current":500.5,"withdrawn":0.42}`, string(data))
+
+	var withdrawn BalanceWithdrawn
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":751.1}`), &withdrawn))
+	assert.Equal(t, Money(75110), withdrawn.Sum)
+
+	// сумма 0.1 + 0.2 во float64 дала бы 0.30000000000000004
+	var total Money
+	for _, s := range []string{"0.1", "0.2"} {
+		m, err := ParseMoney(s)
+		require.NoError(t, err)
+		total += m
+	}
+	assert.Equal(t, "0.3", total.String())
+}
+
+func TestMoneyScan(t *testing.T) {
+	var m Money
+	require.NoError(t, m.Scan("10.50"))
+	assert.Equal(t, Money(1050), m)
+	require.NoError(t, m.Scan(nil))
+	assert.Equal(t, Money(0), m)
+
+	v, err := Money(1050).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "10.5", v)
+}
@@ -0,0 +1,56 @@
+// Package notify доставка сообщений пользователям.
+//
+// Сервис не хранит адреса пользователей, поэтому сообщение адресуется логину, а способ доставки
+// определяет реализация Notifier. Для локального запуска есть FileNotifier. Токены не пишутся в журнал сервиса.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// PasswordReset сообщение с токеном сброса пароля
+type PasswordReset struct {
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Notifier доставляет сообщения пользователям
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, message PasswordReset) error
+}
+
+// FileNotifier дописывает сообщения в файл по одному JSON на строку
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) SendPasswordReset(ctx context.Context, message PasswordReset) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
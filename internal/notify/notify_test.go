@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resets.jsonl")
+	notifier := NewFileNotifier(path)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	for _, login := range []string{"alice", "bob"} {
+		err := notifier.SendPasswordReset(context.Background(), PasswordReset{Login: login, Token: "token-" + login, ExpiresAt: expiresAt})
+		require.NoError(t, err)
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var messages []PasswordReset
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var message PasswordReset
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &message))
+		messages = append(messages, message)
+	}
+	require.Len(t, messages, 2)
+	assert.Equal(t, "token-bob", messages[1].Token)
+	assert.True(t, expiresAt.Equal(messages[0].ExpiresAt))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "токены читает только владелец")
+}
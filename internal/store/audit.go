@@ -0,0 +1,140 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"gophermart/internal/models"
+	"time"
+)
+
+// Действия журнала аудита
+const (
+	AuditUserRegister       = "user.register"
+	AuditUserLogin          = "user.login"
+	AuditUserLoginFailed    = "user.login_failed"
+	AuditUserUnlock         = "user.unlock"
+	AuditUserPasswordChange = "user.password_change"
+	AuditUserPasswordReset  = "user.password_reset"
+	AuditUserResetRequest   = "user.password_reset_request"
+	AuditUserRoleChange     = "user.role_change"
+	AuditOrderUpload        = "order.upload"
+	AuditOrderStatus        = "order.status_change"
+	AuditOrderDeadLetter    = "order.dead_letter"
+	AuditOrderRequeue       = "order.requeue"
+	AuditWithdrawal         = "withdrawal.create"
+	AuditWithdrawalRefund   = "withdrawal.refund"
+	AuditBalanceAdjust      = "balance.adjust"
+	AuditRefreshTokenReuse  = "token.refresh_reuse"
+	AuditRefreshTokenRevoke = "token.refresh_revoke"
+	AuditAccessTokenRevoke  = "token.access_revoke"
+)
+
+// Виды сущностей журнала аудита
+const (
+	AuditEntityUser        = "user"
+	AuditEntityOrder       = "order"
+	AuditEntityWithdrawal  = "withdrawal"
+	AuditEntityAccessToken = "access_token"
+)
+
+// AuditUserState состояние пользователя в журнале аудита, пароли и их хеши не записываются
+type AuditUserState struct {
+	Role         string     `json:"role,omitempty"`
+	FailedLogins int        `json:"failed_logins,omitempty"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+}
+
+// AuditOrderState состояние заказа в журнале аудита
+type AuditOrderState struct {
+	Status    string        `json:"status"`
+	Accrual   *models.Money `json:"accrual,omitempty"`
+	Attempts  int           `json:"attempts,omitempty"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+// AuditBalanceState баланс пользователя в журнале аудита, Sum и Reason — сумма и основание изменения
+type AuditBalanceState struct {
+	Current models.Money  `json:"current"`
+	Sum     *models.Money `json:"sum,omitempty"`
+	Reason  string        `json:"reason,omitempty"`
+}
+
+// Инициаторы событий, которые вызваны не пользователем: AuditActorAccrual — ответы и уведомления системы расчёта,
+// AuditActorSystem — всё, у чего нет ни пользователя, ни инициатора в контексте
+const (
+	AuditActorAccrual = "accrual"
+	AuditActorSystem  = "system"
+)
+
+type auditContextKey int
+
+const (
+	actorKey auditContextKey = iota
+	requestIDKey
+)
+
+// WithActor возвращает контекст, изменения в котором записываются в журнал аудита от имени actor:
+// логина пользователя, сотрудника или компонента сервиса
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// WithRequestID возвращает контекст, изменения в котором связываются в журнале аудита с запросом requestID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// NewAuditEvent собирает событие журнала аудита. Инициатор и идентификатор запроса берутся из ctx,
+// а если инициатора в ctx нет, им считается subject — пользователь, действующий от своего имени.
+// before и after — состояние сущности до и после изменения, nil если его нет.
+func NewAuditEvent(ctx context.Context, subject string, action string, entityType string, entityID string, before interface{}, after interface{}) models.AuditEvent {
+	actor, _ := ctx.Value(actorKey).(string)
+	if actor == "" {
+		actor = subject
+	}
+	if actor == "" {
+		actor = AuditActorSystem
+	}
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return models.AuditEvent{
+		Actor:      actor,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     auditState(before),
+		After:      auditState(after),
+		RequestID:  requestID,
+	}
+}
+
+// auditState сериализует состояние сущности. Состояния собираются из строк, чисел и models.Money,
+// поэтому ошибка сериализации означает ошибку в коде, и состояние не записывается.
+func auditState(state interface{}) json.RawMessage {
+	if state == nil {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// AuditFilter условия выборки журнала аудита, пустые поля не ограничивают выборку
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	// BeforeID возвращает события с номером меньше BeforeID, для постраничного просмотра; 0 — с последнего
+	BeforeID int64
+	Limit    int
+}
+
+// Match сообщает, что событие подходит под фильтр, кроме ограничений BeforeID и Limit
+func (f AuditFilter) Match(event models.AuditEvent) bool {
+	return (f.Actor == "" || event.Actor == f.Actor) &&
+		(f.Action == "" || event.Action == f.Action) &&
+		(f.EntityType == "" || event.EntityType == f.EntityType) &&
+		(f.EntityID == "" || event.EntityID == f.EntityID)
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuditEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		subject string
+		actor   string
+	}{
+		{name: "пользователь от своего имени", ctx: context.Background(), subject: "user", actor: "user"},
+		{name: "сотрудник над пользователем", ctx: WithActor(context.Background(), "admin"), subject: "user", actor: "admin"},
+		{name: "система расчёта", ctx: WithActor(context.Background(), AuditActorAccrual), actor: AuditActorAccrual},
+		{name: "инициатор неизвестен", ctx: context.Background(), actor: AuditActorSystem},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := NewAuditEvent(WithRequestID(test.ctx, "request"), test.subject, AuditUserRoleChange, AuditEntityUser, "user",
+				AuditUserState{Role: "user"}, nil)
+			assert.Equal(t, test.actor, event.Actor)
+			assert.Equal(t, "request", event.RequestID)
+			assert.JSONEq(t, `{"role":"user"}`, string(event.Before))
+			assert.Nil(t, event.After)
+		})
+	}
+}
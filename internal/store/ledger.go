@@ -0,0 +1,137 @@
+package store
+
+import (
+	"errors"
+	"gophermart/internal/models"
+)
+
+// Счета книги учёта баллов. Баланс любого счёта — сумма его проводок.
+const (
+	AccountUserPoints  = "user_points" // баллы пользователя
+	AccountAccrual     = "accrual"     // источник начислений системы расчёта
+	AccountWithdrawals = "withdrawals" // баллы, списанные в счёт оплаты заказов
+	AccountAdjustments = "adjustments" // ручные корректировки
+)
+
+// Виды транзакций книги учёта
+const (
+	LedgerKindAccrual    = "ACCRUAL"
+	LedgerKindWithdrawal = "WITHDRAWAL"
+	LedgerKindRefund     = "REFUND"
+	LedgerKindAdjustment = "ADJUSTMENT"
+)
+
+var ErrLedgerUnbalanced = errors.New("ledger transaction is not balanced")
+var ErrLedgerZeroAmount = errors.New("ledger entry amount must not be zero")
+var ErrLedgerWrongSign = errors.New("ledger entry amount has wrong sign for transaction kind")
+var ErrAlreadyRefunded = errors.New("withdrawal already refunded")
+
+// LedgerPosting транзакция книги учёта: набор проводок, сумма которых равна нулю.
+// Положительная сумма увеличивает баланс счёта, отрицательная уменьшает.
+type LedgerPosting struct {
+	Kind    string
+	Order   int64 // номер заказа, 0 если транзакция не связана с заказом
+	Reason  string
+	Entries []LedgerPostingEntry
+}
+
+type LedgerPostingEntry struct {
+	Account string
+	Amount  models.Money
+}
+
+// NewAccrualPosting начисление баллов за заказ
+func NewAccrualPosting(order int64, amount models.Money) *LedgerPosting {
+	return &LedgerPosting{
+		Kind:  LedgerKindAccrual,
+		Order: order,
+		Entries: []LedgerPostingEntry{
+			{Account: AccountUserPoints, Amount: amount},
+			{Account: AccountAccrual, Amount: -amount},
+		},
+	}
+}
+
+// NewWithdrawalPosting списание баллов в счёт оплаты заказа
+func NewWithdrawalPosting(order int64, amount models.Money) *LedgerPosting {
+	return &LedgerPosting{
+		Kind:  LedgerKindWithdrawal,
+		Order: order,
+		Entries: []LedgerPostingEntry{
+			{Account: AccountUserPoints, Amount: -amount},
+			{Account: AccountWithdrawals, Amount: amount},
+		},
+	}
+}
+
+// NewRefundPosting возврат ранее списанных баллов
+func NewRefundPosting(order int64, amount models.Money) *LedgerPosting {
+	return &LedgerPosting{
+		Kind:  LedgerKindRefund,
+		Order: order,
+		Entries: []LedgerPostingEntry{
+			{Account: AccountUserPoints, Amount: amount},
+			{Account: AccountWithdrawals, Amount: -amount},
+		},
+	}
+}
+
+// NewAdjustmentPosting ручная корректировка баланса, amount может быть отрицательным
+func NewAdjustmentPosting(amount models.Money, reason string) *LedgerPosting {
+	return &LedgerPosting{
+		Kind:   LedgerKindAdjustment,
+		Reason: reason,
+		Entries: []LedgerPostingEntry{
+			{Account: AccountUserPoints, Amount: amount},
+			{Account: AccountAdjustments, Amount: -amount},
+		},
+	}
+}
+
+// Validate проверяет, что транзакция сбалансирована, не содержит пустых проводок и меняет баланс
+// пользователя в сторону, положенную её виду: списание только уменьшает его, начисление и возврат — увеличивают
+func (p *LedgerPosting) Validate() error {
+	if len(p.Entries) < 2 {
+		return ErrLedgerUnbalanced
+	}
+	var total models.Money
+	for _, entry := range p.Entries {
+		if entry.Amount == 0 {
+			return ErrLedgerZeroAmount
+		}
+		if entry.Account == AccountUserPoints && !p.validSign(entry.Amount) {
+			return ErrLedgerWrongSign
+		}
+		total += entry.Amount
+	}
+	if total != 0 {
+		return ErrLedgerUnbalanced
+	}
+	return nil
+}
+
+// validSign проверяет знак проводки по счёту баллов пользователя, у корректировки он любой
+func (p *LedgerPosting) validSign(amount models.Money) bool {
+	switch p.Kind {
+	case LedgerKindWithdrawal:
+		return amount < 0
+	case LedgerKindAccrual, LedgerKindRefund:
+		return amount > 0
+	default:
+		return true
+	}
+}
+
+// BalanceFromLedger восстанавливает баланс пользователя по истории его проводок
+func BalanceFromLedger(entries []models.LedgerEntry) models.Balance {
+	var balance models.Balance
+	for _, entry := range entries {
+		switch entry.Account {
+		case AccountUserPoints:
+			balance.Current += entry.Amount
+		case AccountWithdrawals:
+			balance.Withdrawn += entry.Amount
+		}
+	}
+	return balance
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"testing"
+
+	"gophermart/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerPostingValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		posting *LedgerPosting
+		want    error
+	}{
+		{name: "начисление", posting: NewAccrualPosting(2377225624, 50000)},
+		{name: "списание", posting: NewWithdrawalPosting(2377225624, 1050)},
+		{name: "возврат", posting: NewRefundPosting(2377225624, 1050)},
+		{name: "отрицательная корректировка", posting: NewAdjustmentPosting(-100, "ошибка начисления")},
+		{name: "нулевая сумма", posting: NewAccrualPosting(2377225624, 0), want: ErrLedgerZeroAmount},
+		{name: "отрицательное списание", posting: NewWithdrawalPosting(2377225624, -1050), want: ErrLedgerWrongSign},
+		{name: "отрицательное начисление", posting: NewAccrualPosting(2377225624, -50000), want: ErrLedgerWrongSign},
+		{name: "отрицательный возврат", posting: NewRefundPosting(2377225624, -1050), want: ErrLedgerWrongSign},
+		{
+			name: "несбалансированная транзакция",
+			posting: &LedgerPosting{Kind: LedgerKindAdjustment, Entries: []LedgerPostingEntry{
+				{Account: AccountUserPoints, Amount: 100},
+				{Account: AccountAdjustments, Amount: -99},
+			}},
+			want: ErrLedgerUnbalanced,
+		},
+		{
+			name: "одна проводка",
+			posting: &LedgerPosting{Kind: LedgerKindAdjustment, Entries: []LedgerPostingEntry{
+				{Account: AccountUserPoints, Amount: 100},
+			}},
+			want: ErrLedgerUnbalanced,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.ErrorIs(t, test.posting.Validate(), test.want)
+		})
+	}
+}
+
+func TestBalanceFromLedger(t *testing.T) {
+	var entries []models.LedgerEntry
+	for _, posting := range []*LedgerPosting{
+		NewAccrualPosting(1, 50000),
+		NewWithdrawalPosting(2, 10000),
+		NewWithdrawalPosting(3, 2550),
+		NewRefundPosting(3, 2550),
+		NewAdjustmentPosting(-1000, "корректировка"),
+	} {
+		for _, entry := range posting.Entries {
+			entries = append(entries, models.LedgerEntry{Kind: posting.Kind, Account: entry.Account, Amount: entry.Amount})
+		}
+	}
+
+	balance := BalanceFromLedger(entries)
+	assert.Equal(t, models.Money(39000), balance.Current)
+	assert.Equal(t, models.Money(10000), balance.Withdrawn)
+}
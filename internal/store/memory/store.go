@@ -0,0 +1,996 @@
+// Package memory хранилище в памяти процесса с той же семантикой, что и PostgreSQL.
+// Подходит для запуска сервиса без базы данных и для тестов.
+package memory
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gophermart/internal/metrics"
+	"gophermart/internal/models"
+	"gophermart/internal/store"
+)
+
+type user struct {
+	id             int64
+	login          string
+	hashedPassword []byte
+	registeredAt   time.Time
+	role           string
+	// failedLogins неудачные входы подряд, lockedUntil срок блокировки после maxFailures неудачных входов
+	failedLogins int
+	lockedUntil  time.Time
+}
+
+type order struct {
+	number     int64
+	userID     int64
+	status     string
+	accrual    models.Money
+	uploadedAt time.Time
+	seq        int64
+	// lockedBy и lockedUntil аренда заказа воркером
+	lockedBy    string
+	lockedUntil time.Time
+	// attempts, nextAttemptAt и lastError учёт неудачных опросов системы расчёта
+	attempts       int
+	nextAttemptAt  time.Time
+	lastError      string
+	deadLetteredAt time.Time
+	requeuedAt     time.Time
+}
+
+// pending сообщает, что заказ ждёт опроса системы расчёта к моменту now
+func (o *order) pending(now time.Time) bool {
+	if o.status != models.OrderStatusNew && o.status != models.OrderStatusProcessing {
+		return false
+	}
+	return o.deadLetteredAt.IsZero() && !o.nextAttemptAt.After(now)
+}
+
+type withdrawal struct {
+	number      int64
+	userID      int64
+	sum         models.Money
+	processedAt time.Time
+	refunded    bool
+	seq         int64
+}
+
+type refreshToken struct {
+	login     string
+	family    string
+	expiresAt time.Time
+	used      bool
+	revoked   bool
+}
+
+type passwordReset struct {
+	login     string
+	expiresAt time.Time
+	used      bool
+}
+
+type ledgerEntry struct {
+	userID int64
+	entry  models.LedgerEntry
+}
+
+// Storage потокобезопасная реализация store.StorageInterface.
+// Все операции выполняются под одной блокировкой, что соответствует сериализуемым транзакциям.
+type Storage struct {
+	mu          sync.Mutex
+	users       map[string]*user
+	orders      map[int64]*order
+	withdrawals map[int64]*withdrawal
+	ledger      []ledgerEntry
+	seq         int64
+	// callbacks подписи принятых уведомлений системы расчёта и сроки их хранения
+	callbacks map[string]time.Time
+	// refreshTokens токены обновления по хешу, revokedTokens отозванные токены доступа и сроки их действия
+	refreshTokens map[string]*refreshToken
+	revokedTokens map[string]time.Time
+	// loginFailures времена неудачных попыток входа по ключу
+	loginFailures map[string][]time.Time
+	// passwordResets токены сброса пароля по хешу
+	passwordResets map[string]*passwordReset
+	// auditEvents журнал аудита изменений
+	auditEvents []models.AuditEvent
+}
+
+func NewStorage() *Storage {
+	return &Storage{
+		users:       make(map[string]*user),
+		orders:      make(map[int64]*order),
+		withdrawals: make(map[int64]*withdrawal),
+		callbacks:   make(map[string]time.Time),
+
+		refreshTokens: make(map[string]*refreshToken),
+		revokedTokens: make(map[string]time.Time),
+		loginFailures: make(map[string][]time.Time),
+
+		passwordResets: make(map[string]*passwordReset),
+	}
+}
+
+func (s *Storage) nextSeq() int64 {
+	s.seq++
+	return s.seq
+}
+
+// audit добавляет событие в журнал аудита, вызывается под блокировкой вместе с изменением
+func (s *Storage) audit(event models.AuditEvent) {
+	event.ID = s.nextSeq()
+	event.CreatedAt = time.Now()
+	s.auditEvents = append(s.auditEvents, event)
+}
+
+func (s *Storage) Ping(ctx context.Context) bool {
+	return true
+}
+
+func (s *Storage) UserRegister(ctx context.Context, login string, password string) error {
+	hashedPassword, err := store.HashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; ok {
+		return store.ErrLoginDuplicate
+	}
+	s.users[login] = &user{
+		id:             s.nextSeq(),
+		login:          login,
+		hashedPassword: hashedPassword,
+		registeredAt:   time.Now(),
+		role:           models.RoleUser,
+	}
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditUserRegister, store.AuditEntityUser, login,
+		nil, store.AuditUserState{Role: models.RoleUser}))
+	return nil
+}
+
+func (s *Storage) UserLogin(ctx context.Context, login string, password string) error {
+	// хеш копируется под блокировкой: смена и сброс пароля меняют его у того же пользователя
+	s.mu.Lock()
+	var hashedPassword []byte
+	u, ok := s.users[login]
+	if ok {
+		hashedPassword = u.hashedPassword
+	}
+	s.mu.Unlock()
+	if !ok {
+		return store.ErrAuthentication
+	}
+
+	err := store.ComparePassword(ctx, hashedPassword, password)
+	if err != nil {
+		return store.ErrAuthentication
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditUserLogin, store.AuditEntityUser, login, nil, nil))
+	return nil
+}
+
+func (s *Storage) ReserveLoginAttempt(ctx context.Context, key string, at time.Time, window time.Duration, limit int) (bool, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := at.Add(-window)
+	for attemptKey, attempts := range s.loginFailures {
+		kept := attempts[:0]
+		for _, attempt := range attempts {
+			if !attempt.Before(expired) {
+				kept = append(kept, attempt)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.loginFailures, attemptKey)
+		} else {
+			s.loginFailures[attemptKey] = kept
+		}
+	}
+
+	count := 0
+	var oldest time.Time
+	for _, attempt := range s.loginFailures[key] {
+		if !attempt.After(expired) {
+			continue
+		}
+		count++
+		if oldest.IsZero() || attempt.Before(oldest) {
+			oldest = attempt
+		}
+	}
+	if count >= limit && count > 0 {
+		return false, oldest, nil
+	}
+	s.loginFailures[key] = append(s.loginFailures[key], at)
+	return true, time.Time{}, nil
+}
+
+func (s *Storage) ReleaseLoginAttempt(ctx context.Context, key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.loginFailures[key]
+	for i, attempt := range attempts {
+		if attempt.Equal(at) {
+			s.loginFailures[key] = append(attempts[:i], attempts[i+1:]...)
+			break
+		}
+	}
+	if len(s.loginFailures[key]) == 0 {
+		delete(s.loginFailures, key)
+	}
+	return nil
+}
+
+func (s *Storage) RecordFailedLogin(ctx context.Context, login string, maxFailures int, lockout time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return time.Time{}, store.ErrUserNotFound
+	}
+	before := store.AuditUserState{FailedLogins: u.failedLogins}
+	u.failedLogins++
+	if u.failedLogins < maxFailures {
+		s.audit(store.NewAuditEvent(ctx, login, store.AuditUserLoginFailed, store.AuditEntityUser, login,
+			before, store.AuditUserState{FailedLogins: u.failedLogins}))
+		return time.Time{}, nil
+	}
+	u.failedLogins = 0
+	u.lockedUntil = time.Now().Add(lockout)
+	lockedUntil := u.lockedUntil
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditUserLoginFailed, store.AuditEntityUser, login,
+		before, store.AuditUserState{LockedUntil: &lockedUntil}))
+	return u.lockedUntil, nil
+}
+
+func (s *Storage) ResetFailedLogins(ctx context.Context, login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[login]; ok {
+		u.failedLogins = 0
+	}
+	return nil
+}
+
+func (s *Storage) GetUserLockedUntil(ctx context.Context, login string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return time.Time{}, store.ErrUserNotFound
+	}
+	return u.lockedUntil, nil
+}
+
+func (s *Storage) UnlockUser(ctx context.Context, login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return store.ErrUserNotFound
+	}
+	before := store.AuditUserState{FailedLogins: u.failedLogins}
+	if !u.lockedUntil.IsZero() {
+		lockedUntil := u.lockedUntil
+		before.LockedUntil = &lockedUntil
+	}
+	u.failedLogins = 0
+	u.lockedUntil = time.Time{}
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditUserUnlock, store.AuditEntityUser, login,
+		before, store.AuditUserState{}))
+	return nil
+}
+
+func (s *Storage) ChangePassword(ctx context.Context, login string, password string) error {
+	hashedPassword, err := store.HashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return store.ErrUserNotFound
+	}
+	s.setPassword(u, hashedPassword)
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditUserPasswordChange, store.AuditEntityUser, login, nil, nil))
+	return nil
+}
+
+// setPassword заменяет пароль пользователя и отзывает его токены обновления. Вызывается под блокировкой.
+func (s *Storage) setPassword(u *user, hashedPassword []byte) {
+	u.hashedPassword = hashedPassword
+	for _, token := range s.refreshTokens {
+		if token.login == u.login {
+			token.revoked = true
+		}
+	}
+}
+
+func (s *Storage) CreatePasswordReset(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; !ok {
+		return store.ErrUserNotFound
+	}
+	now := time.Now()
+	for hash, reset := range s.passwordResets {
+		if reset.login == login || reset.expiresAt.Before(now) {
+			delete(s.passwordResets, hash)
+		}
+	}
+	s.passwordResets[tokenHash] = &passwordReset{login: login, expiresAt: expiresAt}
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditUserResetRequest, store.AuditEntityUser, login, nil, nil))
+	return nil
+}
+
+func (s *Storage) ResetPassword(ctx context.Context, tokenHash string, password string) (string, error) {
+	hashedPassword, err := store.HashPassword(ctx, password)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reset, ok := s.passwordResets[tokenHash]
+	if !ok || reset.used || !reset.expiresAt.After(time.Now()) {
+		return "", store.ErrResetTokenInvalid
+	}
+	u, ok := s.users[reset.login]
+	if !ok {
+		return "", store.ErrResetTokenInvalid
+	}
+	reset.used = true
+	s.setPassword(u, hashedPassword)
+	u.failedLogins = 0
+	u.lockedUntil = time.Time{}
+	s.audit(store.NewAuditEvent(ctx, u.login, store.AuditUserPasswordReset, store.AuditEntityUser, u.login, nil, nil))
+	return u.login, nil
+}
+
+func (s *Storage) GetUser(ctx context.Context, login string) (models.UserInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return models.UserInfo{}, store.ErrUserNotFound
+	}
+	user := models.UserInfo{Login: u.login, Role: u.role, RegisteredAt: u.registeredAt}
+	if u.lockedUntil.After(time.Now()) {
+		lockedUntil := u.lockedUntil
+		user.LockedUntil = &lockedUntil
+	}
+	return user, nil
+}
+
+func (s *Storage) SetUserRole(ctx context.Context, login string, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return store.ErrUserNotFound
+	}
+	before := store.AuditUserState{Role: u.role}
+	u.role = role
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditUserRoleChange, store.AuditEntityUser, login,
+		before, store.AuditUserState{Role: role}))
+	return nil
+}
+
+func (s *Storage) GetAuditEvents(ctx context.Context, filter store.AuditFilter) ([]models.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []models.AuditEvent
+	for i := len(s.auditEvents) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(events) >= filter.Limit {
+			break
+		}
+		event := s.auditEvents[i]
+		if filter.BeforeID > 0 && event.ID >= filter.BeforeID {
+			continue
+		}
+		if filter.Match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *Storage) UploadUserOrders(ctx context.Context, login string, number int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return store.ErrUserNotFound
+	}
+	err := s.uploadOrder(u.id, number)
+	if err != nil {
+		return err
+	}
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditOrderUpload, store.AuditEntityOrder, strconv.FormatInt(number, 10),
+		nil, store.AuditOrderState{Status: models.OrderStatusNew}))
+	return nil
+}
+
+func (s *Storage) uploadOrder(userID int64, number int64) error {
+	if existing, ok := s.orders[number]; ok {
+		if existing.userID != userID {
+			return store.ErrDuplicateOrderOtherUser
+		}
+		return store.ErrDuplicateOrder
+	}
+	s.orders[number] = &order{
+		number:     number,
+		userID:     userID,
+		status:     models.OrderStatusNew,
+		uploadedAt: time.Now(),
+		seq:        s.nextSeq(),
+	}
+	return nil
+}
+
+func (s *Storage) GetUserOrders(ctx context.Context, login string) ([]models.StatusOrders, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ordersUser []models.StatusOrders
+	u, ok := s.users[login]
+	if !ok {
+		return ordersUser, nil
+	}
+
+	var found []*order
+	for _, o := range s.orders {
+		if o.userID == u.id {
+			found = append(found, o)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq > found[j].seq })
+
+	for _, o := range found {
+		ordersUser = append(ordersUser, models.StatusOrders{
+			Number:     strconv.FormatInt(o.number, 10),
+			Status:     o.status,
+			Accrual:    o.accrual,
+			UploadedAt: o.uploadedAt,
+		})
+	}
+	return ordersUser, nil
+}
+
+func (s *Storage) GetUserBalance(ctx context.Context, login string) (models.Balance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return models.Balance{}, nil
+	}
+	return store.BalanceFromLedger(s.userLedger(u.id)), nil
+}
+
+func (s *Storage) UpdateUserBalanceWithdraw(ctx context.Context, login string, orderNumber string, sum models.Money) error {
+	number, err := strconv.ParseInt(orderNumber, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return store.ErrUserNotFound
+	}
+
+	balance := store.BalanceFromLedger(s.userLedger(u.id))
+	if balance.Current < sum {
+		return store.ErrInsufficientFunds
+	}
+
+	posting := store.NewWithdrawalPosting(number, sum)
+	err = posting.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = s.uploadOrder(u.id, number)
+	if err != nil {
+		return err
+	}
+
+	s.withdrawals[number] = &withdrawal{
+		number:      number,
+		userID:      u.id,
+		sum:         sum,
+		processedAt: time.Now(),
+		seq:         s.nextSeq(),
+	}
+	s.post(u.id, posting)
+	metrics.PointsWithdrawn.Add(sum.Float64())
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditWithdrawal, store.AuditEntityWithdrawal, orderNumber,
+		store.AuditBalanceState{Current: balance.Current}, store.AuditBalanceState{Current: balance.Current - sum, Sum: &sum}))
+	return nil
+}
+
+func (s *Storage) GetUserWithdrawals(ctx context.Context, login string) ([]models.BalanceWithdrawals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var withdrawalsUser []models.BalanceWithdrawals
+	u, ok := s.users[login]
+	if !ok {
+		return withdrawalsUser, nil
+	}
+
+	var found []*withdrawal
+	for _, w := range s.withdrawals {
+		if w.userID == u.id {
+			found = append(found, w)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq > found[j].seq })
+
+	for _, w := range found {
+		withdrawalsUser = append(withdrawalsUser, models.BalanceWithdrawals{
+			Order:       strconv.FormatInt(w.number, 10),
+			Sum:         w.sum,
+			ProcessedAt: w.processedAt,
+		})
+	}
+	return withdrawalsUser, nil
+}
+
+func (s *Storage) GetOrdersProcessing(ctx context.Context) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var found []*order
+	for _, o := range s.orders {
+		if o.pending(now) {
+			found = append(found, o)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq > found[j].seq })
+
+	var numbers []int64
+	for _, o := range found {
+		numbers = append(numbers, o.number)
+	}
+	return numbers, nil
+}
+
+func (s *Storage) CountPendingOrders(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, o := range s.orders {
+		if (o.status == models.OrderStatusNew || o.status == models.OrderStatusProcessing) && o.deadLetteredAt.IsZero() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Storage) ClaimOrders(ctx context.Context, worker string, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var found []*order
+	for _, o := range s.orders {
+		if !o.pending(now) {
+			continue
+		}
+		if o.lockedBy != "" && o.lockedUntil.After(now) {
+			continue
+		}
+		found = append(found, o)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq < found[j].seq })
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	var jobs []models.AccrualJob
+	for _, o := range found {
+		o.lockedBy = worker
+		o.lockedUntil = now.Add(lease)
+		queuedAt := o.uploadedAt
+		if !o.requeuedAt.IsZero() {
+			queuedAt = o.requeuedAt
+		}
+		jobs = append(jobs, models.AccrualJob{Number: o.number, Attempts: o.attempts, QueuedAt: queuedAt})
+	}
+	return jobs, nil
+}
+
+func (s *Storage) RenewOrderLease(ctx context.Context, worker string, number int64, lease time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	o, ok := s.orders[number]
+	if !ok || o.lockedBy != worker || o.lockedUntil.Before(now) {
+		return store.ErrLeaseLost
+	}
+	o.lockedUntil = now.Add(lease)
+	return nil
+}
+
+func (s *Storage) ReleaseOrder(ctx context.Context, worker string, number int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if ok && o.lockedBy == worker {
+		o.lockedBy = ""
+		o.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (s *Storage) RetryOrder(ctx context.Context, worker string, number int64, nextAttemptAt time.Time, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if ok && o.lockedBy == worker {
+		o.attempts++
+		o.nextAttemptAt = nextAttemptAt
+		o.lastError = reason
+		o.lockedBy = ""
+		o.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (s *Storage) DeadLetterOrder(ctx context.Context, worker string, number int64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if ok && o.lockedBy == worker {
+		before := store.AuditOrderState{Status: o.status, Attempts: o.attempts, LastError: o.lastError}
+		o.attempts++
+		o.deadLetteredAt = time.Now()
+		o.lastError = reason
+		o.lockedBy = ""
+		o.lockedUntil = time.Time{}
+		s.audit(store.NewAuditEvent(ctx, "", store.AuditOrderDeadLetter, store.AuditEntityOrder, strconv.FormatInt(number, 10),
+			before, store.AuditOrderState{Status: o.status, Attempts: o.attempts, LastError: o.lastError}))
+	}
+	return nil
+}
+
+func (s *Storage) GetDeadLetterOrders(ctx context.Context) ([]models.DeadLetterOrder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logins := make(map[int64]string, len(s.users))
+	for _, u := range s.users {
+		logins[u.id] = u.login
+	}
+
+	var found []*order
+	for _, o := range s.orders {
+		if !o.deadLetteredAt.IsZero() {
+			found = append(found, o)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].deadLetteredAt.Equal(found[j].deadLetteredAt) {
+			return found[i].deadLetteredAt.Before(found[j].deadLetteredAt)
+		}
+		return found[i].number < found[j].number
+	})
+
+	var orders []models.DeadLetterOrder
+	for _, o := range found {
+		orders = append(orders, models.DeadLetterOrder{
+			Number:         strconv.FormatInt(o.number, 10),
+			Login:          logins[o.userID],
+			Status:         o.status,
+			Attempts:       o.attempts,
+			LastError:      o.lastError,
+			UploadedAt:     o.uploadedAt,
+			DeadLetteredAt: o.deadLetteredAt,
+		})
+	}
+	return orders, nil
+}
+
+func (s *Storage) RequeueOrder(ctx context.Context, number int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok || o.deadLetteredAt.IsZero() {
+		return store.ErrOrderNotFound
+	}
+	before := store.AuditOrderState{Status: o.status, Attempts: o.attempts, LastError: o.lastError}
+	o.attempts = 0
+	o.nextAttemptAt = time.Time{}
+	o.lastError = ""
+	o.deadLetteredAt = time.Time{}
+	o.requeuedAt = time.Now()
+	s.audit(store.NewAuditEvent(ctx, "", store.AuditOrderRequeue, store.AuditEntityOrder, strconv.FormatInt(number, 10),
+		before, store.AuditOrderState{Status: o.status}))
+	return nil
+}
+
+func (s *Storage) SaveAccrualCallback(ctx context.Context, signature string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for saved, expires := range s.callbacks {
+		if expires.Before(now) {
+			delete(s.callbacks, saved)
+		}
+	}
+	if _, ok := s.callbacks[signature]; ok {
+		return store.ErrCallbackReplayed
+	}
+	s.callbacks[signature] = expiresAt
+	return nil
+}
+
+func (s *Storage) UpdateStatusOrders(ctx context.Context, statusOrder *models.StatusOrdersAccrual) error {
+	number, err := strconv.ParseInt(statusOrder.Order, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return store.ErrOrderNotFound
+	}
+
+	status, update, credit := store.OrderStatusTransition(o.status, statusOrder.Status)
+	if !update {
+		return nil
+	}
+	before := store.AuditOrderState{Status: o.status}
+
+	if credit && statusOrder.Accrual > 0 {
+		posting := store.NewAccrualPosting(number, statusOrder.Accrual)
+		err = posting.Validate()
+		if err != nil {
+			return err
+		}
+		s.post(o.userID, posting)
+		metrics.PointsCredited.Add(statusOrder.Accrual.Float64())
+	}
+
+	o.status = status
+	after := store.AuditOrderState{Status: status}
+	if credit {
+		o.accrual = statusOrder.Accrual
+		after.Accrual = &statusOrder.Accrual
+	}
+	s.audit(store.NewAuditEvent(ctx, "", store.AuditOrderStatus, store.AuditEntityOrder, statusOrder.Order, before, after))
+	return nil
+}
+
+func (s *Storage) RefundWithdrawal(ctx context.Context, login string, orderNumber string) error {
+	number, err := strconv.ParseInt(orderNumber, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return store.ErrOrderNotFound
+	}
+	w, ok := s.withdrawals[number]
+	if !ok || w.userID != u.id {
+		return store.ErrOrderNotFound
+	}
+	if w.refunded {
+		return store.ErrAlreadyRefunded
+	}
+
+	posting := store.NewRefundPosting(number, w.sum)
+	err = posting.Validate()
+	if err != nil {
+		return err
+	}
+	balance := store.BalanceFromLedger(s.userLedger(u.id))
+	s.post(u.id, posting)
+	w.refunded = true
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditWithdrawalRefund, store.AuditEntityWithdrawal, orderNumber,
+		store.AuditBalanceState{Current: balance.Current}, store.AuditBalanceState{Current: balance.Current + w.sum, Sum: &w.sum}))
+	return nil
+}
+
+func (s *Storage) AdjustUserBalance(ctx context.Context, login string, amount models.Money, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return store.ErrUserNotFound
+	}
+
+	balance := store.BalanceFromLedger(s.userLedger(u.id))
+	if balance.Current+amount < 0 {
+		return store.ErrInsufficientFunds
+	}
+
+	posting := store.NewAdjustmentPosting(amount, reason)
+	err := posting.Validate()
+	if err != nil {
+		return err
+	}
+	s.post(u.id, posting)
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditBalanceAdjust, store.AuditEntityUser, login,
+		store.AuditBalanceState{Current: balance.Current}, store.AuditBalanceState{Current: balance.Current + amount, Sum: &amount, Reason: reason}))
+	return nil
+}
+
+func (s *Storage) GetUserLedger(ctx context.Context, login string) ([]models.LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return nil, nil
+	}
+	return s.userLedger(u.id), nil
+}
+
+// post добавляет проверенную транзакцию в книгу учёта, вызывается под блокировкой
+func (s *Storage) post(userID int64, posting *store.LedgerPosting) {
+	transactionID := s.nextSeq()
+	createdAt := time.Now()
+
+	var order string
+	if posting.Order != 0 {
+		order = strconv.FormatInt(posting.Order, 10)
+	}
+	for _, entry := range posting.Entries {
+		s.ledger = append(s.ledger, ledgerEntry{
+			userID: userID,
+			entry: models.LedgerEntry{
+				TransactionID: transactionID,
+				Kind:          posting.Kind,
+				Account:       entry.Account,
+				Amount:        entry.Amount,
+				Order:         order,
+				Reason:        posting.Reason,
+				CreatedAt:     createdAt,
+			},
+		})
+	}
+}
+
+func (s *Storage) userLedger(userID int64) []models.LedgerEntry {
+	var entries []models.LedgerEntry
+	for _, row := range s.ledger {
+		if row.userID == userID {
+			entries = append(entries, row.entry)
+		}
+	}
+	return entries
+}
+
+func (s *Storage) CreateRefreshToken(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range s.refreshTokens {
+		if token.expiresAt.Before(now) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	if _, ok := s.users[login]; !ok {
+		return store.ErrUserNotFound
+	}
+	s.refreshTokens[tokenHash] = &refreshToken{login: login, family: tokenHash, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *Storage) RotateRefreshToken(ctx context.Context, tokenHash string, newHash string, expiresAt time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[tokenHash]
+	switch {
+	case !ok || token.revoked || token.expiresAt.Before(time.Now()):
+		return "", store.ErrRefreshTokenInvalid
+	case token.used:
+		// заменённый токен предъявлен повторно: он мог быть украден, поэтому отзываем всю цепочку
+		s.revokeFamily(token.family)
+		s.audit(store.NewAuditEvent(ctx, token.login, store.AuditRefreshTokenReuse, store.AuditEntityUser, token.login, nil, nil))
+		return "", store.ErrRefreshTokenReused
+	}
+
+	token.used = true
+	s.refreshTokens[newHash] = &refreshToken{login: token.login, family: token.family, expiresAt: expiresAt}
+	return token.login, nil
+}
+
+func (s *Storage) RevokeRefreshToken(ctx context.Context, login string, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok || token.login != login || !s.revokeFamily(token.family) {
+		return store.ErrRefreshTokenInvalid
+	}
+	s.audit(store.NewAuditEvent(ctx, login, store.AuditRefreshTokenRevoke, store.AuditEntityUser, login, nil, nil))
+	return nil
+}
+
+// revokeFamily отзывает цепочку токенов обновления и сообщает, был ли в ней неотозванный токен.
+// Вызывается под блокировкой.
+func (s *Storage) revokeFamily(family string) bool {
+	revoked := false
+	for _, token := range s.refreshTokens {
+		if token.family == family && !token.revoked {
+			token.revoked = true
+			revoked = true
+		}
+	}
+	return revoked
+}
+
+func (s *Storage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for revoked, expires := range s.revokedTokens {
+		if expires.Before(now) {
+			delete(s.revokedTokens, revoked)
+		}
+	}
+	if _, ok := s.revokedTokens[jti]; !ok {
+		s.revokedTokens[jti] = expiresAt
+		s.audit(store.NewAuditEvent(ctx, "", store.AuditAccessTokenRevoke, store.AuditEntityAccessToken, jti, nil, nil))
+	}
+	return nil
+}
+
+func (s *Storage) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revokedTokens[jti]
+	return ok, nil
+}
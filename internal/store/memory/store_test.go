@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"gophermart/internal/store"
+	"gophermart/internal/store/storetest"
+)
+
+func TestStorage(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.StorageInterface {
+		return NewStorage()
+	})
+}
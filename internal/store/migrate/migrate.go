@@ -0,0 +1,275 @@
+// Package migrate применяет пронумерованные SQL-миграции схемы базы данных.
+//
+// Файлы миграций называются NNNN_описание.up.sql и NNNN_описание.down.sql,
+// применённые версии хранятся в таблице schema_migrations.
+package migrate
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed postgres/*.sql
+var postgres embed.FS
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// Postgres миграции схемы для PostgreSQL
+var Postgres = mustSub(postgres, "postgres")
+
+// SQLite миграции схемы для SQLite, версии совпадают с миграциями PostgreSQL
+var SQLite = mustSub(sqlite, "sqlite")
+
+var ErrSchemaTooNew = errors.New("database schema is newer than the application")
+var ErrNoMigrations = errors.New("no migrations to roll back")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Driver выполняет миграции в конкретной базе данных
+type Driver interface {
+	// Lock берёт блокировку, исключающую одновременное применение миграций несколькими репликами
+	Lock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+	// EnsureVersionTable создаёт таблицу schema_migrations, если её нет
+	EnsureVersionTable(ctx context.Context) error
+	// AppliedVersions возвращает применённые версии и время их применения
+	AppliedVersions(ctx context.Context) (map[int64]time.Time, error)
+	// Apply выполняет скрипт и отмечает версию применённой (up) или откаченной (down) в одной транзакции
+	Apply(ctx context.Context, migration Migration, up bool) error
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	driver     Driver
+	migrations []Migration
+}
+
+func New(driver Driver, migrations []Migration) *Migrator {
+	return &Migrator{driver: driver, migrations: migrations}
+}
+
+// Load читает миграции из fsys и сортирует их по версии
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		base := path.Base(file)
+		var up bool
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			up = true
+			base = strings.TrimSuffix(base, ".up.sql")
+		case strings.HasSuffix(base, ".down.sql"):
+			base = strings.TrimSuffix(base, ".down.sql")
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", file)
+		}
+
+		versionStr, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", file, err)
+		}
+
+		script, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, name)
+		}
+		if up {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d: missing up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest возвращает версию схемы, которую ожидает приложение
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version возвращает текущую версию схемы базы данных
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	applied, err := m.driver.AppliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Up применяет все неприменённые миграции и возвращает их количество
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer m.driver.Unlock(context.WithoutCancel(ctx))
+
+	applied, err := m.driver.AppliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = m.checkKnown(applied)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err = m.driver.Apply(ctx, migration, true)
+		if err != nil {
+			return count, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down откатывает steps последних применённых миграций
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer m.driver.Unlock(context.WithoutCancel(ctx))
+
+	applied, err := m.driver.AppliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = m.checkKnown(applied)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return count, fmt.Errorf("migration %d_%s: missing down script", migration.Version, migration.Name)
+		}
+		err = m.driver.Apply(ctx, migration, false)
+		if err != nil {
+			return count, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, ErrNoMigrations
+	}
+	return count, nil
+}
+
+// Status возвращает состояние каждой известной приложению миграции
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	err := m.driver.EnsureVersionTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.driver.AppliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			appliedAt := appliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, m.checkKnown(applied)
+}
+
+func (m *Migrator) lock(ctx context.Context) error {
+	err := m.driver.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	err = m.driver.EnsureVersionTable(ctx)
+	if err != nil {
+		m.driver.Unlock(context.WithoutCancel(ctx))
+		return err
+	}
+	return nil
+}
+
+// checkKnown не даёт работать со схемой, которую применила более новая версия приложения
+func (m *Migrator) checkKnown(applied map[int64]time.Time) error {
+	known := make(map[int64]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: unknown version %d, latest known %d", ErrSchemaTooNew, version, m.Latest())
+		}
+	}
+	return nil
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
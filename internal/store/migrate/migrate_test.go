@@ -0,0 +1,135 @@
+package migrate
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDriver запоминает выполненные скрипты вместо обращения к базе данных
+type fakeDriver struct {
+	applied map[int64]time.Time
+	scripts []string
+	locked  bool
+}
+
+func (d *fakeDriver) Lock(ctx context.Context) error {
+	d.locked = true
+	return nil
+}
+
+func (d *fakeDriver) Unlock(ctx context.Context) error {
+	d.locked = false
+	return nil
+}
+
+func (d *fakeDriver) EnsureVersionTable(ctx context.Context) error {
+	if d.applied == nil {
+		d.applied = make(map[int64]time.Time)
+	}
+	return nil
+}
+
+func (d *fakeDriver) AppliedVersions(ctx context.Context) (map[int64]time.Time, error) {
+	return d.applied, nil
+}
+
+func (d *fakeDriver) Apply(ctx context.Context, migration Migration, up bool) error {
+	if up {
+		d.scripts = append(d.scripts, migration.Up)
+		d.applied[migration.Version] = time.Now()
+	} else {
+		d.scripts = append(d.scripts, migration.Down)
+		delete(d.applied, migration.Version)
+	}
+	return nil
+}
+
+func testMigrations(t *testing.T) []Migration {
+	migrations, err := Load(fstest.MapFS{
+		"0002_orders.up.sql":   {Data: []byte("create orders")},
+		"0002_orders.down.sql": {Data: []byte("drop orders")},
+		"0001_users.up.sql":    {Data: []byte("create users")},
+		"0001_users.down.sql":  {Data: []byte("drop users")},
+	})
+	require.NoError(t, err)
+	return migrations
+}
+
+func TestLoad(t *testing.T) {
+	migrations := testMigrations(t)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, Migration{Version: 1, Name: "users", Up: "create users", Down: "drop users"}, migrations[0])
+	assert.Equal(t, int64(2), migrations[1].Version)
+
+	_, err := Load(fstest.MapFS{"0001_users.down.sql": {Data: []byte("drop users")}})
+	assert.Error(t, err)
+
+	_, err = Load(fstest.MapFS{"users.up.sql": {Data: []byte("create users")}})
+	assert.Error(t, err)
+
+	postgresMigrations, err := Load(Postgres)
+	require.NoError(t, err)
+	for i, migration := range postgresMigrations {
+		assert.Equal(t, int64(i+1), migration.Version, "версии миграций должны идти подряд")
+		assert.NotEmpty(t, migration.Down, "миграция %d должна уметь откатываться", migration.Version)
+	}
+
+	sqliteMigrations, err := Load(SQLite)
+	require.NoError(t, err)
+	require.Len(t, sqliteMigrations, len(postgresMigrations), "у SQLite те же версии схемы, что и у PostgreSQL")
+	for i, migration := range sqliteMigrations {
+		assert.Equal(t, postgresMigrations[i].Version, migration.Version)
+		assert.Equal(t, postgresMigrations[i].Name, migration.Name)
+		assert.NotEmpty(t, migration.Down, "миграция %d должна уметь откатываться", migration.Version)
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	ctx := context.Background()
+	driver := &fakeDriver{}
+	migrator := New(driver, testMigrations(t))
+
+	count, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.False(t, driver.locked)
+
+	count, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	version, err := migrator.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, migrator.Latest(), version)
+
+	count, err = migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	assert.Nil(t, statuses[1].AppliedAt)
+
+	_, err = migrator.Down(ctx, 5)
+	require.NoError(t, err)
+	_, err = migrator.Down(ctx, 1)
+	assert.ErrorIs(t, err, ErrNoMigrations)
+
+	assert.Equal(t, []string{"create users", "create orders", "drop orders", "drop users"}, driver.scripts)
+}
+
+func TestMigratorSchemaTooNew(t *testing.T) {
+	driver := &fakeDriver{applied: map[int64]time.Time{1: time.Now(), 2: time.Now(), 3: time.Now()}}
+	migrator := New(driver, testMigrations(t))
+
+	_, err := migrator.Up(context.Background())
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+	assert.Empty(t, driver.scripts)
+}
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS users
+(
+	id SERIAL PRIMARY KEY,
+	login varchar(40) NOT NULL,
+	password varchar(64) NOT NULL,
+	sum float DEFAULT 0,
+	withdrawn float DEFAULT 0,
+	registered_at timestamp with time zone,
+	last_time timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS orders
+(
+	number bigint UNIQUE PRIMARY KEY,
+	user_id bigint REFERENCES users(id),
+	status varchar(10) DEFAULT 'NEW',
+	accrual float,
+	uploaded_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS withdrawals
+(
+	number bigint UNIQUE PRIMARY KEY REFERENCES orders(number),
+	user_id bigint REFERENCES users(id),
+	sum float,
+	processed_at timestamp with time zone
+);
@@ -0,0 +1,7 @@
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE float;
+
+ALTER TABLE orders ALTER COLUMN accrual TYPE float;
+
+ALTER TABLE users
+	ALTER COLUMN sum TYPE float,
+	ALTER COLUMN withdrawn TYPE float;
@@ -0,0 +1,8 @@
+-- базы, созданные до перехода на точные суммы, хранили баллы во float
+ALTER TABLE users
+	ALTER COLUMN sum TYPE numeric(14,2),
+	ALTER COLUMN withdrawn TYPE numeric(14,2);
+
+ALTER TABLE orders ALTER COLUMN accrual TYPE numeric(14,2);
+
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE numeric(14,2);
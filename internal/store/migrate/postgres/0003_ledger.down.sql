@@ -0,0 +1,11 @@
+-- возвращаем балансы в столбцы users.sum и users.withdrawn перед удалением книги учёта
+UPDATE users u SET
+	sum = COALESCE((SELECT SUM(amount) FROM ledger_entries e WHERE e.user_id = u.id AND e.account = 'user_points'), 0),
+	withdrawn = COALESCE((SELECT SUM(amount) FROM ledger_entries e WHERE e.user_id = u.id AND e.account = 'withdrawals'), 0);
+
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_transactions;
+DROP FUNCTION IF EXISTS ledger_check_balanced();
+DROP FUNCTION IF EXISTS ledger_immutable();
+
+ALTER TABLE withdrawals DROP COLUMN IF EXISTS refunded_at;
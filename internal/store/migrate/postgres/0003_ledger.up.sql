@@ -0,0 +1,72 @@
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS refunded_at timestamp with time zone;
+
+CREATE TABLE IF NOT EXISTS ledger_transactions
+(
+	id BIGSERIAL PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES users(id),
+	kind varchar(20) NOT NULL,
+	order_number bigint,
+	reason text,
+	created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries
+(
+	id BIGSERIAL PRIMARY KEY,
+	transaction_id bigint NOT NULL REFERENCES ledger_transactions(id),
+	user_id bigint NOT NULL REFERENCES users(id),
+	account varchar(20) NOT NULL,
+	amount numeric(14,2) NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_user_account_idx ON ledger_entries (user_id, account);
+
+-- книга учёта только дополняется, а каждая транзакция к моменту фиксации должна быть сбалансирована
+CREATE OR REPLACE FUNCTION ledger_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+	IF (SELECT SUM(amount) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+		RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id;
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_immutable ON ledger_entries;
+CREATE TRIGGER ledger_entries_immutable BEFORE UPDATE OR DELETE ON ledger_entries
+	FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+DROP TRIGGER IF EXISTS ledger_transactions_immutable ON ledger_transactions;
+CREATE TRIGGER ledger_transactions_immutable BEFORE UPDATE OR DELETE ON ledger_transactions
+	FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+DROP TRIGGER IF EXISTS ledger_entries_balanced ON ledger_entries;
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced AFTER INSERT ON ledger_entries
+	DEFERRABLE INITIALLY DEFERRED
+	FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
+
+-- переносим в книгу учёта балансы, накопленные в столбцах users.sum и users.withdrawn
+WITH legacy AS (
+	SELECT id, sum, withdrawn FROM users u
+	WHERE (sum <> 0 OR withdrawn <> 0)
+		AND NOT EXISTS (SELECT 1 FROM ledger_transactions t WHERE t.user_id = u.id)
+), posted AS (
+	INSERT INTO ledger_transactions (user_id, kind, reason)
+	SELECT id, 'ADJUSTMENT', 'opening balance' FROM legacy
+	RETURNING id, user_id
+)
+INSERT INTO ledger_entries (transaction_id, user_id, account, amount)
+SELECT posted.id, posted.user_id, e.account, e.amount
+FROM posted
+JOIN legacy ON legacy.id = posted.user_id
+CROSS JOIN LATERAL (VALUES
+	('user_points', legacy.sum),
+	('withdrawals', legacy.withdrawn),
+	('adjustments', -(legacy.sum + legacy.withdrawn))
+) AS e(account, amount)
+WHERE e.amount <> 0;
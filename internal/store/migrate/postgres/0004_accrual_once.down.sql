@@ -0,0 +1 @@
+DROP INDEX IF EXISTS ledger_transactions_accrual_once;
@@ -0,0 +1,5 @@
+-- аренда заказа воркером: пока locked_until не истёк, другие реплики заказ не опрашивают
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_by text;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_until timestamp with time zone;
+
+CREATE INDEX IF NOT EXISTS orders_pending_idx ON orders (uploaded_at) WHERE status IN ('NEW', 'PROCESSING');
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS orders_dead_lettered_idx;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS requeued_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS dead_lettered_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS last_error;
+ALTER TABLE orders DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,9 @@
+-- неудачные опросы системы расчёта откладываются с растущей паузой,
+-- а слишком долго не обработанные заказы переводятся в dead-letter до ручного возврата в очередь
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_attempt_at timestamp with time zone;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_error text;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS dead_lettered_at timestamp with time zone;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS requeued_at timestamp with time zone;
+
+CREATE INDEX IF NOT EXISTS orders_dead_lettered_idx ON orders (dead_lettered_at) WHERE dead_lettered_at IS NOT NULL;
@@ -0,0 +1,8 @@
+-- подписи принятых уведомлений системы расчёта, повтор уведомления в окне допустимого времени отклоняется
+CREATE TABLE IF NOT EXISTS accrual_callbacks
+(
+	signature text PRIMARY KEY,
+	expires_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS accrual_callbacks_expires_at_idx ON accrual_callbacks (expires_at);
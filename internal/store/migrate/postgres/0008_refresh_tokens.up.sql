@@ -0,0 +1,23 @@
+-- токены обновления хранятся в виде SHA-256; family объединяет цепочку токенов, полученных ротацией одного входа
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+	token_hash text PRIMARY KEY,
+	family text NOT NULL,
+	user_id bigint NOT NULL REFERENCES users(id),
+	issued_at timestamp with time zone NOT NULL DEFAULT now(),
+	expires_at timestamp with time zone NOT NULL,
+	used_at timestamp with time zone,
+	revoked_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+
+-- отозванные токены доступа хранятся до истечения их срока действия
+CREATE TABLE IF NOT EXISTS revoked_access_tokens
+(
+	jti text PRIMARY KEY,
+	expires_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS revoked_access_tokens_expires_at_idx ON revoked_access_tokens (expires_at);
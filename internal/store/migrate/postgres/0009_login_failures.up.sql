@@ -0,0 +1,13 @@
+-- неудачные входы подряд и временная блокировка пользователя
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until timestamp with time zone;
+
+-- неудачные попытки входа для ограничения в скользящем окне, key — login:<логин> или ip:<адрес>
+CREATE TABLE IF NOT EXISTS login_failures
+(
+	key text NOT NULL,
+	attempted_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_key_idx ON login_failures (key, attempted_at);
+CREATE INDEX IF NOT EXISTS login_failures_attempted_at_idx ON login_failures (attempted_at);
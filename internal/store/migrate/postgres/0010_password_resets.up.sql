@@ -0,0 +1,10 @@
+-- токены сброса пароля хранятся в виде SHA-256 и действуют один раз
+CREATE TABLE IF NOT EXISTS password_resets
+(
+	token_hash text PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES users(id),
+	expires_at timestamp with time zone NOT NULL,
+	used_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets (user_id);
@@ -0,0 +1,17 @@
+-- логины хранятся в виде auth.NormalizeLogin: без пробелов по краям и в нижнем регистре.
+-- Если после приведения логины совпадают, миграция останавливается: такие учётные записи
+-- нужно переименовать или объединить вручную и запустить миграции заново
+DO $$
+DECLARE
+	collisions text;
+BEGIN
+	SELECT string_agg(normalized, ', ') INTO collisions
+	FROM (SELECT lower(btrim(login)) AS normalized FROM users GROUP BY 1 HAVING count(*) > 1) AS duplicates;
+	IF collisions IS NOT NULL THEN
+		RAISE EXCEPTION 'users.login collide after normalization: %', collisions;
+	END IF;
+END $$;
+
+UPDATE users SET login = lower(btrim(login)) WHERE login <> lower(btrim(login));
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_login_key ON users (login);
@@ -0,0 +1,11 @@
+-- журнал действий сотрудников в /api/admin
+CREATE TABLE IF NOT EXISTS admin_actions
+(
+	id bigserial PRIMARY KEY,
+	actor text NOT NULL,
+	action text NOT NULL,
+	path text NOT NULL,
+	status integer NOT NULL,
+	body text,
+	created_at timestamp with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS users
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login varchar(40) NOT NULL,
+	password varchar(64) NOT NULL,
+	registered_at timestamp,
+	last_time timestamp
+);
+
+CREATE TABLE IF NOT EXISTS orders
+(
+	number bigint PRIMARY KEY,
+	user_id bigint REFERENCES users(id),
+	status varchar(10) DEFAULT 'NEW',
+	uploaded_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS withdrawals
+(
+	number bigint PRIMARY KEY REFERENCES orders(number),
+	user_id bigint REFERENCES users(id),
+	processed_at timestamp
+);
@@ -0,0 +1,3 @@
+ALTER TABLE withdrawals DROP COLUMN sum;
+
+ALTER TABLE orders DROP COLUMN accrual;
@@ -0,0 +1,4 @@
+-- суммы хранятся целым числом копеек
+ALTER TABLE orders ADD COLUMN accrual integer;
+
+ALTER TABLE withdrawals ADD COLUMN sum integer;
@@ -0,0 +1,43 @@
+ALTER TABLE withdrawals ADD COLUMN refunded_at timestamp;
+
+CREATE TABLE IF NOT EXISTS ledger_transactions
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id bigint NOT NULL REFERENCES users(id),
+	kind varchar(20) NOT NULL,
+	order_number bigint,
+	reason text,
+	created_at timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	transaction_id bigint NOT NULL REFERENCES ledger_transactions(id),
+	user_id bigint NOT NULL REFERENCES users(id),
+	account varchar(20) NOT NULL,
+	amount integer NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_user_account_idx ON ledger_entries (user_id, account);
+
+-- книга учёта только дополняется, сбалансированность транзакций проверяет приложение
+CREATE TRIGGER IF NOT EXISTS ledger_entries_immutable_update BEFORE UPDATE ON ledger_entries
+BEGIN
+	SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS ledger_entries_immutable_delete BEFORE DELETE ON ledger_entries
+BEGIN
+	SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS ledger_transactions_immutable_update BEFORE UPDATE ON ledger_transactions
+BEGIN
+	SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS ledger_transactions_immutable_delete BEFORE DELETE ON ledger_transactions
+BEGIN
+	SELECT RAISE(ABORT, 'ledger is append-only');
+END;
@@ -0,0 +1,4 @@
+UPDATE orders SET status = 'PROCESSING' WHERE status = 'REGISTERED';
+
+-- баллы за заказ начисляются не более одного раза
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_transactions_accrual_once ON ledger_transactions (order_number) WHERE kind = 'ACCRUAL';
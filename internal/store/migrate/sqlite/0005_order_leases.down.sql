@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS orders_pending_idx;
+
+ALTER TABLE orders DROP COLUMN locked_until;
+ALTER TABLE orders DROP COLUMN locked_by;
@@ -0,0 +1,5 @@
+-- аренда заказа воркером: пока locked_until (unix-время в миллисекундах) не истёк, заказ не опрашивается повторно
+ALTER TABLE orders ADD COLUMN locked_by text;
+ALTER TABLE orders ADD COLUMN locked_until integer;
+
+CREATE INDEX IF NOT EXISTS orders_pending_idx ON orders (uploaded_at) WHERE status IN ('NEW', 'PROCESSING');
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS orders_dead_lettered_idx;
+
+ALTER TABLE orders DROP COLUMN requeued_at;
+ALTER TABLE orders DROP COLUMN dead_lettered_at;
+ALTER TABLE orders DROP COLUMN last_error;
+ALTER TABLE orders DROP COLUMN next_attempt_at;
+ALTER TABLE orders DROP COLUMN attempts;
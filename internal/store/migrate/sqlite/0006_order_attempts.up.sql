@@ -0,0 +1,9 @@
+-- неудачные опросы системы расчёта откладываются с растущей паузой (next_attempt_at — unix-время в миллисекундах),
+-- а слишком долго не обработанные заказы переводятся в dead-letter до ручного возврата в очередь
+ALTER TABLE orders ADD COLUMN attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN next_attempt_at integer;
+ALTER TABLE orders ADD COLUMN last_error text;
+ALTER TABLE orders ADD COLUMN dead_lettered_at timestamp;
+ALTER TABLE orders ADD COLUMN requeued_at timestamp;
+
+CREATE INDEX IF NOT EXISTS orders_dead_lettered_idx ON orders (dead_lettered_at) WHERE dead_lettered_at IS NOT NULL;
@@ -0,0 +1,9 @@
+-- подписи принятых уведомлений системы расчёта, повтор уведомления в окне допустимого времени отклоняется;
+-- expires_at — unix-время в миллисекундах
+CREATE TABLE IF NOT EXISTS accrual_callbacks
+(
+	signature text PRIMARY KEY,
+	expires_at integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS accrual_callbacks_expires_at_idx ON accrual_callbacks (expires_at);
@@ -0,0 +1,14 @@
+-- неудачные входы подряд и временная блокировка пользователя, locked_until — unix-время в миллисекундах
+ALTER TABLE users ADD COLUMN failed_logins integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until integer;
+
+-- неудачные попытки входа для ограничения в скользящем окне, key — login:<логин> или ip:<адрес>;
+-- attempted_at — unix-время в миллисекундах
+CREATE TABLE IF NOT EXISTS login_failures
+(
+	key text NOT NULL,
+	attempted_at integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_key_idx ON login_failures (key, attempted_at);
+CREATE INDEX IF NOT EXISTS login_failures_attempted_at_idx ON login_failures (attempted_at);
@@ -0,0 +1,27 @@
+-- журнал аудита изменений: записывается в той же транзакции, что и само изменение, и только дополняется;
+-- created_at — unix-время в миллисекундах
+CREATE TABLE IF NOT EXISTS audit_events
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor text NOT NULL,
+	action text NOT NULL,
+	entity_type text NOT NULL,
+	entity_id text NOT NULL,
+	before text,
+	after text,
+	request_id text,
+	created_at integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_entity_idx ON audit_events (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor);
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
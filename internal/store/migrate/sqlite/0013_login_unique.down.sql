@@ -0,0 +1,2 @@
+-- приведённые логины не возвращаются к исходному написанию
+DROP INDEX IF EXISTS users_login_key;
@@ -0,0 +1,10 @@
+-- логины хранятся в виде auth.NormalizeLogin: без пробелов по краям и в нижнем регистре,
+-- normalize_login регистрирует хранилище SQLite. Если после приведения логины совпадают, создание
+-- users_login_normalized завершается ошибкой UNIQUE constraint failed и миграция останавливается:
+-- такие учётные записи нужно переименовать или объединить вручную и запустить миграции заново
+CREATE UNIQUE INDEX users_login_normalized ON users (normalize_login(login));
+DROP INDEX users_login_normalized;
+
+UPDATE users SET login = normalize_login(login) WHERE login <> normalize_login(login);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_login_key ON users (login);
@@ -0,0 +1,11 @@
+-- журнал действий сотрудников в /api/admin, created_at — unix-время в миллисекундах
+CREATE TABLE IF NOT EXISTS admin_actions
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor text NOT NULL,
+	action text NOT NULL,
+	path text NOT NULL,
+	status integer NOT NULL,
+	body text,
+	created_at integer NOT NULL
+);
@@ -0,0 +1,2 @@
+-- действия сотрудников записываются в audit_events в той же транзакции, что и сами изменения
+DROP TABLE IF EXISTS admin_actions;
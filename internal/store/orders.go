@@ -0,0 +1,26 @@
+package store
+
+import "gophermart/internal/models"
+
+// OrderStatusTransition определяет, как применить ответ системы расчёта к заказу в статусе current.
+// Возвращает итоговый статус, нужно ли обновлять заказ и нужно ли начислять баллы.
+// Начисление происходит только при переходе в PROCESSED, повтор того же ответа ничего не меняет.
+func OrderStatusTransition(current string, next string) (status string, update bool, credit bool) {
+	if current == models.OrderStatusProcessed || current == models.OrderStatusInvalid {
+		return current, false, false
+	}
+
+	switch next {
+	case models.AccrualStatusRegistered, models.OrderStatusProcessing:
+		status = models.OrderStatusProcessing
+	case models.OrderStatusInvalid, models.OrderStatusProcessed:
+		status = next
+	default:
+		return current, false, false
+	}
+
+	if status == current {
+		return current, false, false
+	}
+	return status, true, status == models.OrderStatusProcessed
+}
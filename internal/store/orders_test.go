@@ -0,0 +1,37 @@
+package store
+
+import (
+	"testing"
+
+	"gophermart/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderStatusTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		next    string
+		status  string
+		update  bool
+		credit  bool
+	}{
+		{name: "заказ зарегистрирован", current: models.OrderStatusNew, next: models.AccrualStatusRegistered, status: models.OrderStatusProcessing, update: true},
+		{name: "расчёт продолжается", current: models.OrderStatusProcessing, next: models.OrderStatusProcessing, status: models.OrderStatusProcessing},
+		{name: "расчёт завершён", current: models.OrderStatusProcessing, next: models.OrderStatusProcessed, status: models.OrderStatusProcessed, update: true, credit: true},
+		{name: "новый заказ сразу рассчитан", current: models.OrderStatusNew, next: models.OrderStatusProcessed, status: models.OrderStatusProcessed, update: true, credit: true},
+		{name: "отказ в расчёте", current: models.OrderStatusNew, next: models.OrderStatusInvalid, status: models.OrderStatusInvalid, update: true},
+		{name: "повторный ответ PROCESSED", current: models.OrderStatusProcessed, next: models.OrderStatusProcessed, status: models.OrderStatusProcessed},
+		{name: "ответ после отказа", current: models.OrderStatusInvalid, next: models.OrderStatusProcessed, status: models.OrderStatusInvalid},
+		{name: "неизвестный статус", current: models.OrderStatusNew, next: "UNKNOWN", status: models.OrderStatusNew},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, update, credit := OrderStatusTransition(test.current, test.next)
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.update, update)
+			assert.Equal(t, test.credit, credit)
+		})
+	}
+}
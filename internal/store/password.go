@@ -0,0 +1,22 @@
+package store
+
+import (
+	"context"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword возвращает bcrypt-хеш пароля. Хеширование — заметная часть регистрации и смены пароля,
+// поэтому у него свой span.
+func HashPassword(ctx context.Context, password string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// ComparePassword сверяет пароль с bcrypt-хешем, несовпадение возвращает ошибку bcrypt
+func ComparePassword(ctx context.Context, hashedPassword []byte, password string) error {
+	_, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+	return bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+}
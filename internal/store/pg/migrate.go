@@ -0,0 +1,124 @@
+package pg
+
+import (
+	"context"
+	"time"
+
+	"gophermart/internal/store/migrate"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationLockKey ключ рекомендательной блокировки, под которой применяются миграции
+const migrationLockKey int64 = 7366294815
+
+// migrationDriver выполняет миграции на одном выделенном соединении,
+// так как рекомендательная блокировка принадлежит сессии
+type migrationDriver struct {
+	pool *pgxpool.Pool
+	conn *pgxpool.Conn
+}
+
+func (d *migrationDriver) Lock(ctx context.Context) error {
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey)
+	if err != nil {
+		conn.Release()
+		return err
+	}
+	d.conn = conn
+	return nil
+}
+
+func (d *migrationDriver) Unlock(ctx context.Context) error {
+	if d.conn == nil {
+		return nil
+	}
+	defer func() {
+		d.conn.Release()
+		d.conn = nil
+	}()
+	_, err := d.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	return err
+}
+
+func (d *migrationDriver) querier() querier {
+	if d.conn != nil {
+		return d.conn
+	}
+	return d.pool
+}
+
+func (d *migrationDriver) EnsureVersionTable(ctx context.Context) error {
+	_, err := d.querier().Exec(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamp with time zone NOT NULL DEFAULT now()
+		)`)
+	return err
+}
+
+func (d *migrationDriver) AppliedVersions(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := d.querier().Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (d *migrationDriver) Apply(ctx context.Context, migration migrate.Migration, up bool) error {
+	return pgx.BeginFunc(ctx, d.conn, func(tx pgx.Tx) error {
+		script := migration.Down
+		if up {
+			script = migration.Up
+		}
+		_, err := tx.Exec(ctx, script)
+		if err != nil {
+			return err
+		}
+
+		if up {
+			_, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+		} else {
+			_, err = tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+		}
+		return err
+	})
+}
+
+// Migrator возвращает управление миграциями схемы этой базы данных
+func (db *Database) Migrator() (*migrate.Migrator, error) {
+	migrations, err := migrate.Load(migrate.Postgres)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(&migrationDriver{pool: db.Conn}, migrations), nil
+}
+
+// Migrations применяет неприменённые миграции и отказывается работать со схемой новее приложения
+func (db *Database) Migrations(ctx context.Context) error {
+	migrator, err := db.Migrator()
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(ctx)
+	return err
+}
@@ -2,12 +2,13 @@ package pg
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
 	"strconv"
 	"time"
 
 	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
 	"gophermart/internal/models"
 	"gophermart/internal/store"
 
@@ -15,85 +16,121 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Database struct {
 	Conn *pgxpool.Pool
 }
 
+// querier общий набор методов пула соединений и транзакции
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 func NewDatabase(uri string) *Database {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// миграции могут ждать, пока их применяет другая реплика
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
-	config, err := pgxpool.ParseConfig(uri)
-	if err != nil {
-		logger.Logger.Panic("Ошибка при парсинге конфигурации:", zap.Error(err))
-		return nil
-	}
-	conn, err := pgxpool.NewWithConfig(ctx, config)
+	db, err := Connect(ctx, uri)
 	if err != nil {
-		logger.Logger.Panic("Не удалось подключиться к базе данных")
+		logger.Logger.Panic("Не удалось подключиться к базе данных", zap.Error(err))
 		return nil
 	}
-	db := &Database{Conn: conn}
 	err = db.Migrations(ctx)
 	if err != nil {
-		logger.Logger.Panic("Не удалось подключиться к базе данных", zap.Error(err))
+		logger.Logger.Panic("Не удалось применить миграции", zap.Error(err))
 		return nil
 	}
 	return db
 }
 
+// Connect подключается к базе данных без применения миграций
+func Connect(ctx context.Context, uri string) (*Database, error) {
+	config, err := pgxpool.ParseConfig(uri)
+	if err != nil {
+		return nil, err
+	}
+	config.ConnConfig.Tracer = newQueryTracer()
+	conn, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Database{Conn: conn}, nil
+}
+
 func (db *Database) Close() {
 	db.Conn.Close()
 }
 
-func (db *Database) Migrations(ctx context.Context) error {
-
-	_, err := db.Conn.Exec(ctx,
-		`CREATE TABLE IF NOT EXISTS users
-		(
-			id SERIAL PRIMARY KEY,
-			login varchar(40) NOT NULL,
-			password varchar(64) NOT NULL,
-			sum float DEFAULT 0,
-			withdrawn float DEFAULT 0,
-			registered_at timestamp with time zone,
-			last_time timestamp with time zone
-		)`)
+// postLedger записывает сбалансированную транзакцию в книгу учёта, q должен быть транзакцией базы данных
+func postLedger(ctx context.Context, q querier, userID int64, posting *store.LedgerPosting) error {
+	err := posting.Validate()
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Conn.Exec(ctx,
-		`CREATE TABLE IF NOT EXISTS orders
-		(
-			number bigint UNIQUE PRIMARY KEY,
-			user_id bigint REFERENCES users(id),
-			status varchar(10) DEFAULT 'NEW', 
-			accrual float,
-			uploaded_at timestamp with time zone
-		)`)
+	var order *int64
+	if posting.Order != 0 {
+		order = &posting.Order
+	}
+	var reason *string
+	if posting.Reason != "" {
+		reason = &posting.Reason
+	}
+
+	var transactionID int64
+	err = q.QueryRow(ctx,
+		`INSERT INTO ledger_transactions (user_id, kind, order_number, reason, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		userID, posting.Kind, order, reason, time.Now()).Scan(&transactionID)
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Conn.Exec(ctx,
-		`CREATE TABLE IF NOT EXISTS withdrawals
-		(
-			number bigint UNIQUE PRIMARY KEY REFERENCES orders(number),
-			user_id bigint REFERENCES users(id),
-			sum float,
-			processed_at timestamp with time zone
-		)`)
+	for _, entry := range posting.Entries {
+		_, err = q.Exec(ctx,
+			`INSERT INTO ledger_entries (transaction_id, user_id, account, amount) VALUES ($1, $2, $3, $4)`,
+			transactionID, userID, entry.Account, entry.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userPoints возвращает текущий баланс пользователя по книге учёта
+func userPoints(ctx context.Context, q querier, userID int64) (models.Money, error) {
+	var balance models.Money
+	err := q.QueryRow(ctx,
+		`SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE user_id = $1 AND account = $2`,
+		userID, store.AccountUserPoints).Scan(&balance)
+	return balance, err
+}
+
+// audit записывает событие в журнал аудита, q должен быть транзакцией, в которой выполняется само изменение
+func audit(ctx context.Context, q querier, event models.AuditEvent) error {
+	_, err := q.Exec(ctx,
+		`INSERT INTO audit_events (actor, action, entity_type, entity_id, before, after, request_id)
+		VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb, NULLIF($7, ''))`,
+		event.Actor, event.Action, event.EntityType, event.EntityID, auditJSON(event.Before), auditJSON(event.After), event.RequestID)
 	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось записать событие в журнал аудита", zap.Error(err))
 		return err
 	}
-
 	return nil
 }
 
+// auditJSON возвращает состояние сущности для записи в jsonb, nil — NULL
+func auditJSON(state json.RawMessage) *string {
+	if len(state) == 0 {
+		return nil
+	}
+	value := string(state)
+	return &value
+}
+
 func (db *Database) Ping(ctx context.Context) bool {
 	if err := db.Conn.Ping(ctx); err != nil {
 		return false
@@ -106,29 +143,52 @@ func (db *Database) UserRegister(ctx context.Context, login string, password str
 	err := db.Conn.QueryRow(ctx, `SELECT COUNT(login) FROM users WHERE login = $1`, login).Scan(&countRow)
 
 	if err != nil {
-		logger.Logger.Warn("Ошибка выполнения запроса ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
 		return err
 	}
 
 	if countRow != 0 {
-		logger.Logger.Warn("Пользователь существует")
+		logger.FromContext(ctx).Warn("Пользователь существует")
 		return store.ErrLoginDuplicate
 	}
 
 	var hashedPassword []byte
-	hashedPassword, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err = store.HashPassword(ctx, password)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка при хешировании пароля ", zap.Error(err))
+		return err
+	}
+
+	tx, err := db.Conn.Begin(ctx)
 	if err != nil {
-		logger.Logger.Warn("Ошибка при хешировании пароля ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	_, err = db.Conn.Exec(ctx,
+	_, err = tx.Exec(ctx,
 		`INSERT INTO users (login, password, registered_at) VALUES ($1, $2, $3)`, login, string(hashedPassword), time.Now())
+	var duplicateEntryError = &pgconn.PgError{Code: "23505"}
+	if errors.As(err, &duplicateEntryError) {
+		// логин заняли параллельной регистрацией после проверки выше
+		logger.FromContext(ctx).Warn("Пользователь существует")
+		return store.ErrLoginDuplicate
+	}
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось добавить пользователя ", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserRegister, store.AuditEntityUser, login,
+		nil, store.AuditUserState{Role: models.RoleUser}))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
 	if err != nil {
-		logger.Logger.Warn("Не удалось добавить пользователя ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
 		return err
 	}
-	logger.Logger.Info("Добавлен новый пользователь")
+	logger.FromContext(ctx).Info("Добавлен новый пользователь")
 	return nil
 }
 
@@ -140,51 +200,79 @@ func (db *Database) UserLogin(ctx context.Context, login string, password string
 	if err == pgx.ErrNoRows {
 		return store.ErrAuthentication
 	} else if err != nil {
-		logger.Logger.Warn("Ошибка выполнения запроса ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
 		return err
 	}
 
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	err = store.ComparePassword(ctx, hashedPassword, password)
 	if err != nil {
 		return store.ErrAuthentication
 	}
-	return nil
+	return audit(ctx, db.Conn, store.NewAuditEvent(ctx, login, store.AuditUserLogin, store.AuditEntityUser, login, nil, nil))
 }
 
 func (db *Database) UploadUserOrders(ctx context.Context, login string, order int64) error {
-	var idUser int
+	var idUser int64
 	err := db.Conn.QueryRow(ctx, `SELECT id FROM users WHERE login = $1`, login).Scan(&idUser)
-	if err != nil && err != pgx.ErrNoRows {
-		logger.Logger.Warn("Ошибка выполнения запроса id", zap.Error(err))
+	if err == pgx.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса id", zap.Error(err))
+		return err
+	}
+
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = uploadOrder(ctx, tx, idUser, order)
+	if err != nil {
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditOrderUpload, store.AuditEntityOrder, strconv.FormatInt(order, 10),
+		nil, store.AuditOrderState{Status: models.OrderStatusNew}))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
 		return err
 	}
+	logger.FromContext(ctx).Info("Добавлен новый заказ")
+	return nil
+}
 
+// uploadOrder добавляет заказ пользователю, q может быть как пулом соединений, так и транзакцией.
+func uploadOrder(ctx context.Context, q querier, idUser int64, order int64) error {
 	var countUser int
-	err = db.Conn.QueryRow(ctx, `SELECT COUNT(user_id) FROM orders WHERE number = $1 AND user_id <> $2`, order, idUser).Scan(&countUser)
+	err := q.QueryRow(ctx, `SELECT COUNT(user_id) FROM orders WHERE number = $1 AND user_id <> $2`, order, idUser).Scan(&countUser)
 
 	if err != nil && err != pgx.ErrNoRows {
-		logger.Logger.Warn("Ошибка выполнения запроса user id", zap.Error(err))
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса user id", zap.Error(err))
 		return err
 	}
 
 	if countUser > 0 {
-		logger.Logger.Warn("Этот заказ добавлен для другого пользователя")
+		logger.FromContext(ctx).Warn("Этот заказ добавлен для другого пользователя")
 		return store.ErrDuplicateOrderOtherUser
 	}
 
-	_, err = db.Conn.Exec(ctx,
+	_, err = q.Exec(ctx,
 		`INSERT INTO orders (number, user_id, uploaded_at) VALUES ($1, $2, $3)`, order, idUser, time.Now())
 
 	var duplicateEntryError = &pgconn.PgError{Code: "23505"}
 	if err != nil {
 		if errors.As(err, &duplicateEntryError) {
-			logger.Logger.Warn("Дубликат заказа")
+			logger.FromContext(ctx).Warn("Дубликат заказа")
 			return store.ErrDuplicateOrder
 		}
-		logger.Logger.Warn("Не удалось добавить заказ ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Не удалось добавить заказ ", zap.Error(err))
 		return err
 	}
-	logger.Logger.Info("Добавлен новый заказ")
 	return nil
 }
 
@@ -193,22 +281,18 @@ func (db *Database) GetUserOrders(ctx context.Context, login string) ([]models.S
 	var ordersUser []models.StatusOrders
 	rows, err := db.Conn.Query(ctx, `SELECT number,status,accrual,uploaded_at FROM orders WHERE user_id = (SELECT id FROM users WHERE login = $1) ORDER BY uploaded_at DESC`, login)
 	if err != nil {
-		logger.Logger.Warn("Ошибка выполнения запроса ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
 		return ordersUser, err
 	}
 
 	defer rows.Close()
 
 	for rows.Next() {
-		var accural sql.NullFloat64
-		err = rows.Scan(&orderUser.Number, &orderUser.Status, &accural, &orderUser.UploadedAt)
+		err = rows.Scan(&orderUser.Number, &orderUser.Status, &orderUser.Accrual, &orderUser.UploadedAt)
 		if err != nil {
-			logger.Logger.Warn("Ошибка при сканировании строки:", zap.Error(err))
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
 			return ordersUser, err
 		}
-		if accural.Valid {
-			orderUser.Accrual = accural.Float64
-		}
 		ordersUser = append(ordersUser, orderUser)
 	}
 
@@ -217,53 +301,84 @@ func (db *Database) GetUserOrders(ctx context.Context, login string) ([]models.S
 
 func (db *Database) GetUserBalance(ctx context.Context, login string) (models.Balance, error) {
 	var userBalance models.Balance
-	err := db.Conn.QueryRow(ctx, `SELECT sum,withdrawn FROM users WHERE login = $1`, login).Scan(&userBalance.Current, &userBalance.Withdrawn)
+	err := db.Conn.QueryRow(ctx,
+		`SELECT COALESCE(SUM(e.amount) FILTER (WHERE e.account = $2), 0),
+			COALESCE(SUM(e.amount) FILTER (WHERE e.account = $3), 0)
+		FROM users u LEFT JOIN ledger_entries e ON e.user_id = u.id
+		WHERE u.login = $1`,
+		login, store.AccountUserPoints, store.AccountWithdrawals).Scan(&userBalance.Current, &userBalance.Withdrawn)
 	if err != nil {
-		logger.Logger.Warn("Ошибка выполнения запроса ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
 		return userBalance, err
 	}
 	return userBalance, nil
 }
 
-func (db *Database) UpdateUserBalanceWithdraw(ctx context.Context, login string, order string, sum float64) error {
+func (db *Database) UpdateUserBalanceWithdraw(ctx context.Context, login string, order string, sum models.Money) error {
+	number, err := strconv.ParseInt(order, 10, 64)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось добавмить значение", zap.Error(err))
+		return err
+	}
+
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// блокируем строку пользователя до конца транзакции, чтобы параллельные списания выполнялись по очереди
 	var userID int64
-	var balance float64
-	var withdrawn float64
-	err := db.Conn.QueryRow(ctx, `SELECT id, sum, withdrawn FROM users WHERE login = $1`, login).Scan(&userID, &balance, &withdrawn)
+	err = tx.QueryRow(ctx, `SELECT id FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	balance, err := userPoints(ctx, tx, userID)
 	if err != nil {
-		logger.Logger.Warn("Ошибка выполнения запроса ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
 		return err
 	}
 
 	if balance < sum {
-		logger.Logger.Warn("на счету недостаточно средств")
+		logger.FromContext(ctx).Warn("на счету недостаточно средств")
 		return store.ErrInsufficientFunds
 	}
 
-	number, err := strconv.ParseInt(order, 10, 64)
+	err = uploadOrder(ctx, tx, userID, number)
 	if err != nil {
-		logger.Logger.Warn("Не удалось добавмить значение", zap.Error(err))
+		logger.FromContext(ctx).Warn("Не удалось добавить значение", zap.Error(err))
 		return err
+	}
 
+	_, err = tx.Exec(ctx, `INSERT INTO withdrawals (number, user_id, sum, processed_at) VALUES ($1, $2, $3, $4) `, number, userID, sum, time.Now())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось добавить значение", zap.Error(err))
+		return err
 	}
 
-	err = db.UploadUserOrders(ctx, login, number)
+	err = postLedger(ctx, tx, userID, store.NewWithdrawalPosting(number, sum))
 	if err != nil {
-		logger.Logger.Warn("Не удалось добавить значение", zap.Error(err))
+		logger.FromContext(ctx).Warn("Не удалось обновить баланс", zap.Error(err))
 		return err
 	}
 
-	_, err = db.Conn.Exec(ctx, `INSERT INTO withdrawals (number, user_id, sum, processed_at) VALUES ($1, $2, $3, $4) `, order, userID, sum, time.Now())
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditWithdrawal, store.AuditEntityWithdrawal, order,
+		store.AuditBalanceState{Current: balance}, store.AuditBalanceState{Current: balance - sum, Sum: &sum}))
 	if err != nil {
-		logger.Logger.Warn("Не удалось добавить значение", zap.Error(err))
 		return err
 	}
 
-	_, err = db.Conn.Exec(ctx, `UPDATE users SET sum = $1, withdrawn = $2 WHERE login = $3`, balance-sum, withdrawn+sum, login)
+	err = tx.Commit(ctx)
 	if err != nil {
-		logger.Logger.Warn("Не удалось обновить баланс", zap.Error(err))
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
 		return err
 	}
+	metrics.PointsWithdrawn.Add(sum.Float64())
 
 	return nil
 }
@@ -273,7 +388,7 @@ func (db *Database) GetUserWithdrawals(ctx context.Context, login string) ([]mod
 	var withdrawalsUser []models.BalanceWithdrawals
 	rows, err := db.Conn.Query(ctx, `SELECT number,sum,processed_at FROM withdrawals WHERE user_id = (SELECT id FROM users WHERE login = $1) ORDER BY processed_at DESC`, login)
 	if err != nil {
-		logger.Logger.Warn("Ошибка выполнения запроса ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
 		return withdrawalsUser, err
 	}
 
@@ -282,7 +397,7 @@ func (db *Database) GetUserWithdrawals(ctx context.Context, login string) ([]mod
 	for rows.Next() {
 		err = rows.Scan(&withdrawalUser.Order, &withdrawalUser.Sum, &withdrawalUser.ProcessedAt)
 		if err != nil {
-			logger.Logger.Warn("Ошибка при сканировании строки:", zap.Error(err))
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
 			return withdrawalsUser, err
 		}
 
@@ -294,9 +409,11 @@ func (db *Database) GetUserWithdrawals(ctx context.Context, login string) ([]mod
 func (db *Database) GetOrdersProcessing(ctx context.Context) ([]int64, error) {
 	var orderUser int64
 	var ordersUser []int64
-	rows, err := db.Conn.Query(ctx, `SELECT number FROM orders WHERE status = 'NEW' OR status = 'PROCESSING'  ORDER BY uploaded_at DESC`)
+	rows, err := db.Conn.Query(ctx, `SELECT number FROM orders
+		WHERE (status = $1 OR status = $2) AND dead_lettered_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+		ORDER BY uploaded_at DESC`, models.OrderStatusNew, models.OrderStatusProcessing)
 	if err != nil {
-		logger.Logger.Warn("Ошибка выполнения запроса ", zap.Error(err))
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
 		return ordersUser, err
 	}
 
@@ -305,7 +422,7 @@ func (db *Database) GetOrdersProcessing(ctx context.Context) ([]int64, error) {
 	for rows.Next() {
 		err = rows.Scan(&orderUser)
 		if err != nil {
-			logger.Logger.Warn("Ошибка при сканировании строки:", zap.Error(err))
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
 			return ordersUser, err
 		}
 
@@ -315,22 +432,930 @@ func (db *Database) GetOrdersProcessing(ctx context.Context) ([]int64, error) {
 	return ordersUser, nil
 }
 
+func (db *Database) CountPendingOrders(ctx context.Context) (int, error) {
+	var count int
+	err := db.Conn.QueryRow(ctx,
+		`SELECT COUNT(*) FROM orders WHERE status IN ($1, $2) AND dead_lettered_at IS NULL`,
+		models.OrderStatusNew, models.OrderStatusProcessing).Scan(&count)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
+
+func (db *Database) ClaimOrders(ctx context.Context, worker string, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	var job models.AccrualJob
+	var jobs []models.AccrualJob
+	// SKIP LOCKED пропускает заказы, которые в этот момент арендует другая реплика,
+	// а проверка locked_until возвращает в работу заказы упавших воркеров
+	rows, err := db.Conn.Query(ctx,
+		`UPDATE orders SET locked_by = $1, locked_until = now() + make_interval(secs => $2)
+		WHERE number IN (
+			SELECT number FROM orders
+			WHERE status IN ($3, $4) AND dead_lettered_at IS NULL
+				AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+				AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY uploaded_at
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING number, attempts, COALESCE(requeued_at, uploaded_at)`,
+		worker, lease.Seconds(), models.OrderStatusNew, models.OrderStatusProcessing, limit)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return jobs, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		err = rows.Scan(&job.Number, &job.Attempts, &job.QueuedAt)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return jobs, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+func (db *Database) RenewOrderLease(ctx context.Context, worker string, order int64, lease time.Duration) error {
+	tag, err := db.Conn.Exec(ctx,
+		`UPDATE orders SET locked_until = now() + make_interval(secs => $3)
+		WHERE number = $1 AND locked_by = $2 AND locked_until >= now()`, order, worker, lease.Seconds())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось продлить аренду заказа", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrLeaseLost
+	}
+	return nil
+}
+
+func (db *Database) ReleaseOrder(ctx context.Context, worker string, order int64) error {
+	_, err := db.Conn.Exec(ctx, `UPDATE orders SET locked_by = NULL, locked_until = NULL WHERE number = $1 AND locked_by = $2`, order, worker)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось снять аренду заказа", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) RetryOrder(ctx context.Context, worker string, order int64, nextAttemptAt time.Time, reason string) error {
+	_, err := db.Conn.Exec(ctx,
+		`UPDATE orders SET attempts = attempts + 1, next_attempt_at = $3, last_error = $4, locked_by = NULL, locked_until = NULL
+		WHERE number = $1 AND locked_by = $2`, order, worker, nextAttemptAt, reason)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отложить опрос заказа", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) DeadLetterOrder(ctx context.Context, worker string, order int64, reason string) error {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var before store.AuditOrderState
+	err = tx.QueryRow(ctx,
+		`SELECT status, attempts, COALESCE(last_error, '') FROM orders WHERE number = $1 AND locked_by = $2 FOR UPDATE`,
+		order, worker).Scan(&before.Status, &before.Attempts, &before.LastError)
+	if err == pgx.ErrNoRows {
+		return nil
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE orders SET attempts = attempts + 1, dead_lettered_at = now(), last_error = $2, locked_by = NULL, locked_until = NULL
+		WHERE number = $1`, order, reason)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось перевести заказ в dead-letter", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, "", store.AuditOrderDeadLetter, store.AuditEntityOrder, strconv.FormatInt(order, 10),
+		before, store.AuditOrderState{Status: before.Status, Attempts: before.Attempts + 1, LastError: reason}))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *Database) GetDeadLetterOrders(ctx context.Context) ([]models.DeadLetterOrder, error) {
+	var order models.DeadLetterOrder
+	var orders []models.DeadLetterOrder
+	rows, err := db.Conn.Query(ctx,
+		`SELECT o.number, u.login, o.status, o.attempts, COALESCE(o.last_error, ''), o.uploaded_at, o.dead_lettered_at
+		FROM orders o JOIN users u ON u.id = o.user_id
+		WHERE o.dead_lettered_at IS NOT NULL
+		ORDER BY o.dead_lettered_at, o.number`)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return orders, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var number int64
+		err = rows.Scan(&number, &order.Login, &order.Status, &order.Attempts, &order.LastError, &order.UploadedAt, &order.DeadLetteredAt)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return orders, err
+		}
+		order.Number = strconv.FormatInt(number, 10)
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+func (db *Database) RequeueOrder(ctx context.Context, order int64) error {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var before store.AuditOrderState
+	err = tx.QueryRow(ctx,
+		`SELECT status, attempts, COALESCE(last_error, '') FROM orders WHERE number = $1 AND dead_lettered_at IS NOT NULL FOR UPDATE`,
+		order).Scan(&before.Status, &before.Attempts, &before.LastError)
+	if err == pgx.ErrNoRows {
+		return store.ErrOrderNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE orders SET attempts = 0, next_attempt_at = NULL, last_error = NULL, dead_lettered_at = NULL, requeued_at = now()
+		WHERE number = $1`, order)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось вернуть заказ в очередь", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, "", store.AuditOrderRequeue, store.AuditEntityOrder, strconv.FormatInt(order, 10),
+		before, store.AuditOrderState{Status: before.Status}))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *Database) SaveAccrualCallback(ctx context.Context, signature string, expiresAt time.Time) error {
+	_, err := db.Conn.Exec(ctx, `DELETE FROM accrual_callbacks WHERE expires_at < now()`)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить устаревшие подписи уведомлений", zap.Error(err))
+		return err
+	}
+	tag, err := db.Conn.Exec(ctx,
+		`INSERT INTO accrual_callbacks (signature, expires_at) VALUES ($1, $2) ON CONFLICT (signature) DO NOTHING`,
+		signature, expiresAt)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сохранить подпись уведомления", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrCallbackReplayed
+	}
+	return nil
+}
+
 func (db *Database) UpdateStatusOrders(ctx context.Context, statusOrder *models.StatusOrdersAccrual) error {
 	number, err := strconv.ParseInt(statusOrder.Order, 10, 64)
 	if err != nil {
 		return err
 	}
-	_, err = db.Conn.Exec(ctx, `UPDATE orders SET status = $1, accrual = $2 WHERE number = $3`, statusOrder.Status, statusOrder.Accrual, number)
+
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// блокируем заказ, чтобы параллельные ответы системы расчёта применялись по очереди
+	var userID int64
+	var current string
+	err = tx.QueryRow(ctx, `SELECT user_id, status FROM orders WHERE number = $1 FOR UPDATE`, number).Scan(&userID, &current)
+	if err == pgx.ErrNoRows {
+		return store.ErrOrderNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	status, update, credit := store.OrderStatusTransition(current, statusOrder.Status)
+	if !update {
+		return nil
+	}
+
+	var accrual *models.Money
+	if credit {
+		accrual = &statusOrder.Accrual
+	}
+	_, err = tx.Exec(ctx, `UPDATE orders SET status = $1, accrual = $2 WHERE number = $3 AND status = $4`, status, accrual, number, current)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось обновить статус заказа", zap.Error(err))
+		return err
+	}
+
+	if credit && statusOrder.Accrual > 0 {
+		err = postLedger(ctx, tx, userID, store.NewAccrualPosting(number, statusOrder.Accrual))
+		if err != nil {
+			logger.FromContext(ctx).Warn("Не удалось обновить баланс", zap.Error(err))
+			return err
+		}
+	}
+
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, "", store.AuditOrderStatus, store.AuditEntityOrder, statusOrder.Order,
+		store.AuditOrderState{Status: current}, store.AuditOrderState{Status: status, Accrual: accrual}))
 	if err != nil {
-		logger.Logger.Warn("Не удалось обновить баланс", zap.Error(err))
 		return err
 	}
 
-	_, err = db.Conn.Exec(ctx, `UPDATE users SET sum = sum + $1 WHERE id = (SELECT user_id FROM orders WHERE number = $2)`, statusOrder.Accrual, number)
+	err = tx.Commit(ctx)
 	if err != nil {
-		logger.Logger.Warn("Не удалось обновить баланс", zap.Error(err))
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
 		return err
 	}
+	if credit {
+		metrics.PointsCredited.Add(statusOrder.Accrual.Float64())
+	}
 
 	return nil
 }
+
+func (db *Database) RefundWithdrawal(ctx context.Context, login string, order string) error {
+	number, err := strconv.ParseInt(order, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	var sum models.Money
+	var refundedAt *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT w.user_id, w.sum, w.refunded_at FROM withdrawals w JOIN users u ON u.id = w.user_id
+		WHERE w.number = $1 AND u.login = $2 FOR UPDATE OF w`, number, login).Scan(&userID, &sum, &refundedAt)
+	if err == pgx.ErrNoRows {
+		return store.ErrOrderNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	if refundedAt != nil {
+		return store.ErrAlreadyRefunded
+	}
+
+	balance, err := userPoints(ctx, tx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	err = postLedger(ctx, tx, userID, store.NewRefundPosting(number, sum))
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось вернуть баллы", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE withdrawals SET refunded_at = $1 WHERE number = $2`, time.Now(), number)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось вернуть баллы", zap.Error(err))
+		return err
+	}
+
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditWithdrawalRefund, store.AuditEntityWithdrawal, order,
+		store.AuditBalanceState{Current: balance}, store.AuditBalanceState{Current: balance + sum, Sum: &sum}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (db *Database) AdjustUserBalance(ctx context.Context, login string, amount models.Money, reason string) error {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	err = tx.QueryRow(ctx, `SELECT id FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	balance, err := userPoints(ctx, tx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	if balance+amount < 0 {
+		return store.ErrInsufficientFunds
+	}
+
+	err = postLedger(ctx, tx, userID, store.NewAdjustmentPosting(amount, reason))
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось скорректировать баланс", zap.Error(err))
+		return err
+	}
+
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditBalanceAdjust, store.AuditEntityUser, login,
+		store.AuditBalanceState{Current: balance}, store.AuditBalanceState{Current: balance + amount, Sum: &amount, Reason: reason}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (db *Database) GetUserLedger(ctx context.Context, login string) ([]models.LedgerEntry, error) {
+	var entry models.LedgerEntry
+	var entries []models.LedgerEntry
+	rows, err := db.Conn.Query(ctx,
+		`SELECT t.id, t.kind, e.account, e.amount, t.order_number, t.reason, t.created_at
+		FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id
+		WHERE e.user_id = (SELECT id FROM users WHERE login = $1)
+		ORDER BY t.id, e.id`, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return entries, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var order *int64
+		var reason *string
+		err = rows.Scan(&entry.TransactionID, &entry.Kind, &entry.Account, &entry.Amount, &order, &reason, &entry.CreatedAt)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return entries, err
+		}
+		entry.Order, entry.Reason = "", ""
+		if order != nil {
+			entry.Order = strconv.FormatInt(*order, 10)
+		}
+		if reason != nil {
+			entry.Reason = *reason
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (db *Database) CreateRefreshToken(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error {
+	_, err := db.Conn.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at < now()`)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить истёкшие токены обновления", zap.Error(err))
+		return err
+	}
+
+	tag, err := db.Conn.Exec(ctx,
+		`INSERT INTO refresh_tokens (token_hash, family, user_id, expires_at)
+		SELECT $1::text, $1::text, id, $2::timestamptz FROM users WHERE login = $3`, tokenHash, expiresAt, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сохранить токен обновления", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrUserNotFound
+	}
+	return nil
+}
+
+func (db *Database) RotateRefreshToken(ctx context.Context, tokenHash string, newHash string, expiresAt time.Time) (string, error) {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	// блокируем токен, чтобы из двух одновременных обновлений одним токеном прошло только первое
+	var login, family string
+	var userID int64
+	var expired, used, revoked bool
+	err = tx.QueryRow(ctx,
+		`SELECT u.login, t.user_id, t.family, t.expires_at < now(), t.used_at IS NOT NULL, t.revoked_at IS NOT NULL
+		FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 FOR UPDATE OF t`, tokenHash).Scan(&login, &userID, &family, &expired, &used, &revoked)
+	if err == pgx.ErrNoRows {
+		return "", store.ErrRefreshTokenInvalid
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return "", err
+	}
+
+	switch {
+	case revoked || expired:
+		return "", store.ErrRefreshTokenInvalid
+	case used:
+		// заменённый токен предъявлен повторно: он мог быть украден, поэтому отзываем всю цепочку
+		_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = now() WHERE family = $1 AND revoked_at IS NULL`, family)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Не удалось отозвать цепочку токенов обновления", zap.Error(err))
+			return "", err
+		}
+		err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditRefreshTokenReuse, store.AuditEntityUser, login, nil, nil))
+		if err != nil {
+			return "", err
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return "", err
+		}
+		return "", store.ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return "", err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO refresh_tokens (token_hash, family, user_id, expires_at) VALUES ($1, $2, $3, $4)`,
+		newHash, family, userID, expiresAt)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сохранить токен обновления", zap.Error(err))
+		return "", err
+	}
+
+	return login, tx.Commit(ctx)
+}
+
+func (db *Database) RevokeRefreshToken(ctx context.Context, login string, tokenHash string) error {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now()
+		WHERE revoked_at IS NULL AND family = (
+			SELECT t.family FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+			WHERE t.token_hash = $1 AND u.login = $2)`, tokenHash, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отозвать токен обновления", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrRefreshTokenInvalid
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditRefreshTokenRevoke, store.AuditEntityUser, login, nil, nil))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *Database) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM revoked_access_tokens WHERE expires_at < now()`)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить истёкшие отозванные токены", zap.Error(err))
+		return err
+	}
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO revoked_access_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`, jti, expiresAt)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отозвать токен доступа", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		err = audit(ctx, tx, store.NewAuditEvent(ctx, "", store.AuditAccessTokenRevoke, store.AuditEntityAccessToken, jti, nil, nil))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *Database) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := db.Conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1)`, jti).Scan(&revoked)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return false, err
+	}
+	return revoked, nil
+}
+
+// loginAttemptLockClass первая половина ключа рекомендательной блокировки, под которой учитываются
+// попытки входа по одному ключу, вторая — hashtext ключа
+const loginAttemptLockClass int32 = 1736629481
+
+func (db *Database) ReserveLoginAttempt(ctx context.Context, key string, at time.Time, window time.Duration, limit int) (bool, time.Time, error) {
+	_, err := db.Conn.Exec(ctx, `DELETE FROM login_failures WHERE attempted_at < $1`, at.Add(-window))
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить устаревшие попытки входа", zap.Error(err))
+		return false, time.Time{}, err
+	}
+
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// без блокировки параллельные попытки одного ключа увидели бы одно и то же число попыток и вместе превысили limit
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`, loginAttemptLockClass, key)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось заблокировать попытки входа", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	var count int
+	var oldest *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT COUNT(*), MIN(attempted_at) FROM login_failures WHERE key = $1 AND attempted_at > $2`,
+		key, at.Add(-window)).Scan(&count, &oldest)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	if count >= limit && oldest != nil {
+		return false, *oldest, nil
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO login_failures (key, attempted_at) VALUES ($1, $2)`, key, at)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось учесть попытку входа", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	return true, time.Time{}, nil
+}
+
+func (db *Database) ReleaseLoginAttempt(ctx context.Context, key string, at time.Time) error {
+	// попытки одного ключа в один момент неразличимы, удаляется одна из них
+	_, err := db.Conn.Exec(ctx,
+		`DELETE FROM login_failures WHERE ctid = (SELECT ctid FROM login_failures WHERE key = $1 AND attempted_at = $2 LIMIT 1)`,
+		key, at)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отменить попытку входа", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) RecordFailedLogin(ctx context.Context, login string, maxFailures int, lockout time.Duration) (time.Time, error) {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return time.Time{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var before store.AuditUserState
+	err = tx.QueryRow(ctx, `SELECT failed_logins FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&before.FailedLogins)
+	if err == pgx.ErrNoRows {
+		return time.Time{}, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return time.Time{}, err
+	}
+
+	var lockedUntil *time.Time
+	err = tx.QueryRow(ctx,
+		`UPDATE users SET
+			locked_until = CASE WHEN failed_logins + 1 >= $2 THEN now() + make_interval(secs => $3) ELSE locked_until END,
+			failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END
+		WHERE login = $1
+		RETURNING CASE WHEN failed_logins = 0 THEN locked_until END`,
+		login, maxFailures, lockout.Seconds()).Scan(&lockedUntil)
+	if err == pgx.ErrNoRows {
+		return time.Time{}, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось учесть неудачный вход", zap.Error(err))
+		return time.Time{}, err
+	}
+
+	after := store.AuditUserState{FailedLogins: before.FailedLogins + 1, LockedUntil: lockedUntil}
+	if lockedUntil != nil {
+		after.FailedLogins = 0
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserLoginFailed, store.AuditEntityUser, login, before, after))
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
+		return time.Time{}, err
+	}
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+	return *lockedUntil, nil
+}
+
+func (db *Database) ResetFailedLogins(ctx context.Context, login string) error {
+	_, err := db.Conn.Exec(ctx, `UPDATE users SET failed_logins = 0 WHERE login = $1 AND failed_logins > 0`, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сбросить счётчик неудачных входов", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetUserLockedUntil(ctx context.Context, login string) (time.Time, error) {
+	var lockedUntil *time.Time
+	err := db.Conn.QueryRow(ctx, `SELECT locked_until FROM users WHERE login = $1`, login).Scan(&lockedUntil)
+	if err == pgx.ErrNoRows {
+		return time.Time{}, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return time.Time{}, err
+	}
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+	return *lockedUntil, nil
+}
+
+func (db *Database) UnlockUser(ctx context.Context, login string) error {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var before store.AuditUserState
+	err = tx.QueryRow(ctx, `SELECT failed_logins, locked_until FROM users WHERE login = $1 FOR UPDATE`, login).
+		Scan(&before.FailedLogins, &before.LockedUntil)
+	if err == pgx.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE login = $1`, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось разблокировать пользователя", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserUnlock, store.AuditEntityUser, login,
+		before, store.AuditUserState{}))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// setPassword заменяет пароль пользователя и отзывает его токены обновления, чтобы завершить другие сеансы
+func setPassword(ctx context.Context, q querier, userID int64, hashedPassword []byte) error {
+	_, err := q.Exec(ctx, `UPDATE users SET password = $1 WHERE id = $2`, string(hashedPassword), userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось заменить пароль", zap.Error(err))
+		return err
+	}
+	_, err = q.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отозвать токены обновления", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) ChangePassword(ctx context.Context, login string, password string) error {
+	hashedPassword, err := store.HashPassword(ctx, password)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка при хешировании пароля ", zap.Error(err))
+		return err
+	}
+
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	err = tx.QueryRow(ctx, `SELECT id FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	err = setPassword(ctx, tx, userID, hashedPassword)
+	if err != nil {
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserPasswordChange, store.AuditEntityUser, login, nil, nil))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *Database) CreatePasswordReset(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	err = tx.QueryRow(ctx, `SELECT id FROM users WHERE login = $1`, login).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM password_resets WHERE user_id = $1 OR expires_at < now()`, userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить прежние токены сброса пароля", zap.Error(err))
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`, tokenHash, userID, expiresAt)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сохранить токен сброса пароля", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserResetRequest, store.AuditEntityUser, login, nil, nil))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *Database) ResetPassword(ctx context.Context, tokenHash string, password string) (string, error) {
+	hashedPassword, err := store.HashPassword(ctx, password)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка при хешировании пароля ", zap.Error(err))
+		return "", err
+	}
+
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	// отмечаем токен использованным в той же транзакции, чтобы из двух одновременных сбросов прошёл только один
+	var login string
+	var userID int64
+	err = tx.QueryRow(ctx,
+		`UPDATE password_resets r SET used_at = now()
+		FROM users u
+		WHERE u.id = r.user_id AND r.token_hash = $1 AND r.used_at IS NULL AND r.expires_at > now()
+		RETURNING u.login, u.id`, tokenHash).Scan(&login, &userID)
+	if err == pgx.ErrNoRows {
+		return "", store.ErrResetTokenInvalid
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return "", err
+	}
+
+	err = setPassword(ctx, tx, userID, hashedPassword)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(ctx, `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1`, userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось разблокировать пользователя", zap.Error(err))
+		return "", err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserPasswordReset, store.AuditEntityUser, login, nil, nil))
+	if err != nil {
+		return "", err
+	}
+	return login, tx.Commit(ctx)
+}
+
+func (db *Database) GetUser(ctx context.Context, login string) (models.UserInfo, error) {
+	var user models.UserInfo
+	var registeredAt *time.Time
+	err := db.Conn.QueryRow(ctx,
+		`SELECT login, role, registered_at, CASE WHEN locked_until > now() THEN locked_until END FROM users WHERE login = $1`,
+		login).Scan(&user.Login, &user.Role, &registeredAt, &user.LockedUntil)
+	if err == pgx.ErrNoRows {
+		return models.UserInfo{}, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return models.UserInfo{}, err
+	}
+	if registeredAt != nil {
+		user.RegisteredAt = *registeredAt
+	}
+	return user, nil
+}
+
+func (db *Database) SetUserRole(ctx context.Context, login string, role string) error {
+	tx, err := db.Conn.Begin(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var before store.AuditUserState
+	err = tx.QueryRow(ctx, `SELECT role FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&before.Role)
+	if err == pgx.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET role = $1 WHERE login = $2`, role, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось назначить роль", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserRoleChange, store.AuditEntityUser, login,
+		before, store.AuditUserState{Role: role}))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *Database) GetAuditEvents(ctx context.Context, filter store.AuditFilter) ([]models.AuditEvent, error) {
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+	rows, err := db.Conn.Query(ctx,
+		`SELECT id, actor, action, entity_type, entity_id, before::text, after::text, COALESCE(request_id, ''), created_at
+		FROM audit_events
+		WHERE ($1::text = '' OR actor = $1) AND ($2::text = '' OR action = $2)
+			AND ($3::text = '' OR entity_type = $3) AND ($4::text = '' OR entity_id = $4)
+			AND ($5::bigint = 0 OR id < $5)
+		ORDER BY id DESC LIMIT $6`,
+		filter.Actor, filter.Action, filter.EntityType, filter.EntityID, filter.BeforeID, limit)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		var before, after *string
+		err = rows.Scan(&event.ID, &event.Actor, &event.Action, &event.EntityType, &event.EntityID,
+			&before, &after, &event.RequestID, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if before != nil {
+			event.Before = json.RawMessage(*before)
+		}
+		if after != nil {
+			event.After = json.RawMessage(*after)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
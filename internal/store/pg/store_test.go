@@ -0,0 +1,29 @@
+package pg
+
+import (
+	"os"
+	"testing"
+
+	"gophermart/internal/logger"
+	"gophermart/internal/store"
+	"gophermart/internal/store/storetest"
+)
+
+// newTestDatabase подключается к базе из TEST_DATABASE_URI, без неё тесты пропускаются
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI не задан")
+	}
+	logger.Init()
+	db := NewDatabase(uri)
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestStorage(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.StorageInterface {
+		return newTestDatabase(t)
+	})
+}
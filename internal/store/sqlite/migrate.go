@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"gophermart/internal/auth"
+	"gophermart/internal/store/migrate"
+
+	"modernc.org/sqlite"
+)
+
+// встроенная lower в SQLite меняет регистр только латиницы, поэтому миграции приводят логины
+// функцией normalize_login — той же auth.NormalizeLogin, что и обработчики
+func init() {
+	err := sqlite.RegisterDeterministicScalarFunction("normalize_login", 1,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			login, ok := args[0].(string)
+			if !ok {
+				return args[0], nil
+			}
+			return auth.NormalizeLogin(login), nil
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// migrationDriver выполняет миграции SQLite. Отдельная блокировка не нужна:
+// база открыта с одним соединением, а запись в файл сериализует сама SQLite
+type migrationDriver struct {
+	db *Database
+}
+
+func (d *migrationDriver) Lock(ctx context.Context) error {
+	return nil
+}
+
+func (d *migrationDriver) Unlock(ctx context.Context) error {
+	return nil
+}
+
+func (d *migrationDriver) EnsureVersionTable(ctx context.Context) error {
+	_, err := d.db.Conn.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamp NOT NULL
+		)`)
+	return err
+}
+
+func (d *migrationDriver) AppliedVersions(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := d.db.Conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (d *migrationDriver) Apply(ctx context.Context, migration migrate.Migration, up bool) error {
+	tx, err := d.db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script := migration.Down
+	if up {
+		script = migration.Up
+	}
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`, migration.Version, migration.Name, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Migrator возвращает управление миграциями схемы этой базы данных
+func (db *Database) Migrator() (*migrate.Migrator, error) {
+	migrations, err := migrate.Load(migrate.SQLite)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(&migrationDriver{db: db}, migrations), nil
+}
+
+// Migrations применяет неприменённые миграции и отказывается работать со схемой новее приложения
+func (db *Database) Migrations(ctx context.Context) error {
+	migrator, err := db.Migrator()
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(ctx)
+	return err
+}
@@ -0,0 +1,1433 @@
+// Package sqlite хранилище на SQLite для установки на одном узле без PostgreSQL.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
+	"gophermart/internal/models"
+	"gophermart/internal/store"
+
+	"go.uber.org/zap"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Scheme схема DATABASE_URI, по которой выбирается это хранилище: sqlite:///var/lib/gophermart.db
+const Scheme = "sqlite"
+
+type Database struct {
+	Conn *sql.DB
+}
+
+// querier общий набор методов базы данных и транзакции
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func NewDatabase(uri string) *Database {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db, err := Connect(ctx, uri)
+	if err != nil {
+		logger.Logger.Panic("Не удалось подключиться к базе данных", zap.Error(err))
+		return nil
+	}
+	err = db.Migrations(ctx)
+	if err != nil {
+		logger.Logger.Panic("Не удалось применить миграции", zap.Error(err))
+		return nil
+	}
+	return db
+}
+
+// Connect открывает файл базы данных без применения миграций
+func Connect(ctx context.Context, uri string) (*Database, error) {
+	dsn, err := dataSourceName(uri)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite допускает одного писателя, поэтому все запросы идут через одно соединение:
+	// транзакции выполняются по очереди, что заменяет блокировки строк PostgreSQL
+	conn.SetMaxOpenConns(1)
+
+	err = conn.PingContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Database{Conn: conn}, nil
+}
+
+// dataSourceName превращает sqlite:///путь/к/файлу.db?параметры в строку подключения драйвера
+func dataSourceName(uri string) (string, error) {
+	path, ok := strings.CutPrefix(uri, Scheme+"://")
+	if !ok {
+		return "", errors.New("sqlite uri must start with sqlite://")
+	}
+	path, rawQuery, _ := strings.Cut(path, "?")
+	if path == "" {
+		return "", errors.New("sqlite uri must contain a database file path")
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", err
+	}
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "busy_timeout(5000)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	return "file:" + path + "?" + query.Encode(), nil
+}
+
+func (db *Database) Close() {
+	db.Conn.Close()
+}
+
+// audit записывает событие в журнал аудита, q должен быть транзакцией, в которой выполняется само изменение
+func audit(ctx context.Context, q querier, event models.AuditEvent) error {
+	_, err := q.ExecContext(ctx,
+		`INSERT INTO audit_events (actor, action, entity_type, entity_id, before, after, request_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`,
+		event.Actor, event.Action, event.EntityType, event.EntityID, auditJSON(event.Before), auditJSON(event.After),
+		event.RequestID, time.Now().UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось записать событие в журнал аудита", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// auditJSON возвращает состояние сущности для записи в столбец text, nil — NULL
+func auditJSON(state json.RawMessage) *string {
+	if len(state) == 0 {
+		return nil
+	}
+	value := string(state)
+	return &value
+}
+
+func (db *Database) Ping(ctx context.Context) bool {
+	if err := db.Conn.PingContext(ctx); err != nil {
+		return false
+	}
+	return true
+}
+
+// isConstraintViolation сообщает, что запрос нарушил ограничение уникальности
+func isConstraintViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func (db *Database) UserRegister(ctx context.Context, login string, password string) error {
+	var countRow int64
+	err := db.Conn.QueryRowContext(ctx, `SELECT COUNT(login) FROM users WHERE login = ?`, login).Scan(&countRow)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	if countRow != 0 {
+		logger.FromContext(ctx).Warn("Пользователь существует")
+		return store.ErrLoginDuplicate
+	}
+
+	hashedPassword, err := store.HashPassword(ctx, password)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка при хешировании пароля ", zap.Error(err))
+		return err
+	}
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO users (login, password, registered_at) VALUES (?, ?, ?)`, login, string(hashedPassword), time.Now())
+	if isConstraintViolation(err) {
+		// логин заняли параллельной регистрацией после проверки выше
+		logger.FromContext(ctx).Warn("Пользователь существует")
+		return store.ErrLoginDuplicate
+	}
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось добавить пользователя ", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserRegister, store.AuditEntityUser, login,
+		nil, store.AuditUserState{Role: models.RoleUser}))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
+		return err
+	}
+	logger.FromContext(ctx).Info("Добавлен новый пользователь")
+	return nil
+}
+
+func (db *Database) UserLogin(ctx context.Context, login string, password string) error {
+	var hashedPassword []byte
+
+	err := db.Conn.QueryRowContext(ctx, `SELECT password FROM users WHERE login = ?`, login).Scan(&hashedPassword)
+	if err == sql.ErrNoRows {
+		return store.ErrAuthentication
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	err = store.ComparePassword(ctx, hashedPassword, password)
+	if err != nil {
+		return store.ErrAuthentication
+	}
+	return audit(ctx, db.Conn, store.NewAuditEvent(ctx, login, store.AuditUserLogin, store.AuditEntityUser, login, nil, nil))
+}
+
+func (db *Database) UploadUserOrders(ctx context.Context, login string, order int64) error {
+	var idUser int64
+	err := db.Conn.QueryRowContext(ctx, `SELECT id FROM users WHERE login = ?`, login).Scan(&idUser)
+	if err == sql.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса id", zap.Error(err))
+		return err
+	}
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	err = uploadOrder(ctx, tx, idUser, order)
+	if err != nil {
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditOrderUpload, store.AuditEntityOrder, strconv.FormatInt(order, 10),
+		nil, store.AuditOrderState{Status: models.OrderStatusNew}))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
+		return err
+	}
+	logger.FromContext(ctx).Info("Добавлен новый заказ")
+	return nil
+}
+
+// uploadOrder добавляет заказ пользователю, q может быть как базой данных, так и транзакцией.
+func uploadOrder(ctx context.Context, q querier, idUser int64, order int64) error {
+	var countUser int
+	err := q.QueryRowContext(ctx, `SELECT COUNT(user_id) FROM orders WHERE number = ? AND user_id <> ?`, order, idUser).Scan(&countUser)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса user id", zap.Error(err))
+		return err
+	}
+
+	if countUser > 0 {
+		logger.FromContext(ctx).Warn("Этот заказ добавлен для другого пользователя")
+		return store.ErrDuplicateOrderOtherUser
+	}
+
+	_, err = q.ExecContext(ctx,
+		`INSERT INTO orders (number, user_id, status, uploaded_at) VALUES (?, ?, ?, ?)`, order, idUser, models.OrderStatusNew, time.Now())
+	if err != nil {
+		if isConstraintViolation(err) {
+			logger.FromContext(ctx).Warn("Дубликат заказа")
+			return store.ErrDuplicateOrder
+		}
+		logger.FromContext(ctx).Warn("Не удалось добавить заказ ", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetUserOrders(ctx context.Context, login string) ([]models.StatusOrders, error) {
+	var orderUser models.StatusOrders
+	var ordersUser []models.StatusOrders
+	rows, err := db.Conn.QueryContext(ctx, `SELECT number,status,accrual,uploaded_at FROM orders WHERE user_id = (SELECT id FROM users WHERE login = ?) ORDER BY uploaded_at DESC, rowid DESC`, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return ordersUser, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var accrual sql.NullInt64
+		err = rows.Scan(&orderUser.Number, &orderUser.Status, &accrual, &orderUser.UploadedAt)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return ordersUser, err
+		}
+		orderUser.Accrual = models.MoneyFromKopecks(accrual.Int64)
+		ordersUser = append(ordersUser, orderUser)
+	}
+
+	return ordersUser, rows.Err()
+}
+
+func (db *Database) GetUserBalance(ctx context.Context, login string) (models.Balance, error) {
+	var current, withdrawn int64
+	err := db.Conn.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(CASE WHEN e.account = ? THEN e.amount END), 0),
+			COALESCE(SUM(CASE WHEN e.account = ? THEN e.amount END), 0)
+		FROM users u LEFT JOIN ledger_entries e ON e.user_id = u.id
+		WHERE u.login = ?`,
+		store.AccountUserPoints, store.AccountWithdrawals, login).Scan(&current, &withdrawn)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return models.Balance{}, err
+	}
+	return models.Balance{Current: models.MoneyFromKopecks(current), Withdrawn: models.MoneyFromKopecks(withdrawn)}, nil
+}
+
+func (db *Database) UpdateUserBalanceWithdraw(ctx context.Context, login string, order string, sum models.Money) error {
+	number, err := strconv.ParseInt(order, 10, 64)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось добавмить значение", zap.Error(err))
+		return err
+	}
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, err := userID(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+
+	balance, err := userPoints(ctx, tx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	if balance < sum {
+		logger.FromContext(ctx).Warn("на счету недостаточно средств")
+		return store.ErrInsufficientFunds
+	}
+
+	err = uploadOrder(ctx, tx, userID, number)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось добавить значение", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO withdrawals (number, user_id, sum, processed_at) VALUES (?, ?, ?, ?)`, number, userID, sum.Kopecks(), time.Now())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось добавить значение", zap.Error(err))
+		return err
+	}
+
+	err = postLedger(ctx, tx, userID, store.NewWithdrawalPosting(number, sum))
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось обновить баланс", zap.Error(err))
+		return err
+	}
+
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditWithdrawal, store.AuditEntityWithdrawal, order,
+		store.AuditBalanceState{Current: balance}, store.AuditBalanceState{Current: balance - sum, Sum: &sum}))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
+		return err
+	}
+	metrics.PointsWithdrawn.Add(sum.Float64())
+
+	return nil
+}
+
+func (db *Database) GetUserWithdrawals(ctx context.Context, login string) ([]models.BalanceWithdrawals, error) {
+	var withdrawalUser models.BalanceWithdrawals
+	var withdrawalsUser []models.BalanceWithdrawals
+	rows, err := db.Conn.QueryContext(ctx, `SELECT number,sum,processed_at FROM withdrawals WHERE user_id = (SELECT id FROM users WHERE login = ?) ORDER BY processed_at DESC, rowid DESC`, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return withdrawalsUser, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var sum int64
+		err = rows.Scan(&withdrawalUser.Order, &sum, &withdrawalUser.ProcessedAt)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return withdrawalsUser, err
+		}
+		withdrawalUser.Sum = models.MoneyFromKopecks(sum)
+		withdrawalsUser = append(withdrawalsUser, withdrawalUser)
+	}
+	return withdrawalsUser, rows.Err()
+}
+
+func (db *Database) GetOrdersProcessing(ctx context.Context) ([]int64, error) {
+	var orderUser int64
+	var ordersUser []int64
+	rows, err := db.Conn.QueryContext(ctx,
+		`SELECT number FROM orders
+		WHERE (status = ? OR status = ?) AND dead_lettered_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+		ORDER BY uploaded_at DESC`, models.OrderStatusNew, models.OrderStatusProcessing, time.Now().UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return ordersUser, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		err = rows.Scan(&orderUser)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return ordersUser, err
+		}
+
+		ordersUser = append(ordersUser, orderUser)
+	}
+
+	return ordersUser, rows.Err()
+}
+
+func (db *Database) CountPendingOrders(ctx context.Context) (int, error) {
+	var count int
+	err := db.Conn.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM orders WHERE status IN (?, ?) AND dead_lettered_at IS NULL`,
+		models.OrderStatusNew, models.OrderStatusProcessing).Scan(&count)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
+
+func (db *Database) ClaimOrders(ctx context.Context, worker string, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	var job models.AccrualJob
+	var jobs []models.AccrualJob
+	// запросы к SQLite идут через одно соединение, поэтому выборка и аренда не пересекаются с другими воркерами
+	now := time.Now()
+	rows, err := db.Conn.QueryContext(ctx,
+		`UPDATE orders SET locked_by = ?, locked_until = ?
+		WHERE number IN (
+			SELECT number FROM orders
+			WHERE status IN (?, ?) AND dead_lettered_at IS NULL
+				AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+				AND (locked_until IS NULL OR locked_until < ?)
+			ORDER BY uploaded_at, rowid
+			LIMIT ?
+		)
+		RETURNING number, attempts, uploaded_at, requeued_at`,
+		worker, now.Add(lease).UnixMilli(), models.OrderStatusNew, models.OrderStatusProcessing, now.UnixMilli(), now.UnixMilli(), limit)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return jobs, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var requeuedAt sql.NullTime
+		err = rows.Scan(&job.Number, &job.Attempts, &job.QueuedAt, &requeuedAt)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return jobs, err
+		}
+		if requeuedAt.Valid {
+			job.QueuedAt = requeuedAt.Time
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+func (db *Database) RenewOrderLease(ctx context.Context, worker string, order int64, lease time.Duration) error {
+	now := time.Now()
+	result, err := db.Conn.ExecContext(ctx,
+		`UPDATE orders SET locked_until = ? WHERE number = ? AND locked_by = ? AND locked_until >= ?`,
+		now.Add(lease).UnixMilli(), order, worker, now.UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось продлить аренду заказа", zap.Error(err))
+		return err
+	}
+	renewed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if renewed == 0 {
+		return store.ErrLeaseLost
+	}
+	return nil
+}
+
+func (db *Database) ReleaseOrder(ctx context.Context, worker string, order int64) error {
+	_, err := db.Conn.ExecContext(ctx, `UPDATE orders SET locked_by = NULL, locked_until = NULL WHERE number = ? AND locked_by = ?`, order, worker)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось снять аренду заказа", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) RetryOrder(ctx context.Context, worker string, order int64, nextAttemptAt time.Time, reason string) error {
+	_, err := db.Conn.ExecContext(ctx,
+		`UPDATE orders SET attempts = attempts + 1, next_attempt_at = ?, last_error = ?, locked_by = NULL, locked_until = NULL
+		WHERE number = ? AND locked_by = ?`, nextAttemptAt.UnixMilli(), reason, order, worker)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отложить опрос заказа", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) DeadLetterOrder(ctx context.Context, worker string, order int64, reason string) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	var before store.AuditOrderState
+	err = tx.QueryRowContext(ctx,
+		`SELECT status, attempts, COALESCE(last_error, '') FROM orders WHERE number = ? AND locked_by = ?`,
+		order, worker).Scan(&before.Status, &before.Attempts, &before.LastError)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE orders SET attempts = attempts + 1, dead_lettered_at = ?, last_error = ?, locked_by = NULL, locked_until = NULL
+		WHERE number = ?`, time.Now(), reason, order)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось перевести заказ в dead-letter", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, "", store.AuditOrderDeadLetter, store.AuditEntityOrder, strconv.FormatInt(order, 10),
+		before, store.AuditOrderState{Status: before.Status, Attempts: before.Attempts + 1, LastError: reason}))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Database) GetDeadLetterOrders(ctx context.Context) ([]models.DeadLetterOrder, error) {
+	var order models.DeadLetterOrder
+	var orders []models.DeadLetterOrder
+	rows, err := db.Conn.QueryContext(ctx,
+		`SELECT o.number, u.login, o.status, o.attempts, o.last_error, o.uploaded_at, o.dead_lettered_at
+		FROM orders o JOIN users u ON u.id = o.user_id
+		WHERE o.dead_lettered_at IS NOT NULL
+		ORDER BY o.dead_lettered_at, o.number`)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return orders, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var number int64
+		var lastError sql.NullString
+		err = rows.Scan(&number, &order.Login, &order.Status, &order.Attempts, &lastError, &order.UploadedAt, &order.DeadLetteredAt)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return orders, err
+		}
+		order.Number = strconv.FormatInt(number, 10)
+		order.LastError = lastError.String
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+func (db *Database) RequeueOrder(ctx context.Context, order int64) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	var before store.AuditOrderState
+	err = tx.QueryRowContext(ctx,
+		`SELECT status, attempts, COALESCE(last_error, '') FROM orders WHERE number = ? AND dead_lettered_at IS NOT NULL`,
+		order).Scan(&before.Status, &before.Attempts, &before.LastError)
+	if err == sql.ErrNoRows {
+		return store.ErrOrderNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE orders SET attempts = 0, next_attempt_at = NULL, last_error = NULL, dead_lettered_at = NULL, requeued_at = ?
+		WHERE number = ?`, time.Now(), order)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось вернуть заказ в очередь", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, "", store.AuditOrderRequeue, store.AuditEntityOrder, strconv.FormatInt(order, 10),
+		before, store.AuditOrderState{Status: before.Status}))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Database) SaveAccrualCallback(ctx context.Context, signature string, expiresAt time.Time) error {
+	_, err := db.Conn.ExecContext(ctx, `DELETE FROM accrual_callbacks WHERE expires_at < ?`, time.Now().UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить устаревшие подписи уведомлений", zap.Error(err))
+		return err
+	}
+	result, err := db.Conn.ExecContext(ctx,
+		`INSERT INTO accrual_callbacks (signature, expires_at) VALUES (?, ?) ON CONFLICT (signature) DO NOTHING`,
+		signature, expiresAt.UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сохранить подпись уведомления", zap.Error(err))
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrCallbackReplayed
+	}
+	return nil
+}
+
+func (db *Database) UpdateStatusOrders(ctx context.Context, statusOrder *models.StatusOrdersAccrual) error {
+	number, err := strconv.ParseInt(statusOrder.Order, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var current string
+	err = tx.QueryRowContext(ctx, `SELECT user_id, status FROM orders WHERE number = ?`, number).Scan(&userID, &current)
+	if err == sql.ErrNoRows {
+		return store.ErrOrderNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	status, update, credit := store.OrderStatusTransition(current, statusOrder.Status)
+	if !update {
+		return nil
+	}
+
+	var accrual *int64
+	if credit {
+		kopecks := statusOrder.Accrual.Kopecks()
+		accrual = &kopecks
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE orders SET status = ?, accrual = ? WHERE number = ? AND status = ?`, status, accrual, number, current)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось обновить статус заказа", zap.Error(err))
+		return err
+	}
+
+	if credit && statusOrder.Accrual > 0 {
+		err = postLedger(ctx, tx, userID, store.NewAccrualPosting(number, statusOrder.Accrual))
+		if err != nil {
+			logger.FromContext(ctx).Warn("Не удалось обновить баланс", zap.Error(err))
+			return err
+		}
+	}
+
+	after := store.AuditOrderState{Status: status}
+	if credit {
+		after.Accrual = &statusOrder.Accrual
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, "", store.AuditOrderStatus, store.AuditEntityOrder, statusOrder.Order,
+		store.AuditOrderState{Status: current}, after))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
+		return err
+	}
+	if credit {
+		metrics.PointsCredited.Add(statusOrder.Accrual.Float64())
+	}
+
+	return nil
+}
+
+func (db *Database) RefundWithdrawal(ctx context.Context, login string, order string) error {
+	number, err := strconv.ParseInt(order, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID, sum int64
+	var refundedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		`SELECT w.user_id, w.sum, w.refunded_at FROM withdrawals w JOIN users u ON u.id = w.user_id
+		WHERE w.number = ? AND u.login = ?`, number, login).Scan(&userID, &sum, &refundedAt)
+	if err == sql.ErrNoRows {
+		return store.ErrOrderNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	if refundedAt.Valid {
+		return store.ErrAlreadyRefunded
+	}
+
+	balance, err := userPoints(ctx, tx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	refund := models.MoneyFromKopecks(sum)
+	err = postLedger(ctx, tx, userID, store.NewRefundPosting(number, refund))
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось вернуть баллы", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE withdrawals SET refunded_at = ? WHERE number = ?`, time.Now(), number)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось вернуть баллы", zap.Error(err))
+		return err
+	}
+
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditWithdrawalRefund, store.AuditEntityWithdrawal, order,
+		store.AuditBalanceState{Current: balance}, store.AuditBalanceState{Current: balance + refund, Sum: &refund}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *Database) AdjustUserBalance(ctx context.Context, login string, amount models.Money, reason string) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, err := userID(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+
+	balance, err := userPoints(ctx, tx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	if balance+amount < 0 {
+		return store.ErrInsufficientFunds
+	}
+
+	err = postLedger(ctx, tx, userID, store.NewAdjustmentPosting(amount, reason))
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось скорректировать баланс", zap.Error(err))
+		return err
+	}
+
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditBalanceAdjust, store.AuditEntityUser, login,
+		store.AuditBalanceState{Current: balance}, store.AuditBalanceState{Current: balance + amount, Sum: &amount, Reason: reason}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *Database) GetUserLedger(ctx context.Context, login string) ([]models.LedgerEntry, error) {
+	var entry models.LedgerEntry
+	var entries []models.LedgerEntry
+	rows, err := db.Conn.QueryContext(ctx,
+		`SELECT t.id, t.kind, e.account, e.amount, t.order_number, t.reason, t.created_at
+		FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id
+		WHERE e.user_id = (SELECT id FROM users WHERE login = ?)
+		ORDER BY t.id, e.id`, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return entries, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var amount int64
+		var order sql.NullInt64
+		var reason sql.NullString
+		err = rows.Scan(&entry.TransactionID, &entry.Kind, &entry.Account, &amount, &order, &reason, &entry.CreatedAt)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Ошибка при сканировании строки:", zap.Error(err))
+			return entries, err
+		}
+		entry.Amount = models.MoneyFromKopecks(amount)
+		entry.Order = ""
+		if order.Valid {
+			entry.Order = strconv.FormatInt(order.Int64, 10)
+		}
+		entry.Reason = reason.String
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// userID возвращает идентификатор пользователя по логину
+func userID(ctx context.Context, q querier, login string) (int64, error) {
+	var id int64
+	err := q.QueryRowContext(ctx, `SELECT id FROM users WHERE login = ?`, login).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return 0, err
+	}
+	return id, nil
+}
+
+// postLedger записывает сбалансированную транзакцию в книгу учёта, q должен быть транзакцией базы данных
+func postLedger(ctx context.Context, q querier, userID int64, posting *store.LedgerPosting) error {
+	err := posting.Validate()
+	if err != nil {
+		return err
+	}
+
+	var order *int64
+	if posting.Order != 0 {
+		order = &posting.Order
+	}
+	var reason *string
+	if posting.Reason != "" {
+		reason = &posting.Reason
+	}
+
+	var transactionID int64
+	err = q.QueryRowContext(ctx,
+		`INSERT INTO ledger_transactions (user_id, kind, order_number, reason, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id`,
+		userID, posting.Kind, order, reason, time.Now()).Scan(&transactionID)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range posting.Entries {
+		_, err = q.ExecContext(ctx,
+			`INSERT INTO ledger_entries (transaction_id, user_id, account, amount) VALUES (?, ?, ?, ?)`,
+			transactionID, userID, entry.Account, entry.Amount.Kopecks())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userPoints возвращает текущий баланс пользователя по книге учёта
+func userPoints(ctx context.Context, q querier, userID int64) (models.Money, error) {
+	var balance int64
+	err := q.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE user_id = ? AND account = ?`,
+		userID, store.AccountUserPoints).Scan(&balance)
+	return models.MoneyFromKopecks(balance), err
+}
+
+func (db *Database) CreateRefreshToken(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error {
+	now := time.Now().UnixMilli()
+	_, err := db.Conn.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < ?`, now)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить истёкшие токены обновления", zap.Error(err))
+		return err
+	}
+
+	result, err := db.Conn.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (token_hash, family, user_id, issued_at, expires_at)
+		SELECT ?, ?, id, ?, ? FROM users WHERE login = ?`, tokenHash, tokenHash, now, expiresAt.UnixMilli(), login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сохранить токен обновления", zap.Error(err))
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrUserNotFound
+	}
+	return nil
+}
+
+func (db *Database) RotateRefreshToken(ctx context.Context, tokenHash string, newHash string, expiresAt time.Time) (string, error) {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	var login, family string
+	var userID int64
+	var expired, used, revoked bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT u.login, t.user_id, t.family, t.expires_at < ?, t.used_at IS NOT NULL, t.revoked_at IS NOT NULL
+		FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?`, now, tokenHash).Scan(&login, &userID, &family, &expired, &used, &revoked)
+	if err == sql.ErrNoRows {
+		return "", store.ErrRefreshTokenInvalid
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return "", err
+	}
+
+	switch {
+	case revoked || expired:
+		return "", store.ErrRefreshTokenInvalid
+	case used:
+		// заменённый токен предъявлен повторно: он мог быть украден, поэтому отзываем всю цепочку
+		_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = ? WHERE family = ? AND revoked_at IS NULL`, now, family)
+		if err != nil {
+			logger.FromContext(ctx).Warn("Не удалось отозвать цепочку токенов обновления", zap.Error(err))
+			return "", err
+		}
+		err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditRefreshTokenReuse, store.AuditEntityUser, login, nil, nil))
+		if err != nil {
+			return "", err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return "", err
+		}
+		return "", store.ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?`, now, tokenHash)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (token_hash, family, user_id, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		newHash, family, userID, now, expiresAt.UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сохранить токен обновления", zap.Error(err))
+		return "", err
+	}
+
+	return login, tx.Commit()
+}
+
+func (db *Database) RevokeRefreshToken(ctx context.Context, login string, tokenHash string) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = ?
+		WHERE revoked_at IS NULL AND family = (
+			SELECT t.family FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+			WHERE t.token_hash = ? AND u.login = ?)`, time.Now().UnixMilli(), tokenHash, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отозвать токен обновления", zap.Error(err))
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return store.ErrRefreshTokenInvalid
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditRefreshTokenRevoke, store.AuditEntityUser, login, nil, nil))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Database) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM revoked_access_tokens WHERE expires_at < ?`, time.Now().UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить истёкшие отозванные токены", zap.Error(err))
+		return err
+	}
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO revoked_access_tokens (jti, expires_at) VALUES (?, ?) ON CONFLICT (jti) DO NOTHING`, jti, expiresAt.UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отозвать токен доступа", zap.Error(err))
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		err = audit(ctx, tx, store.NewAuditEvent(ctx, "", store.AuditAccessTokenRevoke, store.AuditEntityAccessToken, jti, nil, nil))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Database) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := db.Conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = ?)`, jti).Scan(&revoked)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return false, err
+	}
+	return revoked, nil
+}
+
+func (db *Database) ReserveLoginAttempt(ctx context.Context, key string, at time.Time, window time.Duration, limit int) (bool, time.Time, error) {
+	// запросы к SQLite идут через одно соединение, поэтому подсчёт и учёт попытки не пересекаются с другими запросами
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_failures WHERE attempted_at < ?`, at.Add(-window).UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить устаревшие попытки входа", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	var count int
+	var oldest sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(*), MIN(attempted_at) FROM login_failures WHERE key = ? AND attempted_at > ?`,
+		key, at.Add(-window).UnixMilli()).Scan(&count, &oldest)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	if count >= limit && oldest.Valid {
+		return false, time.UnixMilli(oldest.Int64), nil
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO login_failures (key, attempted_at) VALUES (?, ?)`, key, at.UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось учесть попытку входа", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
+		return false, time.Time{}, err
+	}
+	return true, time.Time{}, nil
+}
+
+func (db *Database) ReleaseLoginAttempt(ctx context.Context, key string, at time.Time) error {
+	// попытки одного ключа в одну миллисекунду неразличимы, удаляется одна из них
+	_, err := db.Conn.ExecContext(ctx,
+		`DELETE FROM login_failures WHERE rowid = (SELECT rowid FROM login_failures WHERE key = ? AND attempted_at = ? LIMIT 1)`,
+		key, at.UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отменить попытку входа", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) RecordFailedLogin(ctx context.Context, login string, maxFailures int, lockout time.Duration) (time.Time, error) {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var before store.AuditUserState
+	err = tx.QueryRowContext(ctx, `SELECT failed_logins FROM users WHERE login = ?`, login).Scan(&before.FailedLogins)
+	if err == sql.ErrNoRows {
+		return time.Time{}, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return time.Time{}, err
+	}
+
+	var lockedUntil sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		`UPDATE users SET
+			locked_until = CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END,
+			failed_logins = CASE WHEN failed_logins + 1 >= ? THEN 0 ELSE failed_logins + 1 END
+		WHERE login = ?
+		RETURNING CASE WHEN failed_logins = 0 THEN locked_until END`,
+		maxFailures, time.Now().Add(lockout).UnixMilli(), maxFailures, login).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось учесть неудачный вход", zap.Error(err))
+		return time.Time{}, err
+	}
+
+	after := store.AuditUserState{FailedLogins: before.FailedLogins + 1}
+	var locked time.Time
+	if lockedUntil.Valid {
+		locked = time.UnixMilli(lockedUntil.Int64)
+		after = store.AuditUserState{LockedUntil: &locked}
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserLoginFailed, store.AuditEntityUser, login, before, after))
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось зафиксировать транзакцию", zap.Error(err))
+		return time.Time{}, err
+	}
+	return locked, nil
+}
+
+func (db *Database) ResetFailedLogins(ctx context.Context, login string) error {
+	_, err := db.Conn.ExecContext(ctx, `UPDATE users SET failed_logins = 0 WHERE login = ? AND failed_logins > 0`, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сбросить счётчик неудачных входов", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetUserLockedUntil(ctx context.Context, login string) (time.Time, error) {
+	var lockedUntil sql.NullInt64
+	err := db.Conn.QueryRowContext(ctx, `SELECT locked_until FROM users WHERE login = ?`, login).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return time.Time{}, err
+	}
+	if !lockedUntil.Valid {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(lockedUntil.Int64), nil
+}
+
+func (db *Database) UnlockUser(ctx context.Context, login string) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	var before store.AuditUserState
+	var lockedUntil sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT failed_logins, locked_until FROM users WHERE login = ?`, login).
+		Scan(&before.FailedLogins, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+	if lockedUntil.Valid {
+		locked := time.UnixMilli(lockedUntil.Int64)
+		before.LockedUntil = &locked
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE login = ?`, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось разблокировать пользователя", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserUnlock, store.AuditEntityUser, login,
+		before, store.AuditUserState{}))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setPassword заменяет пароль пользователя и отзывает его токены обновления, чтобы завершить другие сеансы
+func setPassword(ctx context.Context, q querier, userID int64, hashedPassword []byte) error {
+	_, err := q.ExecContext(ctx, `UPDATE users SET password = ? WHERE id = ?`, string(hashedPassword), userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось заменить пароль", zap.Error(err))
+		return err
+	}
+	_, err = q.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, time.Now().UnixMilli(), userID)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось отозвать токены обновления", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db *Database) ChangePassword(ctx context.Context, login string, password string) error {
+	hashedPassword, err := store.HashPassword(ctx, password)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка при хешировании пароля ", zap.Error(err))
+		return err
+	}
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := userID(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+	err = setPassword(ctx, tx, id, hashedPassword)
+	if err != nil {
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserPasswordChange, store.AuditEntityUser, login, nil, nil))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Database) CreatePasswordReset(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := userID(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM password_resets WHERE user_id = ? OR expires_at < ?`, id, time.Now().UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось удалить прежние токены сброса пароля", zap.Error(err))
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?)`, tokenHash, id, expiresAt.UnixMilli())
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось сохранить токен сброса пароля", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserResetRequest, store.AuditEntityUser, login, nil, nil))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Database) ResetPassword(ctx context.Context, tokenHash string, password string) (string, error) {
+	hashedPassword, err := store.HashPassword(ctx, password)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка при хешировании пароля ", zap.Error(err))
+		return "", err
+	}
+
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		`UPDATE password_resets SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id`, now, tokenHash, now).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", store.ErrResetTokenInvalid
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return "", err
+	}
+
+	var login string
+	err = tx.QueryRowContext(ctx, `SELECT login FROM users WHERE id = ?`, id).Scan(&login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return "", err
+	}
+	err = setPassword(ctx, tx, id, hashedPassword)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?`, id)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось разблокировать пользователя", zap.Error(err))
+		return "", err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserPasswordReset, store.AuditEntityUser, login, nil, nil))
+	if err != nil {
+		return "", err
+	}
+	return login, tx.Commit()
+}
+
+func (db *Database) GetUser(ctx context.Context, login string) (models.UserInfo, error) {
+	var user models.UserInfo
+	var registeredAt sql.NullTime
+	var lockedUntil sql.NullInt64
+	err := db.Conn.QueryRowContext(ctx,
+		`SELECT login, role, registered_at, locked_until FROM users WHERE login = ?`,
+		login).Scan(&user.Login, &user.Role, &registeredAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return models.UserInfo{}, store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return models.UserInfo{}, err
+	}
+	user.RegisteredAt = registeredAt.Time
+	if lockedUntil.Valid && lockedUntil.Int64 > time.Now().UnixMilli() {
+		locked := time.UnixMilli(lockedUntil.Int64)
+		user.LockedUntil = &locked
+	}
+	return user, nil
+}
+
+func (db *Database) SetUserRole(ctx context.Context, login string, role string) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось начать транзакцию", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	var before store.AuditUserState
+	err = tx.QueryRowContext(ctx, `SELECT role FROM users WHERE login = ?`, login).Scan(&before.Role)
+	if err == sql.ErrNoRows {
+		return store.ErrUserNotFound
+	} else if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET role = ? WHERE login = ?`, role, login)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Не удалось назначить роль", zap.Error(err))
+		return err
+	}
+	err = audit(ctx, tx, store.NewAuditEvent(ctx, login, store.AuditUserRoleChange, store.AuditEntityUser, login,
+		before, store.AuditUserState{Role: role}))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Database) GetAuditEvents(ctx context.Context, filter store.AuditFilter) ([]models.AuditEvent, error) {
+	// отрицательный LIMIT в SQLite снимает ограничение
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := db.Conn.QueryContext(ctx,
+		`SELECT id, actor, action, entity_type, entity_id, before, after, COALESCE(request_id, ''), created_at
+		FROM audit_events
+		WHERE (?1 = '' OR actor = ?1) AND (?2 = '' OR action = ?2)
+			AND (?3 = '' OR entity_type = ?3) AND (?4 = '' OR entity_id = ?4)
+			AND (?5 = 0 OR id < ?5)
+		ORDER BY id DESC LIMIT ?6`,
+		filter.Actor, filter.Action, filter.EntityType, filter.EntityID, filter.BeforeID, limit)
+	if err != nil {
+		logger.FromContext(ctx).Warn("Ошибка выполнения запроса ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		var before, after sql.NullString
+		var createdAt int64
+		err = rows.Scan(&event.ID, &event.Actor, &event.Action, &event.EntityType, &event.EntityID,
+			&before, &after, &event.RequestID, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			event.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			event.After = json.RawMessage(after.String)
+		}
+		event.CreatedAt = time.UnixMilli(createdAt)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
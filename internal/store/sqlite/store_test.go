@@ -0,0 +1,108 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"gophermart/internal/logger"
+	"gophermart/internal/store"
+	"gophermart/internal/store/storetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDatabase создаёт базу во временном каталоге теста и применяет миграции
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	logger.Init()
+	db := NewDatabase(Scheme + "://" + filepath.Join(t.TempDir(), "gophermart.db"))
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestStorage(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.StorageInterface {
+		return newTestDatabase(t)
+	})
+}
+
+func TestMigrationsDownUp(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	migrator, err := db.Migrator()
+	require.NoError(t, err)
+
+	count, err := migrator.Down(ctx, int(migrator.Latest()))
+	require.NoError(t, err)
+	assert.Equal(t, int(migrator.Latest()), count)
+
+	count, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int(migrator.Latest()), count)
+}
+
+func TestDataSourceName(t *testing.T) {
+	dsn, err := dataSourceName("sqlite:///var/lib/gophermart.db?_txlock=immediate")
+	require.NoError(t, err)
+	assert.Contains(t, dsn, "file:/var/lib/gophermart.db?")
+	assert.Contains(t, dsn, "_txlock=immediate")
+
+	_, err = dataSourceName("sqlite://")
+	assert.Error(t, err)
+	_, err = dataSourceName("postgres://localhost/db")
+	assert.Error(t, err)
+}
+
+func TestAuditEventsAppendOnly(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	require.NoError(t, db.UserRegister(ctx, "audit", "password"))
+
+	_, err := db.Conn.ExecContext(ctx, `UPDATE audit_events SET actor = 'someone'`)
+	assert.ErrorContains(t, err, "append-only")
+	_, err = db.Conn.ExecContext(ctx, `DELETE FROM audit_events`)
+	assert.ErrorContains(t, err, "append-only")
+
+	events, err := db.GetAuditEvents(ctx, store.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "audit", events[0].Actor)
+}
+
+func TestLoginNormalizationMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	migrator, err := db.Migrator()
+	require.NoError(t, err)
+	// откат до схемы перед миграцией 13, которая приводит логины
+	beforeNormalization := int(migrator.Latest()) - 12
+
+	_, err = migrator.Down(ctx, beforeNormalization)
+	require.NoError(t, err)
+	_, err = db.Conn.ExecContext(ctx, `INSERT INTO users (login, password) VALUES (' Иван ', 'x'), ('Petr', 'x')`)
+	require.NoError(t, err)
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+
+	var logins []string
+	rows, err := db.Conn.QueryContext(ctx, `SELECT login FROM users ORDER BY login`)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var login string
+		require.NoError(t, rows.Scan(&login))
+		logins = append(logins, login)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"petr", "иван"}, logins)
+
+	// совпадающие после приведения логины останавливают миграцию
+	_, err = migrator.Down(ctx, beforeNormalization)
+	require.NoError(t, err)
+	_, err = db.Conn.ExecContext(ctx, `INSERT INTO users (login, password) VALUES ('ИВАН', 'x')`)
+	require.NoError(t, err)
+	_, err = migrator.Up(ctx)
+	assert.ErrorContains(t, err, "UNIQUE constraint failed")
+}
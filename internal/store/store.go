@@ -4,21 +4,91 @@ import (
 	"context"
 	"errors"
 	"gophermart/internal/models"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
 )
 
 type StorageInterface interface {
 	UserRegister(ctx context.Context, login string, password string) error
 	UserLogin(ctx context.Context, login string, password string) error
+	// ReserveLoginAttempt учитывает попытку входа по ключу key в момент at, если за окно window до at
+	// по ключу учтено меньше limit попыток, и удаляет попытки всех ключей старше at-window. Подсчёт и учёт
+	// атомарны, поэтому параллельные попытки не превышают limit. Если лимит исчерпан, попытка не учитывается,
+	// а возвращается время самой ранней попытки в окне.
+	ReserveLoginAttempt(ctx context.Context, key string, at time.Time, window time.Duration, limit int) (bool, time.Time, error)
+	// ReleaseLoginAttempt отменяет попытку входа по ключу key, учтённую ReserveLoginAttempt в момент at
+	ReleaseLoginAttempt(ctx context.Context, key string, at time.Time) error
+	// RecordFailedLogin учитывает неудачный вход пользователя login. После maxFailures неудачных входов подряд
+	// пользователь блокируется на lockout, счётчик сбрасывается. Возвращает срок блокировки, если она назначена.
+	RecordFailedLogin(ctx context.Context, login string, maxFailures int, lockout time.Duration) (time.Time, error)
+	// ResetFailedLogins сбрасывает счётчик неудачных входов подряд после успешного входа
+	ResetFailedLogins(ctx context.Context, login string) error
+	// GetUserLockedUntil возвращает срок блокировки пользователя, нулевое время — пользователь не блокировался
+	GetUserLockedUntil(ctx context.Context, login string) (time.Time, error)
+	// UnlockUser снимает блокировку пользователя и сбрасывает счётчик неудачных входов
+	UnlockUser(ctx context.Context, login string) error
+	// ChangePassword заменяет пароль пользователя login и отзывает все его токены обновления
+	ChangePassword(ctx context.Context, login string, password string) error
+	// CreatePasswordReset сохраняет хеш токена сброса пароля пользователя login.
+	// Ранее выданные пользователю токены сброса перестают действовать.
+	CreatePasswordReset(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error
+	// ResetPassword по действующему токену сброса tokenHash заменяет пароль, снимает блокировку,
+	// отзывает токены обновления пользователя и возвращает его логин. Токен действует один раз.
+	ResetPassword(ctx context.Context, tokenHash string, password string) (string, error)
+	// GetUser возвращает сведения о пользователе login
+	GetUser(ctx context.Context, login string) (models.UserInfo, error)
+	SetUserRole(ctx context.Context, login string, role string) error
+	// GetAuditEvents возвращает события журнала аудита, подходящие под filter, новые первыми.
+	// Изменяющие методы хранилища записывают свои события в той же транзакции, что и само изменение.
+	GetAuditEvents(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, error)
 	UploadUserOrders(ctx context.Context, login string, order int64) error
 	GetUserOrders(ctx context.Context, login string) ([]models.StatusOrders, error)
 	GetUserBalance(ctx context.Context, login string) (models.Balance, error)
-	UpdateUserBalanceWithdraw(ctx context.Context, login string, order string, sum float64) error
+	UpdateUserBalanceWithdraw(ctx context.Context, login string, order string, sum models.Money) error
 	GetUserWithdrawals(ctx context.Context, login string) ([]models.BalanceWithdrawals, error)
 	GetOrdersProcessing(ctx context.Context) ([]int64, error)
+	// CountPendingOrders возвращает число заказов, ожидающих расчёта начислений, включая арендованные
+	// и отложенные после неудачного опроса. Заказы в dead-letter не учитываются.
+	CountPendingOrders(ctx context.Context) (int, error)
+	// ClaimOrders арендует для worker до limit заказов в обработке, которые никем не арендованы или чья аренда истекла.
+	// Один заказ одновременно арендован не более чем одним воркером во всём кластере.
+	// Заказы в dead-letter и заказы, чей next_attempt_at ещё не наступил, не арендуются.
+	ClaimOrders(ctx context.Context, worker string, limit int, lease time.Duration) ([]models.AccrualJob, error)
+	// RenewOrderLease продлевает аренду заказа на lease от текущего момента, если она всё ещё принадлежит worker
+	// и не истекла. Иначе возвращает ErrLeaseLost: заказ мог арендовать и опросить другой воркер.
+	RenewOrderLease(ctx context.Context, worker string, order int64, lease time.Duration) error
+	// ReleaseOrder снимает аренду заказа, если она всё ещё принадлежит worker
+	ReleaseOrder(ctx context.Context, worker string, order int64) error
+	// RetryOrder учитывает неудачный опрос и снимает аренду worker, следующая аренда возможна не раньше nextAttemptAt
+	RetryOrder(ctx context.Context, worker string, order int64, nextAttemptAt time.Time, reason string) error
+	// DeadLetterOrder учитывает неудачный опрос и прекращает опрашивать заказ до RequeueOrder
+	DeadLetterOrder(ctx context.Context, worker string, order int64, reason string) error
+	GetDeadLetterOrders(ctx context.Context) ([]models.DeadLetterOrder, error)
+	// RequeueOrder возвращает заказ из dead-letter в очередь со сброшенным счётчиком попыток
+	RequeueOrder(ctx context.Context, order int64) error
+	// SaveAccrualCallback запоминает подпись принятого уведомления системы расчёта до expiresAt.
+	// Повторное уведомление с той же подписью до expiresAt отклоняется с ErrCallbackReplayed.
+	SaveAccrualCallback(ctx context.Context, signature string, expiresAt time.Time) error
 	UpdateStatusOrders(ctx context.Context, statusOrder *models.StatusOrdersAccrual) error
+	// CreateRefreshToken сохраняет хеш токена обновления пользователя login, с которого начинается новая цепочка ротации
+	CreateRefreshToken(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error
+	// RotateRefreshToken заменяет действующий токен обновления tokenHash на newHash той же цепочки и возвращает логин.
+	// Повторное использование уже заменённого токена отзывает всю цепочку и возвращает ErrRefreshTokenReused.
+	RotateRefreshToken(ctx context.Context, tokenHash string, newHash string, expiresAt time.Time) (string, error)
+	// RevokeRefreshToken отзывает цепочку токена обновления tokenHash пользователя login
+	RevokeRefreshToken(ctx context.Context, login string, tokenHash string) error
+	// RevokeAccessToken отзывает токен доступа jti до истечения его срока действия expiresAt
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+	RefundWithdrawal(ctx context.Context, login string, order string) error
+	AdjustUserBalance(ctx context.Context, login string, amount models.Money, reason string) error
+	GetUserLedger(ctx context.Context, login string) ([]models.LedgerEntry, error)
 	Ping(ctx context.Context) bool
 }
 
+// StorageContext хранилище, через которое работают обработчики и воркеры.
+// Каждый вызов ведёт span store.<метод>, в который вкладываются span'ы запросов к базе данных.
 type StorageContext struct {
 	storage StorageInterface
 }
@@ -29,47 +99,289 @@ var ErrDuplicateOrder = errors.New("duplicate order")
 var ErrDuplicateOrderOtherUser = errors.New("duplicate order other user")
 var ErrOrderNotFound = errors.New("order not found")
 var ErrInsufficientFunds = errors.New("insufficient funds")
+var ErrUserNotFound = errors.New("user not found")
+var ErrCallbackReplayed = errors.New("accrual callback replayed")
+var ErrRefreshTokenInvalid = errors.New("refresh token is invalid, expired or revoked")
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+var ErrResetTokenInvalid = errors.New("password reset token is invalid, expired or used")
+var ErrLeaseLost = errors.New("order lease expired or taken by another worker")
 
 func (sc *StorageContext) SetStorage(storage StorageInterface) {
 	sc.storage = storage
 }
 
 func (sc *StorageContext) UserRegister(ctx context.Context, login string, password string) error {
-	return sc.storage.UserRegister(ctx, login, password)
+	ctx, span := startSpan(ctx, "UserRegister")
+	err := sc.storage.UserRegister(ctx, login, password)
+	endSpan(span, err)
+	return err
 }
 
 func (sc *StorageContext) UserLogin(ctx context.Context, login string, password string) error {
-	return sc.storage.UserLogin(ctx, login, password)
+	ctx, span := startSpan(ctx, "UserLogin")
+	err := sc.storage.UserLogin(ctx, login, password)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) ReserveLoginAttempt(ctx context.Context, key string, at time.Time, window time.Duration, limit int) (bool, time.Time, error) {
+	ctx, span := startSpan(ctx, "ReserveLoginAttempt")
+	reserved, oldest, err := sc.storage.ReserveLoginAttempt(ctx, key, at, window, limit)
+	endSpan(span, err)
+	return reserved, oldest, err
+}
+
+func (sc *StorageContext) ReleaseLoginAttempt(ctx context.Context, key string, at time.Time) error {
+	ctx, span := startSpan(ctx, "ReleaseLoginAttempt")
+	err := sc.storage.ReleaseLoginAttempt(ctx, key, at)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) RecordFailedLogin(ctx context.Context, login string, maxFailures int, lockout time.Duration) (time.Time, error) {
+	ctx, span := startSpan(ctx, "RecordFailedLogin")
+	lockedUntil, err := sc.storage.RecordFailedLogin(ctx, login, maxFailures, lockout)
+	endSpan(span, err)
+	return lockedUntil, err
+}
+
+func (sc *StorageContext) ResetFailedLogins(ctx context.Context, login string) error {
+	ctx, span := startSpan(ctx, "ResetFailedLogins")
+	err := sc.storage.ResetFailedLogins(ctx, login)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) GetUserLockedUntil(ctx context.Context, login string) (time.Time, error) {
+	ctx, span := startSpan(ctx, "GetUserLockedUntil")
+	lockedUntil, err := sc.storage.GetUserLockedUntil(ctx, login)
+	endSpan(span, err)
+	return lockedUntil, err
+}
+
+func (sc *StorageContext) UnlockUser(ctx context.Context, login string) error {
+	ctx, span := startSpan(ctx, "UnlockUser")
+	err := sc.storage.UnlockUser(ctx, login)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) ChangePassword(ctx context.Context, login string, password string) error {
+	ctx, span := startSpan(ctx, "ChangePassword")
+	err := sc.storage.ChangePassword(ctx, login, password)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) CreatePasswordReset(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error {
+	ctx, span := startSpan(ctx, "CreatePasswordReset")
+	err := sc.storage.CreatePasswordReset(ctx, login, tokenHash, expiresAt)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) ResetPassword(ctx context.Context, tokenHash string, password string) (string, error) {
+	ctx, span := startSpan(ctx, "ResetPassword")
+	login, err := sc.storage.ResetPassword(ctx, tokenHash, password)
+	endSpan(span, err)
+	return login, err
+}
+
+func (sc *StorageContext) GetUser(ctx context.Context, login string) (models.UserInfo, error) {
+	ctx, span := startSpan(ctx, "GetUser")
+	user, err := sc.storage.GetUser(ctx, login)
+	endSpan(span, err)
+	return user, err
+}
+
+func (sc *StorageContext) SetUserRole(ctx context.Context, login string, role string) error {
+	ctx, span := startSpan(ctx, "SetUserRole")
+	err := sc.storage.SetUserRole(ctx, login, role)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) GetAuditEvents(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, error) {
+	ctx, span := startSpan(ctx, "GetAuditEvents")
+	events, err := sc.storage.GetAuditEvents(ctx, filter)
+	endSpan(span, err)
+	return events, err
 }
 
 func (sc *StorageContext) UploadUserOrders(ctx context.Context, login string, order int64) error {
-	return sc.storage.UploadUserOrders(ctx, login, order)
+	ctx, span := startSpan(ctx, "UploadUserOrders")
+	err := sc.storage.UploadUserOrders(ctx, login, order)
+	endSpan(span, err)
+	return err
 }
 
 func (sc *StorageContext) GetUserOrders(ctx context.Context, login string) ([]models.StatusOrders, error) {
-	return sc.storage.GetUserOrders(ctx, login)
+	ctx, span := startSpan(ctx, "GetUserOrders")
+	orders, err := sc.storage.GetUserOrders(ctx, login)
+	endSpan(span, err)
+	return orders, err
 }
 
 func (sc *StorageContext) GetUserBalance(ctx context.Context, login string) (models.Balance, error) {
-	return sc.storage.GetUserBalance(ctx, login)
+	ctx, span := startSpan(ctx, "GetUserBalance")
+	balance, err := sc.storage.GetUserBalance(ctx, login)
+	endSpan(span, err)
+	return balance, err
 }
 
-func (sc *StorageContext) UpdateUserBalanceWithdraw(ctx context.Context, login string, order string, sum float64) error {
-	return sc.storage.UpdateUserBalanceWithdraw(ctx, login, order, sum)
+func (sc *StorageContext) UpdateUserBalanceWithdraw(ctx context.Context, login string, order string, sum models.Money) error {
+	ctx, span := startSpan(ctx, "UpdateUserBalanceWithdraw")
+	err := sc.storage.UpdateUserBalanceWithdraw(ctx, login, order, sum)
+	endSpan(span, err)
+	return err
 }
 
 func (sc *StorageContext) GetUserWithdrawals(ctx context.Context, login string) ([]models.BalanceWithdrawals, error) {
-	return sc.storage.GetUserWithdrawals(ctx, login)
+	ctx, span := startSpan(ctx, "GetUserWithdrawals")
+	withdrawals, err := sc.storage.GetUserWithdrawals(ctx, login)
+	endSpan(span, err)
+	return withdrawals, err
 }
 
 func (sc *StorageContext) Ping(ctx context.Context) (exists bool) {
-	return sc.storage.Ping(ctx)
+	ctx, span := startSpan(ctx, "Ping")
+	defer span.End()
+	exists = sc.storage.Ping(ctx)
+	if !exists {
+		span.SetStatus(codes.Error, "database is unreachable")
+	}
+	return exists
 }
 
 func (sc *StorageContext) GetOrdersProcessing(ctx context.Context) ([]int64, error) {
-	return sc.storage.GetOrdersProcessing(ctx)
+	ctx, span := startSpan(ctx, "GetOrdersProcessing")
+	orders, err := sc.storage.GetOrdersProcessing(ctx)
+	endSpan(span, err)
+	return orders, err
+}
+
+func (sc *StorageContext) CountPendingOrders(ctx context.Context) (int, error) {
+	ctx, span := startSpan(ctx, "CountPendingOrders")
+	count, err := sc.storage.CountPendingOrders(ctx)
+	endSpan(span, err)
+	return count, err
+}
+
+func (sc *StorageContext) ClaimOrders(ctx context.Context, worker string, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	ctx, span := startSpan(ctx, "ClaimOrders")
+	jobs, err := sc.storage.ClaimOrders(ctx, worker, limit, lease)
+	endSpan(span, err)
+	return jobs, err
+}
+
+func (sc *StorageContext) RenewOrderLease(ctx context.Context, worker string, order int64, lease time.Duration) error {
+	ctx, span := startSpan(ctx, "RenewOrderLease")
+	err := sc.storage.RenewOrderLease(ctx, worker, order, lease)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) ReleaseOrder(ctx context.Context, worker string, order int64) error {
+	ctx, span := startSpan(ctx, "ReleaseOrder")
+	err := sc.storage.ReleaseOrder(ctx, worker, order)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) RetryOrder(ctx context.Context, worker string, order int64, nextAttemptAt time.Time, reason string) error {
+	ctx, span := startSpan(ctx, "RetryOrder")
+	err := sc.storage.RetryOrder(ctx, worker, order, nextAttemptAt, reason)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) DeadLetterOrder(ctx context.Context, worker string, order int64, reason string) error {
+	ctx, span := startSpan(ctx, "DeadLetterOrder")
+	err := sc.storage.DeadLetterOrder(ctx, worker, order, reason)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) GetDeadLetterOrders(ctx context.Context) ([]models.DeadLetterOrder, error) {
+	ctx, span := startSpan(ctx, "GetDeadLetterOrders")
+	orders, err := sc.storage.GetDeadLetterOrders(ctx)
+	endSpan(span, err)
+	return orders, err
+}
+
+func (sc *StorageContext) RequeueOrder(ctx context.Context, order int64) error {
+	ctx, span := startSpan(ctx, "RequeueOrder")
+	err := sc.storage.RequeueOrder(ctx, order)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) SaveAccrualCallback(ctx context.Context, signature string, expiresAt time.Time) error {
+	ctx, span := startSpan(ctx, "SaveAccrualCallback")
+	err := sc.storage.SaveAccrualCallback(ctx, signature, expiresAt)
+	endSpan(span, err)
+	return err
 }
 
 func (sc *StorageContext) UpdateStatusOrders(ctx context.Context, statusOrder *models.StatusOrdersAccrual) error {
-	return sc.storage.UpdateStatusOrders(ctx, statusOrder)
+	ctx, span := startSpan(ctx, "UpdateStatusOrders")
+	err := sc.storage.UpdateStatusOrders(ctx, statusOrder)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) CreateRefreshToken(ctx context.Context, login string, tokenHash string, expiresAt time.Time) error {
+	ctx, span := startSpan(ctx, "CreateRefreshToken")
+	err := sc.storage.CreateRefreshToken(ctx, login, tokenHash, expiresAt)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) RotateRefreshToken(ctx context.Context, tokenHash string, newHash string, expiresAt time.Time) (string, error) {
+	ctx, span := startSpan(ctx, "RotateRefreshToken")
+	login, err := sc.storage.RotateRefreshToken(ctx, tokenHash, newHash, expiresAt)
+	endSpan(span, err)
+	return login, err
+}
+
+func (sc *StorageContext) RevokeRefreshToken(ctx context.Context, login string, tokenHash string) error {
+	ctx, span := startSpan(ctx, "RevokeRefreshToken")
+	err := sc.storage.RevokeRefreshToken(ctx, login, tokenHash)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, span := startSpan(ctx, "RevokeAccessToken")
+	err := sc.storage.RevokeAccessToken(ctx, jti, expiresAt)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, span := startSpan(ctx, "IsAccessTokenRevoked")
+	revoked, err := sc.storage.IsAccessTokenRevoked(ctx, jti)
+	endSpan(span, err)
+	return revoked, err
+}
+
+func (sc *StorageContext) RefundWithdrawal(ctx context.Context, login string, order string) error {
+	ctx, span := startSpan(ctx, "RefundWithdrawal")
+	err := sc.storage.RefundWithdrawal(ctx, login, order)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) AdjustUserBalance(ctx context.Context, login string, amount models.Money, reason string) error {
+	ctx, span := startSpan(ctx, "AdjustUserBalance")
+	err := sc.storage.AdjustUserBalance(ctx, login, amount, reason)
+	endSpan(span, err)
+	return err
+}
+
+func (sc *StorageContext) GetUserLedger(ctx context.Context, login string) ([]models.LedgerEntry, error) {
+	ctx, span := startSpan(ctx, "GetUserLedger")
+	entries, err := sc.storage.GetUserLedger(ctx, login)
+	endSpan(span, err)
+	return entries, err
 }
@@ -0,0 +1,858 @@
+// Package storetest общий набор тестов, который должна проходить каждая реализация store.StorageInterface.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gophermart/internal/models"
+	"gophermart/internal/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counter делает логины и номера заказов уникальными, чтобы тесты не мешали друг другу в общей базе данных
+var counter atomic.Int64
+
+func uniqueLogin(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), counter.Add(1))
+}
+
+func uniqueOrder() int64 {
+	return time.Now().UnixNano()/1000 + counter.Add(1)
+}
+
+func registerUser(t *testing.T, storage store.StorageInterface, prefix string) string {
+	t.Helper()
+	login := uniqueLogin(prefix)
+	require.NoError(t, storage.UserRegister(context.Background(), login, "password"))
+	return login
+}
+
+// Run запускает набор тестов для хранилища, newStorage вызывается для каждого теста
+func Run(t *testing.T, newStorage func(t *testing.T) store.StorageInterface) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage store.StorageInterface)
+	}{
+		{name: "регистрация и аутентификация", test: testRegisterLogin},
+		{name: "параллельная регистрация", test: testConcurrentRegister},
+		{name: "попытки входа", test: testLoginAttempts},
+		{name: "параллельные попытки входа", test: testLoginAttemptsConcurrent},
+		{name: "блокировка пользователя", test: testUserLockout},
+		{name: "смена пароля", test: testChangePassword},
+		{name: "вход во время смены пароля", test: testLoginDuringPasswordChange},
+		{name: "сброс пароля", test: testPasswordReset},
+		{name: "роли пользователей", test: testUserRoles},
+		{name: "журнал аудита", test: testAuditEvents},
+		{name: "загрузка заказов", test: testUploadOrders},
+		{name: "заказы в обработке", test: testOrdersProcessing},
+		{name: "аренда заказов", test: testClaimOrders},
+		{name: "параллельная аренда заказов", test: testClaimOrdersConcurrent},
+		{name: "продление аренды заказа", test: testRenewOrderLease},
+		{name: "повтор и dead-letter", test: testRetryDeadLetter},
+		{name: "повтор уведомления системы расчёта", test: testAccrualCallbackReplay},
+		{name: "токены обновления", test: testRefreshTokens},
+		{name: "отзыв токенов доступа", test: testRevokeAccessToken},
+		{name: "начисление баллов", test: testAccrual},
+		{name: "повтор ответа системы расчёта", test: testAccrualReplay},
+		{name: "списание баллов", test: testWithdraw},
+		{name: "параллельное списание", test: testWithdrawConcurrent},
+		{name: "возврат и корректировка", test: testRefundAdjust},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStorage(t))
+		})
+	}
+}
+
+func testRegisterLogin(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "register")
+
+	assert.ErrorIs(t, storage.UserRegister(ctx, login, "other"), store.ErrLoginDuplicate)
+	assert.NoError(t, storage.UserLogin(ctx, login, "password"))
+	assert.ErrorIs(t, storage.UserLogin(ctx, login, "wrong"), store.ErrAuthentication)
+	assert.ErrorIs(t, storage.UserLogin(ctx, uniqueLogin("missing"), "password"), store.ErrAuthentication)
+
+	balance, err := storage.GetUserBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, models.Balance{}, balance)
+	assert.True(t, storage.Ping(ctx))
+}
+
+func testConcurrentRegister(t *testing.T, storage store.StorageInterface) {
+	login := uniqueLogin("concurrent")
+	errs := make(chan error, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- storage.UserRegister(context.Background(), login, "password")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	registered := 0
+	for err := range errs {
+		if err == nil {
+			registered++
+			continue
+		}
+		assert.ErrorIs(t, err, store.ErrLoginDuplicate)
+	}
+	assert.Equal(t, 1, registered)
+}
+
+func testLoginAttempts(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	key, other := uniqueLogin("ip:"), uniqueLogin("login:")
+	now := time.Now()
+
+	first := now.Add(-30 * time.Second)
+	for _, at := range []time.Time{now.Add(-90 * time.Second), first, now.Add(-time.Second)} {
+		reserved, _, err := storage.ReserveLoginAttempt(ctx, key, at, time.Hour, 10)
+		require.NoError(t, err)
+		require.True(t, reserved)
+	}
+	reserved, _, err := storage.ReserveLoginAttempt(ctx, other, now, time.Hour, 10)
+	require.NoError(t, err)
+	require.True(t, reserved)
+
+	// попытки до начала окна не учитываются, поэтому в окне минута ещё есть место для одной
+	reserved, _, err = storage.ReserveLoginAttempt(ctx, key, now, time.Minute, 3)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	reserved, oldest, err := storage.ReserveLoginAttempt(ctx, key, now, time.Minute, 3)
+	require.NoError(t, err)
+	assert.False(t, reserved, "лимит исчерпан")
+	assert.WithinDuration(t, first, oldest, time.Millisecond)
+
+	// отменённая попытка освобождает место
+	require.NoError(t, storage.ReleaseLoginAttempt(ctx, key, now))
+	reserved, _, err = storage.ReserveLoginAttempt(ctx, key, now, time.Minute, 3)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	// устаревшие попытки удаляются при учёте новой
+	reserved, _, err = storage.ReserveLoginAttempt(ctx, other, now, 10*time.Second, 10)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	reserved, _, err = storage.ReserveLoginAttempt(ctx, key, now, time.Hour, 3)
+	require.NoError(t, err)
+	assert.True(t, reserved, "попытки старше 10 секунд удалены")
+}
+
+func testLoginAttemptsConcurrent(t *testing.T, storage store.StorageInterface) {
+	key := uniqueLogin("login:")
+	var reserved atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, _, err := storage.ReserveLoginAttempt(context.Background(), key, time.Now(), time.Minute, 5)
+			assert.NoError(t, err)
+			if ok {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(5), reserved.Load(), "параллельные попытки не превышают лимит")
+}
+
+func testUserLockout(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "lockout")
+
+	lockedUntil, err := storage.GetUserLockedUntil(ctx, login)
+	require.NoError(t, err)
+	assert.True(t, lockedUntil.IsZero())
+
+	for i := 0; i < 2; i++ {
+		lockedUntil, err = storage.RecordFailedLogin(ctx, login, 3, time.Minute)
+		require.NoError(t, err)
+		assert.True(t, lockedUntil.IsZero())
+	}
+	// успешный вход сбрасывает счётчик неудачных входов подряд
+	require.NoError(t, storage.ResetFailedLogins(ctx, login))
+	for i := 0; i < 2; i++ {
+		lockedUntil, err = storage.RecordFailedLogin(ctx, login, 3, time.Minute)
+		require.NoError(t, err)
+		assert.True(t, lockedUntil.IsZero())
+	}
+	lockedUntil, err = storage.RecordFailedLogin(ctx, login, 3, time.Minute)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), lockedUntil, 5*time.Second)
+
+	stored, err := storage.GetUserLockedUntil(ctx, login)
+	require.NoError(t, err)
+	assert.WithinDuration(t, lockedUntil, stored, time.Millisecond)
+
+	require.NoError(t, storage.UnlockUser(ctx, login))
+	stored, err = storage.GetUserLockedUntil(ctx, login)
+	require.NoError(t, err)
+	assert.True(t, stored.IsZero())
+
+	missing := uniqueLogin("missing")
+	_, err = storage.RecordFailedLogin(ctx, missing, 3, time.Minute)
+	assert.ErrorIs(t, err, store.ErrUserNotFound)
+	_, err = storage.GetUserLockedUntil(ctx, missing)
+	assert.ErrorIs(t, err, store.ErrUserNotFound)
+	assert.ErrorIs(t, storage.UnlockUser(ctx, missing), store.ErrUserNotFound)
+}
+
+func testChangePassword(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "password")
+	require.NoError(t, storage.CreateRefreshToken(ctx, login, "change-"+login, time.Now().Add(time.Hour)))
+
+	require.NoError(t, storage.ChangePassword(ctx, login, "new password"))
+	assert.ErrorIs(t, storage.UserLogin(ctx, login, "password"), store.ErrAuthentication)
+	assert.NoError(t, storage.UserLogin(ctx, login, "new password"))
+
+	// смена пароля завершает остальные сеансы
+	_, err := storage.RotateRefreshToken(ctx, "change-"+login, "change-next-"+login, time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, store.ErrRefreshTokenInvalid)
+
+	assert.ErrorIs(t, storage.ChangePassword(ctx, uniqueLogin("missing"), "password"), store.ErrUserNotFound)
+}
+
+func testPasswordReset(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "reset")
+	require.NoError(t, storage.CreateRefreshToken(ctx, login, "reset-session-"+login, time.Now().Add(time.Hour)))
+	_, err := storage.RecordFailedLogin(ctx, login, 1, time.Hour)
+	require.NoError(t, err)
+
+	first, second := "reset-first-"+login, "reset-second-"+login
+	require.NoError(t, storage.CreatePasswordReset(ctx, login, first, time.Now().Add(time.Hour)))
+	require.NoError(t, storage.CreatePasswordReset(ctx, login, second, time.Now().Add(time.Hour)))
+
+	_, err = storage.ResetPassword(ctx, first, "new password")
+	assert.ErrorIs(t, err, store.ErrResetTokenInvalid, "новый токен сброса отменяет прежний")
+
+	reset, err := storage.ResetPassword(ctx, second, "new password")
+	require.NoError(t, err)
+	assert.Equal(t, login, reset)
+	assert.NoError(t, storage.UserLogin(ctx, login, "new password"))
+
+	lockedUntil, err := storage.GetUserLockedUntil(ctx, login)
+	require.NoError(t, err)
+	assert.True(t, lockedUntil.IsZero(), "сброс пароля снимает блокировку")
+	_, err = storage.RotateRefreshToken(ctx, "reset-session-"+login, "reset-next-"+login, time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, store.ErrRefreshTokenInvalid)
+
+	_, err = storage.ResetPassword(ctx, second, "other password")
+	assert.ErrorIs(t, err, store.ErrResetTokenInvalid, "токен действует один раз")
+
+	expired := "reset-expired-" + login
+	require.NoError(t, storage.CreatePasswordReset(ctx, login, expired, time.Now().Add(-time.Second)))
+	_, err = storage.ResetPassword(ctx, expired, "other password")
+	assert.ErrorIs(t, err, store.ErrResetTokenInvalid)
+
+	assert.ErrorIs(t, storage.CreatePasswordReset(ctx, uniqueLogin("missing"), "reset-missing", time.Now().Add(time.Hour)),
+		store.ErrUserNotFound)
+}
+
+func testUserRoles(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "role")
+
+	user, err := storage.GetUser(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, login, user.Login)
+	assert.Equal(t, models.RoleUser, user.Role, "новый пользователь — покупатель")
+	assert.WithinDuration(t, time.Now(), user.RegisteredAt, time.Minute)
+	assert.Nil(t, user.LockedUntil)
+
+	require.NoError(t, storage.SetUserRole(ctx, login, models.RoleSupport))
+	_, err = storage.RecordFailedLogin(ctx, login, 1, time.Hour)
+	require.NoError(t, err)
+	user, err = storage.GetUser(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleSupport, user.Role)
+	require.NotNil(t, user.LockedUntil)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *user.LockedUntil, 5*time.Second)
+
+	missing := uniqueLogin("missing")
+	_, err = storage.GetUser(ctx, missing)
+	assert.ErrorIs(t, err, store.ErrUserNotFound)
+	assert.ErrorIs(t, storage.SetUserRole(ctx, missing, models.RoleAdmin), store.ErrUserNotFound)
+}
+
+func testAuditEvents(t *testing.T, storage store.StorageInterface) {
+	ctx := store.WithRequestID(context.Background(), "request-1")
+	login := uniqueLogin("audit")
+	require.NoError(t, storage.UserRegister(ctx, login, "password"))
+	require.NoError(t, storage.UserLogin(ctx, login, "password"))
+	order := uniqueOrder()
+	require.NoError(t, storage.UploadUserOrders(ctx, login, order))
+	number := strconv.FormatInt(order, 10)
+
+	accrualCtx := store.WithActor(context.Background(), store.AuditActorAccrual)
+	require.NoError(t, storage.UpdateStatusOrders(accrualCtx, &models.StatusOrdersAccrual{
+		Order: number, Status: models.OrderStatusProcessed, Accrual: models.MoneyFromKopecks(10000),
+	}))
+
+	// неудачное изменение откатывается вместе со своим событием
+	withdrawal := strconv.FormatInt(uniqueOrder(), 10)
+	assert.ErrorIs(t, storage.UpdateUserBalanceWithdraw(ctx, login, withdrawal, models.MoneyFromKopecks(20000)), store.ErrInsufficientFunds)
+	require.NoError(t, storage.UpdateUserBalanceWithdraw(ctx, login, withdrawal, models.MoneyFromKopecks(3000)))
+
+	adminCtx := store.WithActor(context.Background(), "admin")
+	require.NoError(t, storage.SetUserRole(adminCtx, login, models.RoleSupport))
+
+	events, err := storage.GetAuditEvents(ctx, store.AuditFilter{EntityType: store.AuditEntityUser, EntityID: login})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, store.AuditUserRoleChange, events[0].Action, "новые события первыми")
+	assert.Equal(t, "admin", events[0].Actor)
+	assert.JSONEq(t, `{"role":"user"}`, string(events[0].Before))
+	assert.JSONEq(t, `{"role":"support"}`, string(events[0].After))
+	assert.Empty(t, events[0].RequestID)
+	assert.Equal(t, store.AuditUserLogin, events[1].Action)
+	assert.Equal(t, store.AuditUserRegister, events[2].Action)
+	assert.Equal(t, login, events[2].Actor)
+	assert.Equal(t, "request-1", events[2].RequestID)
+	assert.Nil(t, events[2].Before)
+	assert.WithinDuration(t, time.Now(), events[2].CreatedAt, time.Minute)
+
+	events, err = storage.GetAuditEvents(ctx, store.AuditFilter{EntityType: store.AuditEntityOrder, EntityID: number})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, store.AuditOrderStatus, events[0].Action)
+	assert.Equal(t, store.AuditActorAccrual, events[0].Actor)
+	assert.JSONEq(t, `{"status":"NEW"}`, string(events[0].Before))
+	assert.JSONEq(t, `{"status":"PROCESSED","accrual":100}`, string(events[0].After))
+	assert.Equal(t, store.AuditOrderUpload, events[1].Action)
+
+	events, err = storage.GetAuditEvents(ctx, store.AuditFilter{Action: store.AuditWithdrawal, EntityID: withdrawal})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, login, events[0].Actor)
+	assert.JSONEq(t, `{"current":100}`, string(events[0].Before))
+	assert.JSONEq(t, `{"current":70,"sum":30}`, string(events[0].After))
+
+	// постраничный просмотр
+	events, err = storage.GetAuditEvents(ctx, store.AuditFilter{Actor: login, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, store.AuditWithdrawal, events[0].Action)
+	assert.Equal(t, store.AuditOrderUpload, events[1].Action)
+	events, err = storage.GetAuditEvents(ctx, store.AuditFilter{Actor: login, BeforeID: events[1].ID})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, store.AuditUserLogin, events[0].Action)
+	assert.Equal(t, store.AuditUserRegister, events[1].Action)
+}
+
+func testUploadOrders(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "orders")
+	other := registerUser(t, storage, "orders-other")
+
+	orders, err := storage.GetUserOrders(ctx, login)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+
+	first, second := uniqueOrder(), uniqueOrder()
+	require.NoError(t, storage.UploadUserOrders(ctx, login, first))
+	require.NoError(t, storage.UploadUserOrders(ctx, login, second))
+	assert.ErrorIs(t, storage.UploadUserOrders(ctx, login, first), store.ErrDuplicateOrder)
+	assert.ErrorIs(t, storage.UploadUserOrders(ctx, other, first), store.ErrDuplicateOrderOtherUser)
+	assert.ErrorIs(t, storage.UploadUserOrders(ctx, uniqueLogin("missing"), uniqueOrder()), store.ErrUserNotFound)
+
+	orders, err = storage.GetUserOrders(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	assert.Equal(t, strconv.FormatInt(second, 10), orders[0].Number, "новые заказы идут первыми")
+	assert.Equal(t, strconv.FormatInt(first, 10), orders[1].Number)
+	assert.Equal(t, models.OrderStatusNew, orders[0].Status)
+	assert.Equal(t, models.Money(0), orders[0].Accrual)
+	assert.False(t, orders[0].UploadedAt.IsZero())
+
+	orders, err = storage.GetUserOrders(ctx, other)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+}
+
+func testOrdersProcessing(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "processing")
+	pending, err := storage.CountPendingOrders(ctx)
+	require.NoError(t, err)
+
+	fresh, processing, processed := uniqueOrder(), uniqueOrder(), uniqueOrder()
+	for _, number := range []int64{fresh, processing, processed} {
+		require.NoError(t, storage.UploadUserOrders(ctx, login, number))
+	}
+	require.NoError(t, storage.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: strconv.FormatInt(processing, 10), Status: models.AccrualStatusRegistered}))
+	require.NoError(t, storage.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: strconv.FormatInt(processed, 10), Status: models.OrderStatusProcessed}))
+
+	numbers, err := storage.GetOrdersProcessing(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, numbers, fresh)
+	assert.Contains(t, numbers, processing)
+	assert.NotContains(t, numbers, processed)
+
+	count, err := storage.CountPendingOrders(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pending+2, count, "рассчитанный заказ не ждёт опроса")
+
+	err = storage.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: strconv.FormatInt(uniqueOrder(), 10), Status: models.OrderStatusProcessed})
+	assert.ErrorIs(t, err, store.ErrOrderNotFound)
+}
+
+// claimLimit с запасом покрывает заказы других тестов в общей базе данных
+const claimLimit = 100000
+
+// claim арендует заказы и возвращает их номера
+func claim(t *testing.T, storage store.StorageInterface, worker string, limit int, lease time.Duration) []int64 {
+	t.Helper()
+	jobs, err := storage.ClaimOrders(context.Background(), worker, limit, lease)
+	require.NoError(t, err)
+	numbers := make([]int64, 0, len(jobs))
+	for _, job := range jobs {
+		numbers = append(numbers, job.Number)
+	}
+	return numbers
+}
+
+func testClaimOrders(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "claim")
+
+	fresh, processed := uniqueOrder(), uniqueOrder()
+	require.NoError(t, storage.UploadUserOrders(ctx, login, fresh))
+	require.NoError(t, storage.UploadUserOrders(ctx, login, processed))
+	require.NoError(t, storage.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: strconv.FormatInt(processed, 10), Status: models.OrderStatusProcessed}))
+
+	first, second := uniqueLogin("worker"), uniqueLogin("worker")
+	claimed := claim(t, storage, first, claimLimit, time.Minute)
+	assert.Contains(t, claimed, fresh)
+	assert.NotContains(t, claimed, processed)
+
+	assert.NotContains(t, claim(t, storage, second, claimLimit, time.Minute), fresh, "арендованный заказ не выдаётся другому воркеру")
+
+	require.NoError(t, storage.ReleaseOrder(ctx, second, fresh))
+	assert.NotContains(t, claim(t, storage, second, claimLimit, time.Minute), fresh, "чужую аренду снять нельзя")
+
+	require.NoError(t, storage.ReleaseOrder(ctx, first, fresh))
+	assert.Contains(t, claim(t, storage, second, claimLimit, 50*time.Millisecond), fresh, "снятая аренда возвращает заказ в работу")
+
+	// воркер упал и не снял аренду, после её истечения заказ достаётся другому
+	time.Sleep(200 * time.Millisecond)
+	assert.Contains(t, claim(t, storage, first, claimLimit, time.Minute), fresh)
+}
+
+func testRenewOrderLease(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "renew")
+	number := uniqueOrder()
+	require.NoError(t, storage.UploadUserOrders(ctx, login, number))
+
+	first, second := uniqueLogin("worker"), uniqueLogin("worker")
+	require.Contains(t, claim(t, storage, first, claimLimit, 100*time.Millisecond), number)
+	assert.ErrorIs(t, storage.RenewOrderLease(ctx, second, number, time.Minute), store.ErrLeaseLost, "чужую аренду продлить нельзя")
+	require.NoError(t, storage.RenewOrderLease(ctx, first, number, time.Minute))
+
+	time.Sleep(200 * time.Millisecond)
+	assert.NotContains(t, claim(t, storage, second, claimLimit, time.Minute), number, "продлённая аренда не истекает")
+
+	require.NoError(t, storage.ReleaseOrder(ctx, first, number))
+	require.Contains(t, claim(t, storage, first, claimLimit, 50*time.Millisecond), number)
+	time.Sleep(200 * time.Millisecond)
+	assert.ErrorIs(t, storage.RenewOrderLease(ctx, first, number, time.Minute), store.ErrLeaseLost, "истёкшая аренда не продлевается")
+	assert.ErrorIs(t, storage.RenewOrderLease(ctx, first, uniqueOrder(), time.Minute), store.ErrLeaseLost)
+}
+
+func testClaimOrdersConcurrent(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "claim-concurrent")
+
+	const orders, workers = 30, 6
+	mine := make(map[int64]bool, orders)
+	for i := 0; i < orders; i++ {
+		number := uniqueOrder()
+		mine[number] = true
+		require.NoError(t, storage.UploadUserOrders(ctx, login, number))
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	owners := make(map[int64]string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			for {
+				claimed, err := storage.ClaimOrders(ctx, worker, 3, time.Minute)
+				if !assert.NoError(t, err) || len(claimed) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, job := range claimed {
+					number := job.Number
+					if owner, ok := owners[number]; ok {
+						t.Errorf("заказ %d арендован воркерами %s и %s", number, owner, worker)
+					}
+					owners[number] = worker
+				}
+				mu.Unlock()
+			}
+		}(uniqueLogin("worker"))
+	}
+	wg.Wait()
+
+	for number := range mine {
+		assert.Contains(t, owners, number)
+	}
+}
+
+func testRetryDeadLetter(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "dead-letter")
+	worker, other := uniqueLogin("worker"), uniqueLogin("worker")
+
+	delayed, failing := uniqueOrder(), uniqueOrder()
+	require.NoError(t, storage.UploadUserOrders(ctx, login, delayed))
+	require.NoError(t, storage.UploadUserOrders(ctx, login, failing))
+
+	job := findJob(t, storage, worker, delayed)
+	assert.Equal(t, 0, job.Attempts)
+	assert.False(t, job.QueuedAt.IsZero())
+
+	// отложенный заказ не арендуется и не считается ожидающим опроса до next_attempt_at
+	require.NoError(t, storage.RetryOrder(ctx, worker, delayed, time.Now().Add(time.Hour), "no content"))
+	assert.NotContains(t, claim(t, storage, worker, claimLimit, time.Minute), delayed)
+	numbers, err := storage.GetOrdersProcessing(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, numbers, delayed)
+	pending, err := storage.CountPendingOrders(ctx)
+	require.NoError(t, err)
+	assert.ErrorIs(t, storage.RequeueOrder(ctx, delayed), store.ErrOrderNotFound, "отложенный заказ не в dead-letter")
+
+	require.NoError(t, storage.ReleaseOrder(ctx, worker, failing))
+	findJob(t, storage, worker, failing)
+	require.NoError(t, storage.RetryOrder(ctx, other, failing, time.Now().Add(-time.Second), "чужая аренда"))
+	require.NoError(t, storage.RetryOrder(ctx, worker, failing, time.Now().Add(-time.Second), "internal server error"))
+	job = findJob(t, storage, worker, failing)
+	assert.Equal(t, 1, job.Attempts, "неудачу учитывает только арендатор")
+
+	require.NoError(t, storage.DeadLetterOrder(ctx, worker, failing, "max age exceeded"))
+	assert.NotContains(t, claim(t, storage, worker, claimLimit, time.Minute), failing)
+	count, err := storage.CountPendingOrders(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pending-1, count, "заказ в dead-letter не ждёт опроса, отложенный — ждёт")
+
+	deadLetters, err := storage.GetDeadLetterOrders(ctx)
+	require.NoError(t, err)
+	var found *models.DeadLetterOrder
+	for i := range deadLetters {
+		if deadLetters[i].Number == strconv.FormatInt(failing, 10) {
+			found = &deadLetters[i]
+		}
+	}
+	require.NotNil(t, found)
+	assert.Equal(t, login, found.Login)
+	assert.Equal(t, models.OrderStatusNew, found.Status)
+	assert.Equal(t, 2, found.Attempts)
+	assert.Equal(t, "max age exceeded", found.LastError)
+	assert.False(t, found.DeadLetteredAt.IsZero())
+
+	requeued := time.Now()
+	require.NoError(t, storage.RequeueOrder(ctx, failing))
+	assert.ErrorIs(t, storage.RequeueOrder(ctx, failing), store.ErrOrderNotFound)
+	job = findJob(t, storage, other, failing)
+	assert.Equal(t, 0, job.Attempts)
+	assert.WithinDuration(t, requeued, job.QueuedAt, 5*time.Second, "срок жизни возвращённого заказа отсчитывается заново")
+}
+
+// findJob арендует заказы и возвращает задание по заказу number
+func findJob(t *testing.T, storage store.StorageInterface, worker string, number int64) models.AccrualJob {
+	t.Helper()
+	jobs, err := storage.ClaimOrders(context.Background(), worker, claimLimit, time.Minute)
+	require.NoError(t, err)
+	for _, job := range jobs {
+		if job.Number == number {
+			return job
+		}
+	}
+	require.Failf(t, "заказ не арендован", "%d", number)
+	return models.AccrualJob{}
+}
+
+func testAccrualCallbackReplay(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	signature, expired := uniqueLogin("signature"), uniqueLogin("signature")
+
+	require.NoError(t, storage.SaveAccrualCallback(ctx, signature, time.Now().Add(time.Minute)))
+	assert.ErrorIs(t, storage.SaveAccrualCallback(ctx, signature, time.Now().Add(time.Minute)), store.ErrCallbackReplayed)
+
+	// подпись с истёкшим сроком хранения удаляется и может быть принята снова
+	require.NoError(t, storage.SaveAccrualCallback(ctx, expired, time.Now().Add(-time.Second)))
+	assert.NoError(t, storage.SaveAccrualCallback(ctx, expired, time.Now().Add(time.Minute)))
+}
+
+func testRefreshTokens(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "refresh")
+	expiresAt := time.Now().Add(time.Hour)
+	first, second, third := uniqueLogin("hash"), uniqueLogin("hash"), uniqueLogin("hash")
+
+	assert.ErrorIs(t, storage.CreateRefreshToken(ctx, uniqueLogin("missing"), first, expiresAt), store.ErrUserNotFound)
+	require.NoError(t, storage.CreateRefreshToken(ctx, login, first, expiresAt))
+
+	rotated, err := storage.RotateRefreshToken(ctx, first, second, expiresAt)
+	require.NoError(t, err)
+	assert.Equal(t, login, rotated)
+
+	// повтор заменённого токена отзывает всю цепочку, включая выданный взамен
+	_, err = storage.RotateRefreshToken(ctx, first, third, expiresAt)
+	assert.ErrorIs(t, err, store.ErrRefreshTokenReused)
+	_, err = storage.RotateRefreshToken(ctx, second, third, expiresAt)
+	assert.ErrorIs(t, err, store.ErrRefreshTokenInvalid)
+	_, err = storage.RotateRefreshToken(ctx, uniqueLogin("hash"), third, expiresAt)
+	assert.ErrorIs(t, err, store.ErrRefreshTokenInvalid)
+
+	expired := uniqueLogin("hash")
+	require.NoError(t, storage.CreateRefreshToken(ctx, login, expired, time.Now().Add(-time.Second)))
+	_, err = storage.RotateRefreshToken(ctx, expired, third, expiresAt)
+	assert.ErrorIs(t, err, store.ErrRefreshTokenInvalid)
+
+	// выход отзывает цепочку только своего токена
+	session := uniqueLogin("hash")
+	require.NoError(t, storage.CreateRefreshToken(ctx, login, session, expiresAt))
+	other := registerUser(t, storage, "refresh")
+	assert.ErrorIs(t, storage.RevokeRefreshToken(ctx, other, session), store.ErrRefreshTokenInvalid)
+	require.NoError(t, storage.RevokeRefreshToken(ctx, login, session))
+	assert.ErrorIs(t, storage.RevokeRefreshToken(ctx, login, session), store.ErrRefreshTokenInvalid)
+	_, err = storage.RotateRefreshToken(ctx, session, third, expiresAt)
+	assert.ErrorIs(t, err, store.ErrRefreshTokenInvalid)
+}
+
+func testRevokeAccessToken(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	jti := uniqueLogin("jti")
+
+	revoked, err := storage.IsAccessTokenRevoked(ctx, jti)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	require.NoError(t, storage.RevokeAccessToken(ctx, jti, time.Now().Add(time.Hour)))
+	require.NoError(t, storage.RevokeAccessToken(ctx, jti, time.Now().Add(time.Hour)))
+	revoked, err = storage.IsAccessTokenRevoked(ctx, jti)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func testAccrual(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "accrual")
+
+	credited, invalid := uniqueOrder(), uniqueOrder()
+	require.NoError(t, storage.UploadUserOrders(ctx, login, credited))
+	require.NoError(t, storage.UploadUserOrders(ctx, login, invalid))
+
+	require.NoError(t, storage.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: strconv.FormatInt(credited, 10), Status: models.OrderStatusProcessed, Accrual: 72998}))
+	require.NoError(t, storage.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: strconv.FormatInt(invalid, 10), Status: models.OrderStatusInvalid}))
+
+	orders, err := storage.GetUserOrders(ctx, login)
+	require.NoError(t, err)
+	statuses := make(map[string]models.StatusOrders)
+	for _, order := range orders {
+		statuses[order.Number] = order
+	}
+	assert.Equal(t, models.OrderStatusProcessed, statuses[strconv.FormatInt(credited, 10)].Status)
+	assert.Equal(t, models.Money(72998), statuses[strconv.FormatInt(credited, 10)].Accrual)
+	assert.Equal(t, models.OrderStatusInvalid, statuses[strconv.FormatInt(invalid, 10)].Status)
+
+	balance, err := storage.GetUserBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, models.Balance{Current: 72998}, balance)
+}
+
+func testAccrualReplay(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "replay")
+
+	number := uniqueOrder()
+	require.NoError(t, storage.UploadUserOrders(ctx, login, number))
+
+	order := strconv.FormatInt(number, 10)
+	responses := []models.StatusOrdersAccrual{
+		{Order: order, Status: models.AccrualStatusRegistered},
+		{Order: order, Status: models.OrderStatusProcessing},
+		{Order: order, Status: models.OrderStatusProcessed, Accrual: 72998},
+		{Order: order, Status: models.OrderStatusProcessed, Accrual: 72998},
+		{Order: order, Status: models.OrderStatusProcessing},
+		{Order: order, Status: models.OrderStatusInvalid},
+	}
+	for _, response := range responses {
+		response := response
+		require.NoError(t, storage.UpdateStatusOrders(ctx, &response))
+	}
+
+	// параллельный повтор того же ответа тоже не должен начислить баллы повторно
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, storage.UpdateStatusOrders(ctx, &models.StatusOrdersAccrual{Order: order, Status: models.OrderStatusProcessed, Accrual: 72998}))
+		}()
+	}
+	wg.Wait()
+
+	balance, err := storage.GetUserBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(72998), balance.Current)
+
+	orders, err := storage.GetUserOrders(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, models.OrderStatusProcessed, orders[0].Status)
+	assert.Equal(t, models.Money(72998), orders[0].Accrual)
+
+	entries, err := storage.GetUserLedger(ctx, login)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func testWithdraw(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "withdraw")
+	require.NoError(t, storage.AdjustUserBalance(ctx, login, 10000, "test"))
+
+	withdrawals, err := storage.GetUserWithdrawals(ctx, login)
+	require.NoError(t, err)
+	assert.Empty(t, withdrawals)
+
+	first, second := strconv.FormatInt(uniqueOrder(), 10), strconv.FormatInt(uniqueOrder(), 10)
+	require.NoError(t, storage.UpdateUserBalanceWithdraw(ctx, login, first, 2550))
+	require.NoError(t, storage.UpdateUserBalanceWithdraw(ctx, login, second, 1000))
+	assert.ErrorIs(t, storage.UpdateUserBalanceWithdraw(ctx, login, strconv.FormatInt(uniqueOrder(), 10), 6451), store.ErrInsufficientFunds)
+	assert.ErrorIs(t, storage.UpdateUserBalanceWithdraw(ctx, login, first, 1), store.ErrDuplicateOrder)
+	// отрицательное списание пополнило бы баланс, нулевое не меняет его
+	assert.ErrorIs(t, storage.UpdateUserBalanceWithdraw(ctx, login, strconv.FormatInt(uniqueOrder(), 10), -1000), store.ErrLedgerWrongSign)
+	assert.ErrorIs(t, storage.UpdateUserBalanceWithdraw(ctx, login, strconv.FormatInt(uniqueOrder(), 10), 0), store.ErrLedgerZeroAmount)
+
+	balance, err := storage.GetUserBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, models.Balance{Current: 6450, Withdrawn: 3550}, balance)
+
+	withdrawals, err = storage.GetUserWithdrawals(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 2)
+	assert.Equal(t, second, withdrawals[0].Order, "новые списания идут первыми")
+	assert.Equal(t, models.Money(1000), withdrawals[0].Sum)
+	assert.Equal(t, first, withdrawals[1].Order)
+	assert.False(t, withdrawals[1].ProcessedAt.IsZero())
+}
+
+func testLoginDuringPasswordChange(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "change")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			err := storage.UserLogin(ctx, login, "password")
+			if err != nil && !errors.Is(err, store.ErrAuthentication) {
+				t.Errorf("неожиданная ошибка: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, storage.ChangePassword(ctx, login, "password"))
+		}
+	}()
+	wg.Wait()
+
+	assert.NoError(t, storage.UserLogin(ctx, login, "password"))
+}
+
+func testWithdrawConcurrent(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "concurrent")
+	require.NoError(t, storage.AdjustUserBalance(ctx, login, 10000, "test"))
+
+	const attempts = 20
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, insufficient := 0, 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(order string) {
+			defer wg.Done()
+			err := storage.UpdateUserBalanceWithdraw(ctx, login, order, 1000)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, store.ErrInsufficientFunds):
+				insufficient++
+			default:
+				t.Errorf("неожиданная ошибка: %v", err)
+			}
+		}(strconv.FormatInt(uniqueOrder(), 10))
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, succeeded)
+	assert.Equal(t, attempts-10, insufficient)
+
+	balance, err := storage.GetUserBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, models.Balance{Current: 0, Withdrawn: 10000}, balance)
+}
+
+func testRefundAdjust(t *testing.T, storage store.StorageInterface) {
+	ctx := context.Background()
+	login := registerUser(t, storage, "ledger")
+	require.NoError(t, storage.AdjustUserBalance(ctx, login, 50000, "test"))
+
+	order := strconv.FormatInt(uniqueOrder(), 10)
+	require.NoError(t, storage.UpdateUserBalanceWithdraw(ctx, login, order, 12345))
+	require.NoError(t, storage.RefundWithdrawal(ctx, login, order))
+	assert.ErrorIs(t, storage.RefundWithdrawal(ctx, login, order), store.ErrAlreadyRefunded)
+	assert.ErrorIs(t, storage.RefundWithdrawal(ctx, login, strconv.FormatInt(uniqueOrder(), 10)), store.ErrOrderNotFound)
+	assert.ErrorIs(t, storage.AdjustUserBalance(ctx, login, -60000, "test"), store.ErrInsufficientFunds)
+	assert.ErrorIs(t, storage.AdjustUserBalance(ctx, uniqueLogin("missing"), 100, "test"), store.ErrUserNotFound)
+
+	entries, err := storage.GetUserLedger(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+	for _, entry := range entries {
+		assert.NotZero(t, entry.TransactionID)
+		assert.False(t, entry.CreatedAt.IsZero())
+	}
+	assert.Equal(t, store.LedgerKindAdjustment, entries[0].Kind)
+	assert.Equal(t, "test", entries[0].Reason)
+	assert.Equal(t, store.LedgerKindWithdrawal, entries[2].Kind)
+	assert.Equal(t, order, entries[2].Order)
+	assert.Equal(t, store.LedgerKindRefund, entries[4].Kind)
+
+	balance, err := storage.GetUserBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, store.BalanceFromLedger(entries), balance)
+	assert.Equal(t, models.Balance{Current: 50000, Withdrawn: 0}, balance)
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("gophermart/internal/store")
+
+// expectedErrors ответы хранилища о состоянии данных, а не сбои: span с такой ошибкой не помечается ошибочным
+var expectedErrors = []error{
+	ErrLoginDuplicate,
+	ErrAuthentication,
+	ErrDuplicateOrder,
+	ErrDuplicateOrderOtherUser,
+	ErrOrderNotFound,
+	ErrInsufficientFunds,
+	ErrUserNotFound,
+	ErrCallbackReplayed,
+	ErrRefreshTokenInvalid,
+	ErrRefreshTokenReused,
+	ErrResetTokenInvalid,
+	ErrLeaseLost,
+}
+
+// startSpan начинает span метода хранилища, запросы к базе данных становятся его дочерними span'ами
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "store."+method)
+}
+
+// endSpan завершает span метода хранилища с ошибкой err
+func endSpan(span trace.Span, err error) {
+	defer span.End()
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	for _, expected := range expectedErrors {
+		if errors.Is(err, expected) {
+			return
+		}
+	}
+	span.SetStatus(codes.Error, err.Error())
+}
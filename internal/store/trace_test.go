@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gophermart/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingStorage хранилище, методы которого возвращают заданную ошибку
+type failingStorage struct {
+	StorageInterface
+	err error
+}
+
+func (s failingStorage) UploadUserOrders(ctx context.Context, login string, order int64) error {
+	return s.err
+}
+
+func (s failingStorage) GetUserBalance(ctx context.Context, login string) (models.Balance, error) {
+	return models.Balance{}, s.err
+}
+
+func TestStorageContextSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx := context.Background()
+	storage := &StorageContext{}
+	storage.SetStorage(failingStorage{err: ErrDuplicateOrder})
+	_ = storage.UploadUserOrders(ctx, "user", 12345678903)
+	storage.SetStorage(failingStorage{err: errors.New("connection refused")})
+	_, _ = storage.GetUserBalance(ctx, "user")
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "store.UploadUserOrders", spans[0].Name())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code, "повтор заказа — ответ хранилища, а не сбой")
+	assert.Len(t, spans[0].Events(), 1, "ошибка записана в span")
+	assert.Equal(t, "store.GetUserBalance", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
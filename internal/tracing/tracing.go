@@ -0,0 +1,84 @@
+// Package tracing трассировка сервиса в OpenTelemetry.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Экспортёры span'ов, которые выбираются TRACING_EXPORTER
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// ServiceName имя сервиса в ресурсе трассировки, если не задано OTEL_SERVICE_NAME
+const ServiceName = "gophermart"
+
+var ErrUnknownExporter = errors.New("unknown tracing exporter")
+
+// Config настройки трассировки
+type Config struct {
+	// Exporter экспортёр span'ов: none, stdout или otlp
+	Exporter string
+	// Endpoint адрес коллектора OTLP/HTTP, например http://localhost:4318.
+	// Пустой адрес берётся из OTEL_EXPORTER_OTLP_ENDPOINT или равен https://localhost:4318.
+	Endpoint string
+	// SampleRatio доля трассируемых запросов, у которых нет родительского span'а
+	SampleRatio float64
+}
+
+// Init настраивает глобальный провайдер трассировки и распространение контекста W3C Trace Context.
+// Контекст распространяется и без экспортёра, чтобы не обрывать трассы вызывающих сервисов.
+// Возвращённая функция выгружает накопленные span'ы и останавливает экспортёр.
+func Init(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExporter, cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME из окружения важнее имени по умолчанию
+	res, err = resource.Merge(res, resource.Environment())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}